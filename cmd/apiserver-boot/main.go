@@ -21,9 +21,14 @@ import (
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/create"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/doctor"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/etcd"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/init_repo"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/migrate"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/proxy"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/run"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/show"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/verify"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/version"
 )
 
@@ -35,6 +40,11 @@ func main() {
 	run.AddRun(cmd)
 	version.AddVersion(cmd)
 	show.AddShow(cmd)
+	verify.AddVerify(cmd)
+	migrate.AddMigrate(cmd)
+	etcd.AddEtcd(cmd)
+	doctor.AddDoctor(cmd)
+	proxy.AddProxy(cmd)
 
 	if err := cmd.Execute(); err != nil {
 		klog.Fatal(err)
@@ -58,6 +68,9 @@ apiserver-boot build executables
 # Requires generated code was already built by "build executables" or "build generated"
 go test ./pkg/...
 
+# Check the apis for changes breaking the clients since the last release
+apiserver-boot verify api-compat --base v1.0.0
+
 # Run locally by starting a local etcd, apiserver and controller-manager
 # Produces a kubeconfig to talk to the local server
 apiserver-boot run local
@@ -65,10 +78,14 @@ apiserver-boot run local
 # Check the api versions of the locally running server
 kubectl --kubeconfig kubeconfig api-versions
 
-# Build an image and run in a cluster in the default namespace
-# Note: after running this you should clear the discovery service
-# cache before running kubectl with "rm -rf ~/.kube/cache/discovery/"
-apiserver-boot run in-cluster --name creatures --namespace default --image repo/name:tag`,
+# Build an image and run in a cluster in the default namespace, waiting until it's available
+apiserver-boot run in-cluster --name creatures --namespace default --image repo/name:tag
+
+# Diagnose why the APIServices of a group aren't available in the cluster
+apiserver-boot doctor insect.example.com
+
+# Expose a replica of the aggregated apiserver locally, with a temporary kubeconfig for it
+apiserver-boot proxy --service default/creatures`,
 	Run: RunMain,
 }
 
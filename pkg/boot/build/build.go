@@ -32,6 +32,15 @@ apiserver-boot build container --image gcr.io/myrepo/myimage:mytag
 
 # Build resource config for running an aggregated apiserver in cluster
 apiserver-boot build config --name nameofservice --namespace mysystemnamespace --image gcr.io/myrepo/myimage:mytag
+
+# Write the OpenAPI v2 and v3 specs into docs/openapi-spec
+apiserver-boot build openapi
+
+# Write CustomResourceDefinitions for the resources into config/crd/bases
+apiserver-boot build crds
+
+# Generate the typed clientset, listers and informers into pkg/client
+apiserver-boot build clients
 	`,
 	Run: RunBuild,
 }
@@ -43,6 +52,9 @@ func AddBuild(cmd *cobra.Command) {
 	AddBuildContainer(buildCmd)
 	AddBuildResourceConfig(buildCmd)
 	AddDocs(buildCmd)
+	AddBuildOpenAPI(buildCmd)
+	AddBuildCRDs(buildCmd)
+	AddBuildClients(buildCmd)
 }
 
 func RunBuild(cmd *cobra.Command, args []string) {
@@ -0,0 +1,277 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+var codeGeneratorVersion string
+var clientsHeaderFile string
+
+var buildClientsCmd = &cobra.Command{
+	Use:   "clients",
+	Short: "Generate the typed clientset, listers, informers and apply configurations.",
+	Long: `Generate the typed clientset, listers, informers and apply configurations.
+
+Runs client-gen, lister-gen, informer-gen and applyconfiguration-gen from k8s.io/code-generator
+over every versioned package under pkg/apis, and writes the generated code into:
+- pkg/client/clientset/versioned
+- pkg/client/listers
+- pkg/client/informers/externalversions
+- pkg/client/applyconfiguration
+
+Clients are generated for the types marked with "// +genclient", and the types additionally
+marked with "// +genclient:nonNamespaced" get cluster scoped clients.  The markers are checked
+against the NamespaceScoped() of the resources, as clients of the wrong scope send the requests
+to paths which the apiserver doesn't serve.
+
+The generators are looked up in the PATH, and installed with "go install" if missing.`,
+	Example: `# Generate the clients into pkg/client
+apiserver-boot build clients
+
+# Use the generators of another kubernetes release
+apiserver-boot build clients --code-generator-version v0.23.0`,
+	Run: RunBuildClients,
+}
+
+func AddBuildClients(cmd *cobra.Command) {
+	cmd.AddCommand(buildClientsCmd)
+	buildClientsCmd.Flags().StringVar(&codeGeneratorVersion, "code-generator-version", "v0.23.5", "version of k8s.io/code-generator to install if the generators are missing")
+	buildClientsCmd.Flags().StringVar(&clientsHeaderFile, "go-header-file", "", "boilerplate header of the generated files, defaults to boilerplate.go.txt or hack/boilerplate.go.txt")
+}
+
+func RunBuildClients(cmd *cobra.Command, args []string) {
+	initApis()
+	if len(versionedAPIs) == 0 {
+		klog.Fatal("could not find any api versions under pkg/apis")
+	}
+	for _, a := range versionedAPIs {
+		checkClientInputs(filepath.Join("pkg", "apis", a))
+	}
+
+	repo := util.GetRepo()
+	var inputs []string
+	for _, a := range versionedAPIs {
+		inputs = append(inputs, path.Join(repo, "pkg", "apis", filepath.ToSlash(a)))
+	}
+	clientPkg := path.Join(repo, "pkg", "client")
+
+	header := clientsHeaderFile
+	if len(header) == 0 {
+		for _, f := range []string{"boilerplate.go.txt", filepath.Join("hack", "boilerplate.go.txt")} {
+			if _, err := os.Stat(f); err == nil {
+				header = f
+				break
+			}
+		}
+	}
+	if len(header) == 0 {
+		f, err := ioutil.TempFile("", "boilerplate")
+		if err != nil {
+			klog.Fatal(err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		header = f.Name()
+	}
+
+	// the generators write the packages under <output-base>/<package path>, so
+	// generate into a temporary directory and move the packages into pkg/client
+	outputBase, err := ioutil.TempDir("", "apiserver-boot-clients-")
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer os.RemoveAll(outputBase)
+
+	common := []string{"--go-header-file", header, "--output-base", outputBase}
+	// the apply configurations of the ObjectMeta fields, e.g. OwnerReference, are only known to
+	// the generator for its input packages, and --external-applyconfigurations can't express
+	// the k8s.io packages, so generate the meta/v1 apply configurations alongside the apis.
+	runCodeGenerator("applyconfiguration-gen", append(common,
+		"--input-dirs", strings.Join(append(inputs, "k8s.io/apimachinery/pkg/apis/meta/v1"), ","),
+		"--output-package", clientPkg+"/applyconfiguration")...)
+	runCodeGenerator("client-gen", append(common,
+		"--clientset-name", "versioned",
+		"--input-base", "",
+		"--input", strings.Join(inputs, ","),
+		"--apply-configuration-package", clientPkg+"/applyconfiguration",
+		"--output-package", clientPkg+"/clientset")...)
+	runCodeGenerator("lister-gen", append(common,
+		"--input-dirs", strings.Join(inputs, ","),
+		"--output-package", clientPkg+"/listers")...)
+	runCodeGenerator("informer-gen", append(common,
+		"--input-dirs", strings.Join(inputs, ","),
+		"--versioned-clientset-package", clientPkg+"/clientset/versioned",
+		"--listers-package", clientPkg+"/listers",
+		"--output-package", clientPkg+"/informers")...)
+
+	generated := filepath.Join(outputBase, filepath.FromSlash(clientPkg))
+	for _, dir := range []string{"applyconfiguration", "clientset", "listers", "informers"} {
+		target := filepath.Join("pkg", "client", dir)
+		os.RemoveAll(target)
+		os.MkdirAll(filepath.Dir(target), 0700)
+		if err := os.Rename(filepath.Join(generated, dir), target); err != nil {
+			// e.g. the temporary directory is on another device
+			util.DoCmd("cp", "-r", filepath.Join(generated, dir), target)
+		}
+		klog.Infof("wrote %s", target)
+	}
+}
+
+// runCodeGenerator runs a generator of k8s.io/code-generator, installing it first if it isn't
+// in the PATH.
+func runCodeGenerator(name string, args ...string) {
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		util.DoCmd("go", "install", fmt.Sprintf("k8s.io/code-generator/cmd/%s@%s", name, codeGeneratorVersion))
+		bin = filepath.Join(goBinDir(), name)
+	}
+	util.DoCmd(bin, args...)
+}
+
+func goBinDir() string {
+	out, err := exec.Command("go", "env", "GOBIN").Output()
+	if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		return strings.TrimSpace(string(out))
+	}
+	out, err = exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		klog.Fatalf("failed finding GOPATH: %v", err)
+	}
+	return filepath.Join(filepath.SplitList(strings.TrimSpace(string(out)))[0], "bin")
+}
+
+// checkClientInputs verifies the package declares SchemeGroupVersion and Resource, which the
+// generated clients and listers refer to, and that the "+genclient:nonNamespaced" markers of
+// the types agree with their NamespaceScoped() methods.
+func checkClientInputs(dir string) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		klog.Fatalf("failed parsing %s: %v", dir, err)
+	}
+	for _, pkg := range pkgs {
+		markers := map[string][]string{}
+		scoped := map[string]bool{}
+		for _, name := range []string{"SchemeGroupVersion", "Resource"} {
+			found := false
+			for _, f := range pkg.Files {
+				found = found || f.Scope.Lookup(name) != nil
+			}
+			if !found {
+				klog.Fatalf("%s doesn't declare %s, which the generated clients use, "+
+					"see the register.go created by \"apiserver-boot create version\"", dir, name)
+			}
+		}
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.GenDecl:
+					if d.Tok != token.TYPE || len(d.Specs) != 1 {
+						continue
+					}
+					name := d.Specs[0].(*ast.TypeSpec).Name.Name
+					markers[name] = genClientMarkers(fset, f, d)
+				case *ast.FuncDecl:
+					if d.Name.Name != "NamespaceScoped" || d.Recv == nil || d.Body == nil || len(d.Body.List) != 1 {
+						continue
+					}
+					ret, ok := d.Body.List[0].(*ast.ReturnStmt)
+					if !ok || len(ret.Results) != 1 {
+						continue
+					}
+					value, ok := ret.Results[0].(*ast.Ident)
+					if !ok || (value.Name != "true" && value.Name != "false") {
+						continue
+					}
+					scoped[receiverType(d.Recv)] = value.Name == "true"
+				}
+			}
+		}
+		for name, namespaced := range scoped {
+			genclient, nonNamespaced := false, false
+			for _, m := range markers[name] {
+				genclient = genclient || m == "+genclient" || m == "+genclient=true"
+				nonNamespaced = nonNamespaced || m == "+genclient:nonNamespaced"
+			}
+			switch {
+			case !genclient:
+			case namespaced && nonNamespaced:
+				klog.Fatalf("%s in %s is namespace scoped but marked with +genclient:nonNamespaced, remove the marker",
+					name, dir)
+			case !namespaced && !nonNamespaced:
+				klog.Fatalf("%s in %s is cluster scoped, add \"// +genclient:nonNamespaced\" under \"// +genclient\"",
+					name, dir)
+			}
+		}
+	}
+}
+
+// genClientMarkers returns the +genclient markers from the doc comment of the type and from the
+// comment block right above it, the same as the generators read them.
+func genClientMarkers(fset *token.FileSet, f *ast.File, d *ast.GenDecl) []string {
+	var groups []*ast.CommentGroup
+	for _, c := range f.Comments {
+		if c.End() < d.Pos() {
+			groups = append(groups, c)
+		}
+	}
+	if len(groups) > 2 {
+		groups = groups[len(groups)-2:]
+	}
+	var markers []string
+	for _, g := range groups {
+		// skip the comment blocks which aren't adjacent to the type
+		if fset.Position(d.Pos()).Line-fset.Position(g.End()).Line > 2+len(strings.Split(g.Text(), "\n")) {
+			continue
+		}
+		for _, c := range g.List {
+			text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			if strings.HasPrefix(text, "+genclient") {
+				markers = append(markers, text)
+			}
+		}
+	}
+	return markers
+}
+
+func receiverType(recv *ast.FieldList) string {
+	t := recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if ident, ok := t.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
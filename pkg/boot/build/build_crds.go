@@ -0,0 +1,344 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/yaml"
+)
+
+var crdsOutputDir string
+
+var buildCRDsCmd = &cobra.Command{
+	Use:   "crds",
+	Short: "Create CustomResourceDefinitions for the resources of the apiserver.",
+	Long: `Create CustomResourceDefinitions for the resources of the apiserver.
+
+A CustomResourceDefinition is written for every resource served by the apiserver, e.g. for
+migrating from the aggregated apiserver to CRDs, or for serving the resources both ways.  The
+schemas are built from the generated pkg/openapi package, the storage version from
+IsStorageVersion(), and the status and scale subresources from the resources implementing
+ObjectWithStatusSubResource and ObjectWithScaleSubResource.
+
+CRDs can't express every feature of an aggregated apiserver.  A warning is logged for the
+connector and arbitrary subresources, which are left out of the CRDs, and for the resources with
+a custom storage, whose CRDs are still written but whose objects are stored in etcd by the
+kube-apiserver.`,
+	Example: `# Write the CRDs into config/crd/bases
+go generate ./pkg/openapi/...
+apiserver-boot build crds
+
+# Install the CRDs
+kubectl apply -f config/crd/bases`,
+	Run: RunBuildCRDs,
+}
+
+func AddBuildCRDs(cmd *cobra.Command) {
+	cmd.AddCommand(buildCRDsCmd)
+	buildCRDsCmd.Flags().StringVar(&crdsOutputDir, "output-dir", filepath.Join("config", "crd", "bases"), "Write the CRDs into this directory")
+}
+
+func RunBuildCRDs(cmd *cobra.Command, args []string) {
+	apis, err := LoadProjectAPIs()
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+
+	byName := map[string][]APIResource{}
+	for _, r := range apis.Resources {
+		name := r.Resource + "." + r.Group
+		byName[name] = append(byName[name], r)
+	}
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	os.MkdirAll(crdsOutputDir, 0700)
+	for _, name := range names {
+		crd := buildCRD(name, byName[name], apis.Definitions)
+		data, err := marshalCRD(crd)
+		if err != nil {
+			klog.Fatalf("error: %v", err)
+		}
+		file := filepath.Join(crdsOutputDir, fmt.Sprintf("%s_%s.yaml", crd.Spec.Group, crd.Spec.Names.Plural))
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			klog.Fatalf("error: %v", err)
+		}
+		klog.Infof("wrote %s", file)
+	}
+}
+
+func buildCRD(name string, resources []APIResource, definitions spec.Definitions) *apiextensionsv1.CustomResourceDefinition {
+	sort.Slice(resources, func(i, j int) bool {
+		return version.CompareKubeAwareVersionStrings(resources[i].Version, resources[j].Version) > 0
+	})
+	first := resources[0]
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextensionsv1.SchemeGroupVersion.String(),
+			Kind:       "CustomResourceDefinition",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: first.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:     first.Resource,
+				Singular:   strings.ToLower(first.Kind),
+				Kind:       first.Kind,
+				ListKind:   first.Kind + "List",
+				ShortNames: first.ShortNames,
+			},
+			Scope: apiextensionsv1.ClusterScoped,
+		},
+	}
+	if first.Namespaced {
+		crd.Spec.Scope = apiextensionsv1.NamespaceScoped
+	}
+
+	storage := -1
+	for i, r := range resources {
+		if r.Kind != first.Kind || r.Namespaced != first.Namespaced {
+			klog.Warningf("%s: the kind and the scope of %s differ from %s, which CRDs can't express",
+				name, r.Version, first.Version)
+		}
+		if r.CustomStorage {
+			klog.Warningf("%s: %s has a custom storage which CRDs can't express, the objects are stored in etcd",
+				name, r.Version)
+		}
+		if r.StorageVersion {
+			if storage >= 0 {
+				klog.Warningf("%s: both %s and %s are storage versions, using %s",
+					name, resources[storage].Version, r.Version, resources[storage].Version)
+			} else {
+				storage = i
+			}
+		}
+	}
+	if storage < 0 {
+		klog.Warningf("%s: none of the versions is a storage version, using %s", name, first.Version)
+		storage = 0
+	}
+	if len(resources) > 1 {
+		klog.Warningf("%s: the CRD serves %d versions without a conversion webhook, the schemas of the "+
+			"versions must be compatible", name, len(resources))
+	}
+
+	for i, r := range resources {
+		c := &crdSchemaConverter{definitions: definitions, resource: name + " " + r.Version, stack: map[string]bool{}}
+		schema := c.convert(*spec.RefSchema("#/definitions/" + r.Definition))
+		v := apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:    r.Version,
+			Served:  true,
+			Storage: i == storage,
+			Schema:  &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: &schema},
+		}
+		for _, sub := range r.SubResources {
+			switch sub.Type {
+			case SubResourceStatus:
+				if v.Subresources == nil {
+					v.Subresources = &apiextensionsv1.CustomResourceSubresources{}
+				}
+				v.Subresources.Status = &apiextensionsv1.CustomResourceSubresourceStatus{}
+			case SubResourceScale:
+				if v.Subresources == nil {
+					v.Subresources = &apiextensionsv1.CustomResourceSubresources{}
+				}
+				v.Subresources.Scale = scaleSubresource(name+" "+r.Version, schema)
+			default:
+				klog.Warningf("%s: the %s subresource %s of %s can't be expressed by CRDs and is left out",
+					name, sub.Type, sub.Name, r.Version)
+			}
+		}
+		crd.Spec.Versions = append(crd.Spec.Versions, v)
+	}
+	return crd
+}
+
+// scaleSubresource maps the scale subresource to the replicas fields of the schema, the same
+// way as the scale subresource of the deployments.
+func scaleSubresource(resource string, schema apiextensionsv1.JSONSchemaProps) *apiextensionsv1.CustomResourceSubresourceScale {
+	has := func(parent, field string) bool {
+		_, found := schema.Properties[parent].Properties[field]
+		return found
+	}
+	scale := &apiextensionsv1.CustomResourceSubresourceScale{
+		SpecReplicasPath:   ".spec.replicas",
+		StatusReplicasPath: ".status.replicas",
+	}
+	if !has("spec", "replicas") || !has("status", "replicas") {
+		klog.Warningf("%s: the scale subresource expects the spec.replicas and status.replicas fields, "+
+			"edit the specReplicasPath and statusReplicasPath of the CRD", resource)
+	}
+	if has("status", "selector") {
+		selector := ".status.selector"
+		scale.LabelSelectorPath = &selector
+	}
+	return scale
+}
+
+// marshalCRD drops the status and the empty creation timestamp from the CRD the same way as
+// controller-gen.
+func marshalCRD(crd *apiextensionsv1.CustomResourceDefinition) ([]byte, error) {
+	data, err := json.Marshal(crd)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	delete(obj, "status")
+	delete(obj["metadata"].(map[string]interface{}), "creationTimestamp")
+	data, err = yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("---\n"), data...), nil
+}
+
+// crdSchemaConverter inlines the openapi definitions of a resource into a structural schema.
+type crdSchemaConverter struct {
+	definitions spec.Definitions
+	resource    string
+	stack       map[string]bool
+}
+
+func (c *crdSchemaConverter) convert(s spec.Schema) apiextensionsv1.JSONSchemaProps {
+	if ref := s.Ref.String(); len(ref) > 0 {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		description := s.Description
+		switch name {
+		case "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta":
+			return apiextensionsv1.JSONSchemaProps{Type: "object", Description: description}
+		case "io.k8s.apimachinery.pkg.runtime.RawExtension":
+			return apiextensionsv1.JSONSchemaProps{Type: "object", Description: description, XPreserveUnknownFields: boolPtr(true)}
+		case "io.k8s.apimachinery.pkg.util.intstr.IntOrString":
+			return intOrString(description, "")
+		case "io.k8s.apimachinery.pkg.api.resource.Quantity":
+			return intOrString(description,
+				`^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`)
+		}
+		def, found := c.definitions[name]
+		if !found || c.stack[name] {
+			if c.stack[name] {
+				klog.Warningf("%s: the recursive type %s can't be expressed by CRDs, its fields are preserved unvalidated",
+					c.resource, name)
+			} else {
+				klog.Warningf("%s: missing the openapi definition of %s, its fields are preserved unvalidated",
+					c.resource, name)
+			}
+			return apiextensionsv1.JSONSchemaProps{Type: "object", Description: description, XPreserveUnknownFields: boolPtr(true)}
+		}
+		c.stack[name] = true
+		defer delete(c.stack, name)
+		props := c.convert(def)
+		if len(description) > 0 {
+			props.Description = description
+		}
+		return props
+	}
+
+	props := apiextensionsv1.JSONSchemaProps{
+		Description: s.Description,
+		Format:      s.Format,
+		Required:    s.Required,
+		Nullable:    s.Nullable,
+		Maximum:     s.Maximum,
+		Minimum:     s.Minimum,
+		MaxLength:   s.MaxLength,
+		MinLength:   s.MinLength,
+		Pattern:     s.Pattern,
+		MaxItems:    s.MaxItems,
+		MinItems:    s.MinItems,
+	}
+	if len(s.Type) > 0 {
+		props.Type = s.Type[0]
+	}
+	if props.Format == "int-or-string" {
+		return intOrString(s.Description, "")
+	}
+	for _, e := range s.Enum {
+		raw, _ := json.Marshal(e)
+		props.Enum = append(props.Enum, apiextensionsv1.JSON{Raw: raw})
+	}
+	if len(s.Properties) > 0 {
+		props.Type = "object"
+		props.Properties = map[string]apiextensionsv1.JSONSchemaProps{}
+		for name, p := range s.Properties {
+			props.Properties[name] = c.convert(p)
+		}
+	}
+	if s.Items != nil && s.Items.Schema != nil {
+		items := c.convert(*s.Items.Schema)
+		props.Items = &apiextensionsv1.JSONSchemaPropsOrArray{Schema: &items}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		values := c.convert(*s.AdditionalProperties.Schema)
+		props.AdditionalProperties = &apiextensionsv1.JSONSchemaPropsOrBool{Allows: true, Schema: &values}
+	}
+	if len(props.Type) == 0 {
+		// e.g. an interface{} field
+		props.XPreserveUnknownFields = boolPtr(true)
+	}
+	if v, ok := s.Extensions.GetString("x-kubernetes-list-type"); ok {
+		props.XListType = &v
+	}
+	if v, ok := s.Extensions.GetStringSlice("x-kubernetes-list-map-keys"); ok {
+		props.XListMapKeys = v
+	}
+	if v, ok := s.Extensions.GetString("x-kubernetes-map-type"); ok {
+		props.XMapType = &v
+	}
+	if v, ok := s.Extensions.GetBool("x-kubernetes-preserve-unknown-fields"); ok && v {
+		props.XPreserveUnknownFields = &v
+	}
+	if v, ok := s.Extensions.GetBool("x-kubernetes-embedded-resource"); ok && v {
+		props.XEmbeddedResource = v
+	}
+	return props
+}
+
+func intOrString(description, pattern string) apiextensionsv1.JSONSchemaProps {
+	return apiextensionsv1.JSONSchemaProps{
+		Description: description,
+		AnyOf: []apiextensionsv1.JSONSchemaProps{
+			{Type: "integer"},
+			{Type: "string"},
+		},
+		Pattern:      pattern,
+		XIntOrString: true,
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
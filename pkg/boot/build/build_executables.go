@@ -21,10 +21,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
 )
 
 var goos = "linux"
@@ -175,9 +177,18 @@ func GoBuild(cmd *cobra.Command, args []string) {
 	if buildApiserver() {
 		// Build the apiserver
 		path := filepath.Join("cmd", "apiserver", "main.go")
-		c := exec.Command("go", "build", "-o", filepath.Join(outputdir, "apiserver"), path)
-		c.Env = append(os.Environ(), "CGO_ENABLED=0")
-		klog.Infof("CGO_ENABLED=0")
+		buildArgs := []string{"build"}
+		cgo := "CGO_ENABLED=0"
+		if util.GetStorage() == util.StorageSQLite {
+			// the sqlite driver of kine is only compiled with cgo, linked statically so the
+			// apiserver runs in any image
+			validateCgoTarget()
+			buildArgs = append(buildArgs, "-tags", "netgo,osusergo", "-ldflags", "-linkmode external -extldflags -static")
+			cgo = "CGO_ENABLED=1"
+		}
+		c := exec.Command("go", append(buildArgs, "-o", filepath.Join(outputdir, "apiserver"), path)...)
+		c.Env = append(os.Environ(), cgo)
+		klog.Infof(cgo)
 		if len(goos) > 0 {
 			c.Env = append(c.Env, fmt.Sprintf("GOOS=%s", goos))
 			klog.Infof(fmt.Sprintf("GOOS=%s", goos))
@@ -220,6 +231,24 @@ func GoBuild(cmd *cobra.Command, args []string) {
 	}
 }
 
+// validateCgoTarget exits if the apiserver can't be built with cgo for --goos and --goarch, which
+// needs a C cross compiler unless they are the platform of the host.
+func validateCgoTarget() {
+	targetOS, targetArch := runtime.GOOS, runtime.GOARCH
+	if len(goos) > 0 {
+		targetOS = goos
+	}
+	if len(goarch) > 0 {
+		targetArch = goarch
+	}
+	if targetOS == runtime.GOOS && targetArch == runtime.GOARCH || len(os.Getenv("CC")) > 0 {
+		return
+	}
+	klog.Fatalf("the sqlite storage needs the apiserver built with cgo, which can't build for %s/%s on %s/%s "+
+		"without a C cross compiler, set CC to one or build on %s/%s",
+		targetOS, targetArch, runtime.GOOS, runtime.GOARCH, targetOS, targetArch)
+}
+
 func buildApiserver() bool {
 	for _, t := range BuildTargets {
 		if t == apiserverTarget {
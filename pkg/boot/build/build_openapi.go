@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+var openapiOutputDir string
+var openapiTitle string
+
+var buildOpenAPICmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "Write the OpenAPI v2 and v3 specs of the apiserver to disk.",
+	Long: `Write the OpenAPI v2 and v3 specs of the apiserver to disk.
+
+The specs are built from the generated pkg/openapi package and the api groups under pkg/apis
+by a small helper program compiled in the project, so neither a running apiserver nor etcd is
+needed.  The OpenAPI v2 spec is written to <output-dir>/swagger.json, and an OpenAPI v3 spec per
+group version is written to <output-dir>/v3/apis/<group>/<version>.json, which are the paths the
+apiserver serves them from.`,
+	Example: `# Write the specs into docs/openapi-spec
+go generate ./pkg/openapi/...
+apiserver-boot build openapi
+
+# Write the specs into another directory, e.g. to diff them in CI
+apiserver-boot build openapi --output-dir /tmp/openapi
+git diff --no-index docs/openapi-spec /tmp/openapi
+
+# Build docs from the written spec
+apiserver-boot build docs --build-openapi=false`,
+	Run: RunBuildOpenAPI,
+}
+
+func AddBuildOpenAPI(cmd *cobra.Command) {
+	cmd.AddCommand(buildOpenAPICmd)
+	buildOpenAPICmd.Flags().StringVar(&openapiOutputDir, "output-dir", filepath.Join("docs", "openapi-spec"), "Write the specs into this directory")
+	buildOpenAPICmd.Flags().StringVar(&openapiTitle, "title", "", "Title of the specs, defaults to the name of the go module")
+}
+
+func RunBuildOpenAPI(cmd *cobra.Command, args []string) {
+	if len(openapiTitle) == 0 {
+		openapiTitle = path.Base(util.GetRepo())
+	}
+
+	apis, err := LoadProjectAPIs()
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	swagger := BuildOpenAPISpec(apis, openapiTitle)
+	writeJSON(filepath.Join(openapiOutputDir, "swagger.json"), swagger)
+
+	specs, err := OpenAPIV3Specs(swagger)
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	for gv, doc := range specs {
+		writeJSON(filepath.Join(openapiOutputDir, "v3", "apis", gv.Group, gv.Version+".json"), doc)
+	}
+}
+
+func writeJSON(file string, obj interface{}) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	os.MkdirAll(filepath.Dir(file), 0700)
+	if err := ioutil.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	klog.Infof("wrote %s", file)
+}
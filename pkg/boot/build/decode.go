@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+// DecodedObject is a stored object decoded as json, or the error decoding it.
+type DecodedObject struct {
+	Object json.RawMessage `json:"object,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// DecodeProjectObjects decodes the objects as stored by the aggregated apiserver, either json or
+// protobuf, with the scheme of the api groups under pkg/apis.  The objects are given and returned
+// by key.
+func DecodeProjectObjects(stored map[string][]byte) (map[string]DecodedObject, error) {
+	initApis()
+	var versions []schema.GroupVersion
+	for _, a := range versionedAPIs {
+		versions = append(versions, schema.GroupVersion{Group: path.Dir(a), Version: path.Base(a)})
+	}
+
+	input, err := ioutil.TempFile("", "apiserver-boot-decode-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input.Name())
+	err = json.NewEncoder(input).Encode(stored)
+	input.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed writing the objects to decode")
+	}
+
+	buf := &bytes.Buffer{}
+	err = util.RunHelperProgram("decode", decodeHelperTemplate, decodeHelperTemplateArgs{
+		Repo:     util.GetRepo(),
+		Versions: versions,
+	}, buf, input.Name())
+	if err != nil {
+		return nil, err
+	}
+	decoded := map[string]DecodedObject{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		return nil, errors.Wrap(err, "failed decoding the output of the decode helper")
+	}
+	return decoded, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+type decodeHelperTemplateArgs struct {
+	Repo     string
+	Versions []schema.GroupVersion
+}
+
+// decodeHelperTemplate is a program compiled inside the project.  It reads the
+// stored objects by key from the json file of its argument, decodes them with
+// the scheme of the api groups, and prints them by key as json.
+var decodeHelperTemplate = `
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+{{- range .Versions }}
+	{{ .Group }}{{ .Version }} "{{ $.Repo }}/pkg/apis/{{ .Group }}/{{ .Version }}"
+{{- end }}
+)
+
+type decodedObject struct {
+	Object runtime.Object ` + "`" + `json:"object,omitempty"` + "`" + `
+	Error  string         ` + "`" + `json:"error,omitempty"` + "`" + `
+}
+
+func main() {
+	scheme := runtime.NewScheme()
+{{- range .Versions }}
+	if err := {{ .Group }}{{ .Version }}.AddToScheme(scheme); err != nil {
+		fail(err)
+	}
+{{- end }}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	data, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		fail(err)
+	}
+	stored := map[string][]byte{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		fail(err)
+	}
+
+	decoded := map[string]decodedObject{}
+	for key, value := range stored {
+		obj, gvk, err := decoder.Decode(value, nil, nil)
+		if err != nil {
+			decoded[key] = decodedObject{Error: err.Error()}
+			continue
+		}
+		obj.GetObjectKind().SetGroupVersionKind(*gvk)
+		decoded[key] = decodedObject{Object: obj}
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(decoded); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+`
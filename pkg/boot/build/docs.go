@@ -17,39 +17,38 @@ limitations under the License.
 package build
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
 )
 
 var docsCmd = &cobra.Command{
 	Use:   "docs",
 	Short: "Generate API reference docs from the openapi spec.",
-	Long:  `Generate API reference docs from the openapi spec.`,
+	Long: `Generate API reference docs from the openapi spec.
+
+The openapi definitions are loaded from the generated pkg/openapi package of the project, so
+neither a running apiserver nor etcd is needed.  The docs are rendered as both markdown and html
+into docs/build/index.md and docs/build/index.html.`,
 	Example: `# Edit docs examples
 nano -w docs/examples/<kind>/<kind.yaml
 
-# Start a new server, get the swagger.json, and generate docs from the swagger.json
-apiserver-boot build executables
+# Generate the openapi definitions under pkg/openapi and generate docs from them
+go generate ./pkg/openapi/...
 apiserver-boot build docs
 
 # Build docs and include operations.
 apiserver-boot build docs --operations=true
 
 # Use the swagger.json at docs/openapi-spec/swagger.json instead
-# of getting it from a server.
+# of building it from pkg/openapi.
 apiserver-boot build docs --build-openapi=false
 
-# Use the server at my/bin/apiserver
-apiserver-boot build docs --server my/bin/apiserver
-
 # Instead of generating the table of contents, use the statically defined configuration
 # from docs/config.yaml
 # See an example config.yaml at in kubernetes-incubator/reference-docs
@@ -62,7 +61,7 @@ apiserver-boot build docs --generate-toc=false
 	# <strong>API OVERVIEW</strong>
 	Add your markdown here
 
-# Add examples in the right-most column
+# Add examples to the generated docs
 # Edit docs/examples/<type>/<type>.yaml
 # e.g. docs/examples/pod/pod.yaml
 
@@ -86,11 +85,13 @@ var outputDir string
 func AddDocs(cmd *cobra.Command) {
 	docsCmd.Flags().StringVar(&server, "server", "bin/apiserver", "path to apiserver binary to run to get swagger.json")
 	docsCmd.Flags().BoolVar(&cleanup, "cleanup", true, "If true, cleanup intermediary files")
-	docsCmd.Flags().BoolVar(&buildOpenapi, "build-openapi", true, "If true, run the server and get the new swagger.json")
+	docsCmd.Flags().BoolVar(&buildOpenapi, "build-openapi", true, "If true, build the swagger.json from the generated pkg/openapi")
 	docsCmd.Flags().BoolVar(&operations, "operations", false, "if true, include operations in docs.")
 	docsCmd.Flags().BoolVar(&generateToc, "generate-toc", true, "If true, generate the table of contents from the api groups instead of using a statically configured ToC.")
 	docsCmd.Flags().BoolVar(&disableDelegatedAuth, "disable-delegated-auth", true, "If true, disable delegated auth in the apiserver with --delegated-auth=false.")
 	docsCmd.Flags().StringVar(&outputDir, "output-dir", "docs", "Build docs into this directory")
+	docsCmd.Flags().MarkDeprecated("server", "the swagger.json is built from pkg/openapi without running the apiserver")
+	docsCmd.Flags().MarkDeprecated("disable-delegated-auth", "the swagger.json is built from pkg/openapi without running the apiserver")
 	cmd.AddCommand(docsCmd)
 	docsCmd.AddCommand(docsCleanCmd)
 }
@@ -110,116 +111,45 @@ func RunCleanDocs(cmd *cobra.Command, args []string) {
 }
 
 func RunDocs(cmd *cobra.Command, args []string) {
-	klog.Fatal("Docs is not yet supported")
-	if len(server) == 0 && buildOpenapi {
-		klog.Fatal("Must specifiy --server or --build-openapi=false")
-	}
-
-	os.RemoveAll(filepath.Join(outputDir, "includes"))
-	os.MkdirAll(filepath.Join(outputDir, "openapi-spec"), 0700)
 	os.MkdirAll(filepath.Join(outputDir, "static_includes"), 0700)
 	os.MkdirAll(filepath.Join(outputDir, "examples"), 0700)
+	os.MkdirAll(filepath.Join(outputDir, "build"), 0700)
+
+	specFile := filepath.Join(outputDir, "openapi-spec", "swagger.json")
 
 	// Build the swagger.json
+	var swagger *spec.Swagger
 	if buildOpenapi {
-		flags := []string{
-			"--etcd-servers=http://localhost:2379",
-			"--secure-port=9443",
-			"--print-openapi",
+		apis, err := LoadProjectAPIs()
+		if err != nil {
+			klog.Fatalf("error: %v", err)
 		}
-
-		if disableDelegatedAuth {
-			flags = append(flags, "--delegated-auth=false")
+		swagger = BuildOpenAPISpec(apis, path.Base(util.GetRepo()))
+		if !cleanup {
+			writeJSON(specFile, swagger)
 		}
-
-		etcdStopFunc := RunEtcd()
-		defer etcdStopFunc()
-		klog.Infof("starting local etcd...")
-
-		c := exec.Command(server,
-			flags...,
-		)
-		klog.Infof("%s", strings.Join(c.Args, " "))
-
-		var b bytes.Buffer
-		c.Stdout = &b
-		c.Stderr = os.Stderr
-
-		err := c.Run()
+	} else {
+		var err error
+		swagger, err = LoadOpenAPISpecFile(specFile)
 		if err != nil {
 			klog.Fatalf("error: %v", err)
 		}
+	}
 
-		err = ioutil.WriteFile(filepath.Join(outputDir, "openapi-spec", "swagger.json"), b.Bytes(), 0644)
+	var toc *docsConfig
+	if !generateToc {
+		var err error
+		toc, err = loadDocsConfig(filepath.Join(outputDir, "config.yaml"))
 		if err != nil {
 			klog.Fatalf("error: %v", err)
 		}
 	}
 
-	// Build the docs
-	dir, err := os.Executable()
-	if err != nil {
-		klog.Fatalf("error: %v", err)
-	}
-	dir = filepath.Dir(dir)
-	c := exec.Command(filepath.Join(dir, "gen-apidocs"),
-		fmt.Sprintf("--build-operations=%v", operations),
-		fmt.Sprintf("--use-tags=%v", generateToc),
-		"--allow-errors",
-		"--config-dir="+outputDir)
-	klog.Infof("%s", strings.Join(c.Args, " "))
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	err = c.Run()
-	if err != nil {
-		klog.Fatalf("error: %v", err)
-	}
-
-	wd, err := os.Getwd()
-	if err != nil {
-		klog.Fatalf("error: %v", err)
-	}
-
-	// Run the docker command to build the docs
-	c = exec.Command("docker", "run",
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir, "includes"), "/source"),
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir, "build"), "/build"),
-		"-v", fmt.Sprintf("%s:%s", filepath.Join(wd, outputDir), "/manifest"),
-		"pwittrock/brodocs",
-	)
-	klog.Infof(strings.Join(c.Args, " "))
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	err = c.Run()
-	if err != nil {
-		klog.Fatalf("error: %v", err)
-	}
-
-	// Cleanup intermediate files
-	if cleanup {
-		os.RemoveAll(filepath.Join(wd, outputDir, "includes"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "manifest.json"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "openapi-spec"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "documents"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "runbrodocs.sh"))
-		os.RemoveAll(filepath.Join(wd, outputDir, "build", "node_modules", "marked", "Makefile"))
-	}
-}
-
-func RunEtcd() func() {
-	etcdCmd := exec.Command("etcd")
-
-	klog.Infof("%s", strings.Join(etcdCmd.Args, " "))
-	go func() {
-		err := etcdCmd.Run()
-		if err != nil {
-			klog.Fatalf("Failed to run etcd %v", err)
-			os.Exit(-1)
-		}
-	}()
-	return func() {
-		if etcdCmd.Process != nil {
-			etcdCmd.Process.Kill()
+	page := newDocsPage(swagger, toc, outputDir, operations)
+	for _, file := range []string{"index.md", "index.html"} {
+		if err := page.render(filepath.Join(outputDir, "build", file)); err != nil {
+			klog.Fatalf("error: %v", err)
 		}
+		klog.Infof("wrote %s", filepath.Join(outputDir, "build", file))
 	}
 }
@@ -0,0 +1,622 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/russross/blackfriday/v2"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+	"sigs.k8s.io/yaml"
+)
+
+// docsConfig is the statically configured table of contents read from docs/config.yaml,
+// in the format used by kubernetes-sigs/reference-docs.
+type docsConfig struct {
+	ResourceCategories []struct {
+		Name      string `json:"name"`
+		Include   string `json:"include"`
+		Resources []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Group   string `json:"group"`
+		} `json:"resources"`
+	} `json:"resource_categories"`
+}
+
+func loadDocsConfig(file string) (*docsConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading %s", file)
+	}
+	config := &docsConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "failed parsing %s", file)
+	}
+	return config, nil
+}
+
+// docsExample is an example under docs/examples/<kind>/.  Type examples carry a note and
+// a sample, operation examples carry a request and a response.
+type docsExample struct {
+	File      string `json:"-"`
+	Note      string `json:"note"`
+	Sample    string `json:"sample"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Request   string `json:"request"`
+	Response  string `json:"response"`
+}
+
+type docsField struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+type docsDefinition struct {
+	Name        string
+	Anchor      string
+	Description string
+	Fields      []docsField
+}
+
+type docsOperation struct {
+	Method      string
+	Path        string
+	ID          string
+	Description string
+	Example     *docsExample
+}
+
+type docsKind struct {
+	APIResource
+	Anchor       string
+	Description  string
+	Fields       []docsField
+	Examples     []docsExample
+	Operations   []docsOperation
+	SubResources []string
+}
+
+type docsSubsection struct {
+	Name        string
+	Anchor      string
+	Kinds       []*docsKind
+	Definitions []*docsDefinition
+}
+
+type docsSection struct {
+	Name        string
+	Anchor      string
+	Include     string
+	Subsections []*docsSubsection
+}
+
+type docsPage struct {
+	Title    string
+	Overview string
+	Sections []*docsSection
+}
+
+// operationExamples maps the kubernetes action of an operation to the file holding its example.
+var operationExamples = map[string]string{
+	"post":   "create",
+	"delete": "delete",
+	"list":   "list",
+	"patch":  "patch",
+	"get":    "read",
+	"put":    "replace",
+}
+
+var anchorReplacer = regexp.MustCompile(`[^a-z0-9]+`)
+
+func anchorFor(parts ...string) string {
+	return strings.Trim(anchorReplacer.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-"), "-")
+}
+
+// isUpstreamDefinition returns true for the kubernetes definitions, e.g. ObjectMeta, which are
+// documented by kubernetes and so aren't repeated in the project's docs.
+func isUpstreamDefinition(name string) bool {
+	return strings.HasPrefix(name, "io.k8s.apimachinery.") || strings.HasPrefix(name, "io.k8s.api.")
+}
+
+func shortDefinitionName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// docsBuilder assembles the docs page out of the openapi spec.
+type docsBuilder struct {
+	swagger    *spec.Swagger
+	dir        string
+	operations bool
+	anchors    map[string]string
+	documented map[string]bool
+}
+
+func newDocsPage(swagger *spec.Swagger, toc *docsConfig, dir string, operations bool) *docsPage {
+	b := &docsBuilder{
+		swagger:    swagger,
+		dir:        dir,
+		operations: operations,
+		anchors:    map[string]string{},
+		documented: map[string]bool{},
+	}
+	resources := ResourcesFromOpenAPISpec(swagger)
+	for _, r := range resources {
+		b.anchors[r.Definition] = anchorFor(r.Kind, r.Version, r.Group)
+	}
+	for name := range swagger.Definitions {
+		if _, found := b.anchors[name]; !found && !isUpstreamDefinition(name) {
+			b.anchors[name] = anchorFor(name)
+		}
+	}
+
+	title := "API Reference"
+	if swagger.Info != nil && len(swagger.Info.Title) > 0 {
+		title = swagger.Info.Title + " API Reference"
+	}
+	page := &docsPage{
+		Title:    title,
+		Overview: b.staticInclude("overview", "# <strong>API OVERVIEW</strong>\nAdd your markdown here\n"),
+	}
+
+	if toc == nil {
+		var section *docsSection
+		var subsection *docsSubsection
+		for _, r := range resources {
+			group := strings.Split(r.Group, ".")[0]
+			if section == nil || section.Name != r.Group {
+				section = &docsSection{
+					Name:    r.Group,
+					Anchor:  anchorFor(r.Group),
+					Include: b.staticInclude(group, "# <strong>"+strings.ToUpper(group)+"</strong>\n"),
+				}
+				page.Sections = append(page.Sections, section)
+				subsection = nil
+			}
+			if subsection == nil || subsection.Name != r.Version {
+				subsection = &docsSubsection{Name: r.Version, Anchor: anchorFor(r.Version, r.Group)}
+				section.Subsections = append(section.Subsections, subsection)
+			}
+			subsection.Kinds = append(subsection.Kinds, b.kind(r))
+		}
+	} else {
+		for _, c := range toc.ResourceCategories {
+			section := &docsSection{Name: c.Name, Anchor: anchorFor(c.Name)}
+			if len(c.Include) > 0 {
+				section.Include = b.staticInclude(c.Include, "# <strong>"+strings.ToUpper(c.Name)+"</strong>\n")
+			}
+			subsection := &docsSubsection{}
+			for _, cr := range c.Resources {
+				found := false
+				for _, r := range resources {
+					if r.Kind == cr.Name && r.Version == cr.Version &&
+						(r.Group == cr.Group || strings.HasPrefix(r.Group, cr.Group+".")) {
+						subsection.Kinds = append(subsection.Kinds, b.kind(r))
+						found = true
+					}
+				}
+				if !found {
+					klog.Warningf("could not find resource %s %s/%s listed in the table of contents",
+						cr.Name, cr.Group, cr.Version)
+				}
+			}
+			section.Subsections = []*docsSubsection{subsection}
+			page.Sections = append(page.Sections, section)
+		}
+	}
+
+	// Document the project's own types referenced by the resources, e.g. the Spec and Status,
+	// next to the resources referencing them first.
+	for _, section := range page.Sections {
+		for _, subsection := range section.Subsections {
+			for _, k := range subsection.Kinds {
+				b.documented[k.Definition] = true
+				b.documented[k.ListDefinition] = true
+			}
+		}
+	}
+	for _, section := range page.Sections {
+		for _, subsection := range section.Subsections {
+			for _, k := range subsection.Kinds {
+				def := b.swagger.Definitions[k.Definition]
+				subsection.Definitions = append(subsection.Definitions, b.referencedDefinitions(def)...)
+			}
+		}
+	}
+	return page
+}
+
+// staticInclude reads docs/static_includes/_<name>.md, creating it with the placeholder
+// content if it doesn't exist yet.
+func (b *docsBuilder) staticInclude(name, placeholder string) string {
+	file := filepath.Join(b.dir, "static_includes", "_"+name+".md")
+	util.WriteIfNotFound(file, "static-include-template", "{{ . }}", placeholder)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (b *docsBuilder) kind(r APIResource) *docsKind {
+	def := b.swagger.Definitions[r.Definition]
+	k := &docsKind{
+		APIResource: r,
+		Anchor:      b.anchors[r.Definition],
+		Description: def.Description,
+		Fields:      b.fields(def),
+		Examples:    b.examples(r.Kind),
+	}
+	for _, s := range r.SubResources {
+		k.SubResources = append(k.SubResources, s.Name)
+	}
+	if b.operations {
+		k.Operations = b.kindOperations(r, k.Examples)
+	}
+	return k
+}
+
+func (b *docsBuilder) fields(def spec.Schema) []docsField {
+	var fields []docsField
+	required := map[string]bool{}
+	for _, r := range def.Required {
+		required[r] = true
+	}
+	for name, prop := range def.Properties {
+		fields = append(fields, docsField{
+			Name:        name,
+			Type:        b.typeOf(prop),
+			Description: prop.Description,
+			Required:    required[name],
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// typeOf renders the type of a property as markdown, linking to the documented definitions.
+func (b *docsBuilder) typeOf(s spec.Schema) string {
+	if ref := s.Ref.String(); len(ref) > 0 {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		if anchor, found := b.anchors[name]; found {
+			return fmt.Sprintf("[%s](#%s)", shortDefinitionName(name), anchor)
+		}
+		return shortDefinitionName(name)
+	}
+	if s.Items != nil && s.Items.Schema != nil {
+		return "[]" + b.typeOf(*s.Items.Schema)
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		return "map[string]" + b.typeOf(*s.AdditionalProperties.Schema)
+	}
+	if len(s.Type) == 0 {
+		return "object"
+	}
+	if len(s.Format) > 0 {
+		return s.Type[0] + " (" + s.Format + ")"
+	}
+	return s.Type[0]
+}
+
+// referencedDefinitions walks the references of a definition and returns the project's
+// own definitions which haven't been documented yet.
+func (b *docsBuilder) referencedDefinitions(def spec.Schema) []*docsDefinition {
+	var defs []*docsDefinition
+	var walk func(s spec.Schema)
+	walk = func(s spec.Schema) {
+		if ref := s.Ref.String(); len(ref) > 0 {
+			name := strings.TrimPrefix(ref, "#/definitions/")
+			if b.documented[name] || isUpstreamDefinition(name) {
+				return
+			}
+			target, found := b.swagger.Definitions[name]
+			if !found {
+				return
+			}
+			b.documented[name] = true
+			defs = append(defs, &docsDefinition{
+				Name:        shortDefinitionName(name),
+				Anchor:      b.anchors[name],
+				Description: target.Description,
+				Fields:      b.fields(target),
+			})
+			walk(target)
+			return
+		}
+		var names []string
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			walk(s.Properties[name])
+		}
+		if s.Items != nil && s.Items.Schema != nil {
+			walk(*s.Items.Schema)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			walk(*s.AdditionalProperties.Schema)
+		}
+	}
+	walk(def)
+	return defs
+}
+
+// examples reads the examples under docs/examples/<kind>/.
+func (b *docsBuilder) examples(kind string) []docsExample {
+	dir := filepath.Join(b.dir, "examples", strings.ToLower(kind))
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	sort.Strings(files)
+	var examples []docsExample
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			klog.Fatalf("error: %v", err)
+		}
+		e := docsExample{}
+		if err := yaml.Unmarshal(data, &e); err != nil {
+			klog.Warningf("skipping example %s: %v", file, err)
+			continue
+		}
+		e.File = strings.TrimSuffix(filepath.Base(file), ".yaml")
+		examples = append(examples, e)
+	}
+	return examples
+}
+
+func (b *docsBuilder) kindOperations(r APIResource, examples []docsExample) []docsOperation {
+	var paths []string
+	subResources := map[string]string{}
+	for p := range b.swagger.Paths.Paths {
+		m := resourcePathMatch.FindStringSubmatch(p)
+		if m != nil && m[1] == r.Group && m[2] == r.Version && m[4] == r.Resource {
+			paths = append(paths, p)
+			subResources[p] = m[7]
+		}
+	}
+	sort.Strings(paths)
+
+	var ops []docsOperation
+	for _, p := range paths {
+		item := b.swagger.Paths.Paths[p]
+		for _, m := range []struct {
+			method string
+			op     *spec.Operation
+		}{
+			{"GET", item.Get}, {"POST", item.Post}, {"PUT", item.Put}, {"PATCH", item.Patch},
+			{"DELETE", item.Delete}, {"HEAD", item.Head}, {"OPTIONS", item.Options},
+		} {
+			if m.op == nil {
+				continue
+			}
+			op := docsOperation{Method: m.method, Path: p, ID: m.op.ID, Description: m.op.Description}
+			// only the operations on the resource itself have examples, not the subresources
+			action, _ := m.op.Extensions.GetString(extensionAction)
+			if file, found := operationExamples[action]; found && len(subResources[p]) == 0 {
+				for i := range examples {
+					if examples[i].File == file {
+						op.Example = &examples[i]
+					}
+				}
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func (p *docsPage) render(file string) error {
+	toc, content := &bytes.Buffer{}, &bytes.Buffer{}
+	t := template.Must(template.New("docs").Funcs(template.FuncMap{
+		"cell":   markdownCell,
+		"indent": func(s string) string { return "    " + strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n    ") },
+		"typeExamples": func(examples []docsExample) []docsExample {
+			var result []docsExample
+			for _, e := range examples {
+				if len(e.Sample) > 0 {
+					result = append(result, e)
+				}
+			}
+			return result
+		},
+	}).Parse(docsTocTemplate + docsContentTemplate))
+	if err := t.ExecuteTemplate(toc, "toc", p); err != nil {
+		return errors.Wrapf(err, "failed rendering %s", file)
+	}
+	if err := t.ExecuteTemplate(content, "content", p); err != nil {
+		return errors.Wrapf(err, "failed rendering %s", file)
+	}
+
+	var data []byte
+	if filepath.Ext(file) == ".html" {
+		buf := &bytes.Buffer{}
+		err := htmltemplate.Must(htmltemplate.New("html").Parse(docsHTMLTemplate)).Execute(buf, map[string]interface{}{
+			"Title":   p.Title,
+			"Toc":     htmltemplate.HTML(blackfriday.Run(toc.Bytes())),
+			"Content": htmltemplate.HTML(blackfriday.Run(content.Bytes())),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed rendering %s", file)
+		}
+		data = buf.Bytes()
+	} else {
+		data = []byte(fmt.Sprintf("# %s\n\n%s\n%s", p.Title, toc.String(), content.String()))
+	}
+	os.MkdirAll(filepath.Dir(file), 0700)
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// markdownCell escapes a string for a cell of a markdown table.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "|", `\|`)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+var docsTocTemplate = `{{ define "toc" -}}
+## Table of Contents
+
+{{ range .Sections -}}
+- [{{ .Name }}](#{{ .Anchor }})
+{{- range .Subsections }}
+{{- if .Name }}
+  - [{{ .Name }}](#{{ .Anchor }})
+{{- range .Kinds }}
+    - [{{ .Kind }}](#{{ .Anchor }})
+{{- end }}
+{{- else }}
+{{- range .Kinds }}
+  - [{{ .Kind }}](#{{ .Anchor }})
+{{- end }}
+{{- end }}
+{{- end }}
+{{ end }}
+{{- end }}`
+
+var docsContentTemplate = `{{ define "fields" -}}
+{{ if . -}}
+| Field | Type | Description |
+| ----- | ---- | ----------- |
+{{ range . -}}
+| ` + "`" + `{{ .Name }}` + "`" + `{{ if .Required }} *(required)*{{ end }} | {{ .Type }} | {{ cell .Description }} |
+{{ end }}
+{{- else -}}
+*No fields.*
+{{ end }}
+{{- end }}
+
+{{- define "content" -}}
+{{ if .Overview }}{{ .Overview }}
+
+{{ end -}}
+{{ range .Sections -}}
+<a name="{{ .Anchor }}"></a>
+## {{ .Name }}
+
+{{ if .Include }}{{ .Include }}
+
+{{ end -}}
+{{ range .Subsections -}}
+{{ if .Name -}}
+<a name="{{ .Anchor }}"></a>
+### {{ .Name }}
+
+{{ end -}}
+{{ range .Kinds -}}
+<a name="{{ .Anchor }}"></a>
+#### {{ .Kind }}
+
+| Group | Version | Kind | Resource | Scope |
+| ----- | ------- | ---- | -------- | ----- |
+| ` + "`" + `{{ .Group }}` + "`" + ` | ` + "`" + `{{ .Version }}` + "`" + ` | ` + "`" + `{{ .Kind }}` + "`" + ` | ` + "`" + `{{ .Resource }}` + "`" + ` | {{ if .Namespaced }}Namespaced{{ else }}Cluster{{ end }} |
+
+{{ if .Description }}{{ .Description }}
+
+{{ end -}}
+{{ if .SubResources }}Subresources: {{ range $i, $s := .SubResources }}{{ if $i }}, {{ end }}` + "`" + `{{ $s }}` + "`" + `{{ end }}
+
+{{ end -}}
+{{ range typeExamples .Examples -}}
+{{ if .Note }}*{{ .Note }}*
+
+{{ end -}}
+{{ indent .Sample }}
+
+{{ end -}}
+{{ template "fields" .Fields }}
+{{ if .Operations -}}
+##### Operations
+
+{{ range .Operations -}}
+###### ` + "`" + `{{ .Method }} {{ .Path }}` + "`" + `
+
+{{ .Description }}
+
+{{ with .Example -}}
+{{ if .Request }}Request:
+
+{{ indent .Request }}
+
+{{ end -}}
+{{ if .Response }}Response:
+
+{{ indent .Response }}
+
+{{ end -}}
+{{ end -}}
+{{ end -}}
+{{ end -}}
+{{ end -}}
+{{ range .Definitions -}}
+<a name="{{ .Anchor }}"></a>
+#### {{ .Name }}
+
+{{ if .Description }}{{ .Description }}
+
+{{ end -}}
+{{ template "fields" .Fields }}
+{{ end -}}
+{{ end -}}
+{{ end -}}
+{{- end }}`
+
+var docsHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { margin: 0; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; }
+nav { position: fixed; top: 0; bottom: 0; left: 0; width: 280px; overflow-y: auto; padding: 1em; background: #f5f7fa; border-right: 1px solid #ddd; font-size: 14px; }
+nav ul { list-style: none; padding-left: 1em; }
+nav > ul { padding-left: 0; }
+main { margin-left: 320px; padding: 1em 2em; max-width: 960px; }
+a { color: #326ce5; text-decoration: none; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f5f7fa; }
+pre { background: #f5f7fa; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<nav>
+<h3>{{ .Title }}</h3>
+{{ .Toc }}
+</nav>
+<main>
+{{ .Content }}
+</main>
+</body>
+</html>
+`
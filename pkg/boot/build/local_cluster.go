@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+var LocalCluster bool
+var LocalIP string
+var LocalPort int32
+var ApplyLocalConfig bool
+
+// LocalConfigFile is the file of the APIServices and the Service registering the apiserver
+// running on the host, under the --output directory.
+const LocalConfigFile = "apiservice-local.yaml"
+
+// buildLocalClusterConfig registers the apiserver running on the host into the cluster of the
+// current kubeconfig context, e.g. a kind or minikube cluster.
+func buildLocalClusterConfig() {
+	if len(LocalIP) == 0 {
+		LocalIP = detectLocalIP()
+	}
+	ip := net.ParseIP(LocalIP)
+	if ip == nil {
+		klog.Fatalf("Invalid --local-ip %q", LocalIP)
+	}
+	klog.Infof("Registering the apiserver at %s", net.JoinHostPort(LocalIP, fmt.Sprintf("%d", LocalPort)))
+
+	dir := filepath.Join(ResourceConfigDir, "certificates")
+	createCerts(ip)
+	caCert, err := ioutil.ReadFile(filepath.Join(dir, "apiserver_ca.crt"))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	file := filepath.Join(ResourceConfigDir, LocalConfigFile)
+	WriteLocalConfig(file, Name, Namespace, LocalIP, LocalPort, caCert)
+	klog.Infof("Wrote the APIServices and the Service to %s", file)
+
+	if ApplyLocalConfig {
+		util.DoCmd("kubectl", "apply", "-f", file)
+	}
+
+	kubeconfig := clientcmd.RecommendedHomeFile
+	if precedence := clientcmd.NewDefaultClientConfigLoadingRules().Precedence; len(precedence) > 0 {
+		kubeconfig = precedence[0]
+	}
+	klog.Infof(`Run the apiserver on the host, delegating the authentication and authorization to the cluster:
+  bin/apiserver --secure-port=%d --etcd-servers=http://localhost:2379 \
+    --tls-cert-file=%s --tls-private-key-file=%s \
+    --kubeconfig=%s --authentication-kubeconfig=%s --authorization-kubeconfig=%s`,
+		LocalPort, filepath.Join(dir, "apiserver.crt"), filepath.Join(dir, "apiserver.key"),
+		kubeconfig, kubeconfig, kubeconfig)
+}
+
+// detectLocalIP returns the address of the host reachable from the cluster of the current
+// kubeconfig context: the gateway of the docker network of kind, or the address of the host in
+// the network of the minikube node.  Otherwise the address of the default route is guessed.
+func detectLocalIP() string {
+	config, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		klog.Fatalf("Failed loading the kubeconfig: %v", err)
+	}
+	context := config.CurrentContext
+
+	var ip string
+	switch {
+	case strings.HasPrefix(context, "kind-"):
+		ip, err = kindHostIP()
+	case isMinikubeProfile(context):
+		ip, err = minikubeHostIP(context)
+	default:
+		ip, err = defaultRouteIP()
+		if err == nil {
+			klog.Warningf("The context %q is neither of kind nor minikube, guessing %s is reachable from the cluster, "+
+				"specify --local-ip otherwise", context, ip)
+		}
+	}
+	if err != nil {
+		klog.Fatalf("Failed detecting the address of the host for the context %q, specify --local-ip: %v", context, err)
+	}
+	return ip
+}
+
+// kindHostIP returns the gateway of the docker network of the kind nodes, which is the host.
+func kindHostIP() (string, error) {
+	out, err := exec.Command("docker", "network", "inspect", "kind",
+		"--format", "{{range .IPAM.Config}}{{.Gateway}} {{end}}").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed inspecting the docker network of kind")
+	}
+	for _, gateway := range strings.Fields(string(out)) {
+		if ip := net.ParseIP(gateway); ip != nil && ip.To4() != nil {
+			return gateway, nil
+		}
+	}
+	return "", errors.Errorf("no IPv4 gateway in the docker network of kind")
+}
+
+// isMinikubeProfile returns whether minikube knows a profile of the context name, which is the
+// name of the context minikube writes.
+func isMinikubeProfile(context string) bool {
+	if _, err := exec.LookPath("minikube"); err != nil {
+		return false
+	}
+	return exec.Command("minikube", "ip", "--profile", context).Run() == nil
+}
+
+// minikubeHostIP returns the address of the host in the network of the minikube node.
+func minikubeHostIP(profile string) (string, error) {
+	out, err := exec.Command("minikube", "ip", "--profile", profile).Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed getting the address of the minikube node")
+	}
+	nodeIP := net.ParseIP(strings.TrimSpace(string(out)))
+	if nodeIP == nil {
+		return "", errors.Errorf("invalid address of the minikube node %q", strings.TrimSpace(string(out)))
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(nodeIP) && !ipNet.IP.Equal(nodeIP) {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", errors.Errorf("no address of the host in the network of the minikube node %s", nodeIP)
+}
+
+// defaultRouteIP returns the local address of the default route, no packet is sent.
+func defaultRouteIP() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
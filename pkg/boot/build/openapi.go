@@ -0,0 +1,500 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+const (
+	extensionGVK    = "x-kubernetes-group-version-kind"
+	extensionAction = "x-kubernetes-action"
+
+	scaleDefinitionName = "io.k8s.api.autoscaling.v1.Scale"
+	patchDefinitionName = "io.k8s.apimachinery.pkg.apis.meta.v1.Patch"
+)
+
+// Types of subresources served by apiserver-runtime.
+const (
+	SubResourceStatus    = "status"
+	SubResourceScale     = "scale"
+	SubResourceArbitrary = "arbitrary"
+	SubResourceConnector = "connector"
+)
+
+// APISubResource is a subresource served under an APIResource.
+type APISubResource struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Definition string `json:"definition,omitempty"`
+}
+
+// APIResource is a resource served by the aggregated apiserver of the project.
+type APIResource struct {
+	Group          string           `json:"group"`
+	Version        string           `json:"version"`
+	Kind           string           `json:"kind"`
+	Resource       string           `json:"resource"`
+	Namespaced     bool             `json:"namespaced"`
+	StorageVersion bool             `json:"storageVersion"`
+	CustomStorage  bool             `json:"customStorage"`
+	ShortNames     []string         `json:"shortNames,omitempty"`
+	Definition     string           `json:"definition"`
+	ListDefinition string           `json:"listDefinition"`
+	SubResources   []APISubResource `json:"subResources,omitempty"`
+}
+
+func (r APIResource) GroupVersion() schema.GroupVersion {
+	return schema.GroupVersion{Group: r.Group, Version: r.Version}
+}
+
+// ProjectAPIs holds the openapi definitions generated under pkg/openapi and the
+// resources registered by the api groups under pkg/apis.
+type ProjectAPIs struct {
+	Definitions spec.Definitions `json:"definitions"`
+	Resources   []APIResource    `json:"resources"`
+}
+
+// LoadProjectAPIs compiles and runs a helper program inside the project of the working
+// directory, which imports the generated GetOpenAPIDefinitions and the api groups.  No apiserver
+// or etcd is needed.  The api versions are read again on every call, as the working directory
+// may be another checkout of the project.
+func LoadProjectAPIs() (*ProjectAPIs, error) {
+	if !HasGeneratedOpenAPI() {
+		return nil, fmt.Errorf("could not find the generated openapi definitions under pkg/openapi, run openapi-gen first")
+	}
+	versionedAPIs, err := findVersionedAPIs(".")
+	if err != nil {
+		return nil, err
+	}
+	var versions []schema.GroupVersion
+	for _, a := range versionedAPIs {
+		versions = append(versions, schema.GroupVersion{Group: path.Dir(a), Version: path.Base(a)})
+	}
+
+	buf := &bytes.Buffer{}
+	err = util.RunHelperProgram("openapi", openapiHelperTemplate, openapiHelperTemplateArgs{
+		Repo:     util.GetRepo(),
+		Versions: versions,
+	}, buf)
+	if err != nil {
+		return nil, err
+	}
+	apis := &ProjectAPIs{}
+	if err := json.Unmarshal(buf.Bytes(), apis); err != nil {
+		return nil, errors.Wrap(err, "failed decoding the output of the openapi helper")
+	}
+	for _, r := range apis.Resources {
+		for _, s := range r.SubResources {
+			if _, ok := apis.Definitions[scaleDefinitionName]; s.Type == SubResourceScale && !ok {
+				for name, def := range scaleDefinitions() {
+					apis.Definitions[name] = def
+				}
+			}
+		}
+	}
+	return apis, nil
+}
+
+// HasGeneratedOpenAPI returns whether the package pkg/openapi of the project of the working
+// directory has the GetOpenAPIDefinitions generated by openapi-gen, and not only its doc.go.
+func HasGeneratedOpenAPI() bool {
+	files, _ := filepath.Glob(filepath.Join("pkg", "openapi", "*.go"))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err == nil && bytes.Contains(data, []byte("func GetOpenAPIDefinitions(")) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadOpenAPISpecFile reads a swagger.json from the disk.
+func LoadOpenAPISpecFile(file string) (*spec.Swagger, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading %s", file)
+	}
+	swagger := &spec.Swagger{}
+	if err := json.Unmarshal(data, swagger); err != nil {
+		return nil, errors.Wrapf(err, "failed parsing %s", file)
+	}
+	return swagger, nil
+}
+
+// BuildOpenAPISpec assembles an OpenAPI v2 document out of the project's definitions,
+// laying out the paths the same way the aggregated apiserver serves them.
+func BuildOpenAPISpec(apis *ProjectAPIs, title string) *spec.Swagger {
+	swagger := &spec.Swagger{
+		SwaggerProps: spec.SwaggerProps{
+			Swagger: "2.0",
+			Info: &spec.Info{
+				InfoProps: spec.InfoProps{
+					Title:   title,
+					Version: "unversioned",
+				},
+			},
+			Paths:       &spec.Paths{Paths: map[string]spec.PathItem{}},
+			Definitions: apis.Definitions,
+		},
+	}
+	for _, r := range apis.Resources {
+		addResourcePaths(swagger.Paths, r)
+	}
+	if _, found := swagger.Definitions[patchDefinitionName]; !found && len(apis.Resources) > 0 {
+		swagger.Definitions[patchDefinitionName] = *objectSchema("Patch is provided to give a concrete name and type to the Kubernetes PATCH request body.")
+	}
+	return swagger
+}
+
+var resourcePathMatch = regexp.MustCompile(
+	`^/apis/([^/]+)/([^/]+)/(namespaces/\{namespace\}/)?([^/{}]+)(/\{name\})?(/([^/{}]+))?$`)
+
+// ResourcesFromOpenAPISpec recovers the served resources out of the paths of an OpenAPI
+// v2 document, e.g. one fetched from a running server.  The storage version and the custom
+// storage are not published in the document and so are left unset.
+func ResourcesFromOpenAPISpec(swagger *spec.Swagger) []APIResource {
+	if swagger.Paths == nil {
+		return nil
+	}
+	byName := map[string]*APIResource{}
+	for p, item := range swagger.Paths.Paths {
+		m := resourcePathMatch.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		group, version, namespaced, resource, sub := m[1], m[2], len(m[3]) > 0, m[4], m[7]
+		for _, op := range operationsOf(item) {
+			gvk, ok := gvkOf(op.Extensions)
+			if !ok {
+				continue
+			}
+			key := group + "/" + version + "/" + resource
+			r, found := byName[key]
+			if !found {
+				r = &APIResource{Group: group, Version: version, Resource: resource}
+				byName[key] = r
+			}
+			r.Namespaced = r.Namespaced || namespaced
+			if len(sub) == 0 {
+				r.Kind = gvk.Kind
+				continue
+			}
+			action, _ := op.Extensions.GetString(extensionAction)
+			s := APISubResource{Name: sub, Type: SubResourceArbitrary}
+			switch {
+			case action == "connect":
+				s.Type = SubResourceConnector
+			case sub == SubResourceStatus:
+				s.Type = SubResourceStatus
+			case sub == SubResourceScale:
+				s.Type = SubResourceScale
+			}
+			if def := definitionForGVK(swagger.Definitions, gvk); len(def) > 0 {
+				s.Definition = def
+			}
+			if !hasSubResource(r.SubResources, sub) {
+				r.SubResources = append(r.SubResources, s)
+			}
+		}
+	}
+
+	var resources []APIResource
+	for _, r := range byName {
+		if len(r.Kind) == 0 {
+			continue
+		}
+		gvk := schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+		r.Definition = definitionForGVK(swagger.Definitions, gvk)
+		r.ListDefinition = definitionForGVK(swagger.Definitions, gvk.GroupVersion().WithKind(r.Kind+"List"))
+		sort.Slice(r.SubResources, func(i, j int) bool { return r.SubResources[i].Name < r.SubResources[j].Name })
+		resources = append(resources, *r)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		if resources[i].Version != resources[j].Version {
+			return resources[i].Version < resources[j].Version
+		}
+		return resources[i].Kind < resources[j].Kind
+	})
+	return resources
+}
+
+func hasSubResource(subs []APISubResource, name string) bool {
+	for _, s := range subs {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func operationsOf(item spec.PathItem) []*spec.Operation {
+	var ops []*spec.Operation
+	for _, op := range []*spec.Operation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch} {
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func gvkOf(ext spec.Extensions) (schema.GroupVersionKind, bool) {
+	v, ok := ext[extensionGVK]
+	if !ok {
+		return schema.GroupVersionKind{}, false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return schema.GroupVersionKind{}, false
+	}
+	// definitions carry a list of gvks while operations carry a single one
+	var gvks []schema.GroupVersionKind
+	if err := json.Unmarshal(data, &gvks); err == nil && len(gvks) > 0 {
+		return gvks[0], true
+	}
+	gvk := schema.GroupVersionKind{}
+	if err := json.Unmarshal(data, &gvk); err != nil || len(gvk.Kind) == 0 {
+		return schema.GroupVersionKind{}, false
+	}
+	return gvk, true
+}
+
+func definitionForGVK(definitions spec.Definitions, gvk schema.GroupVersionKind) string {
+	for name, def := range definitions {
+		if defGVK, ok := gvkOf(def.Extensions); ok && defGVK == gvk {
+			return name
+		}
+	}
+	return ""
+}
+
+func addResourcePaths(paths *spec.Paths, r APIResource) {
+	gvk := map[string]interface{}{"group": r.Group, "version": r.Version, "kind": r.Kind}
+	base := fmt.Sprintf("/apis/%s/%s", r.Group, r.Version)
+	collection := base + "/" + r.Resource
+	ident := operationIdent(r.GroupVersion())
+	scope := ""
+	var pathParams []spec.Parameter
+	if r.Namespaced {
+		collection = base + "/namespaces/{namespace}/" + r.Resource
+		scope = "Namespaced"
+		pathParams = append(pathParams, newParameter("namespace", "path", "string",
+			"object name and auth scope, such as for teams and projects"))
+	}
+	item := collection + "/{name}"
+	itemParams := append([]spec.Parameter{newParameter("name", "path", "string", "name of the "+r.Kind)}, pathParams...)
+
+	object := r.Definition
+	list := r.ListDefinition
+	paths.Paths[collection] = spec.PathItem{
+		PathItemProps: spec.PathItemProps{
+			Parameters: pathParams,
+			Get:        newOperation("list"+ident+scope+r.Kind, "list or watch objects of kind "+r.Kind, "list", gvk, list, false, listParameters()...),
+			Post:       newOperation("create"+ident+scope+r.Kind, "create a "+r.Kind, "post", gvk, object, true),
+			Delete:     newOperation("deletecollection"+ident+scope+r.Kind, "delete collection of "+r.Kind, "deletecollection", gvk, "", false, listParameters()...),
+		},
+	}
+	paths.Paths[item] = spec.PathItem{
+		PathItemProps: spec.PathItemProps{
+			Parameters: itemParams,
+			Get:        newOperation("read"+ident+scope+r.Kind, "read the specified "+r.Kind, "get", gvk, object, false),
+			Put:        newOperation("replace"+ident+scope+r.Kind, "replace the specified "+r.Kind, "put", gvk, object, true),
+			Patch:      newOperation("patch"+ident+scope+r.Kind, "partially update the specified "+r.Kind, "patch", gvk, object, false, patchBodyParameter()),
+			Delete:     newOperation("delete"+ident+scope+r.Kind, "delete a "+r.Kind, "delete", gvk, "", false),
+		},
+	}
+	if r.Namespaced {
+		paths.Paths[base+"/"+r.Resource] = spec.PathItem{
+			PathItemProps: spec.PathItemProps{
+				Get: newOperation("list"+ident+r.Kind+"ForAllNamespaces", "list or watch objects of kind "+r.Kind, "list", gvk, list, false, listParameters()...),
+			},
+		}
+	}
+
+	for _, sub := range r.SubResources {
+		subIdent := ident + scope + r.Kind + strings.Title(sub.Name)
+		subPath := item + "/" + sub.Name
+		switch sub.Type {
+		case SubResourceConnector:
+			connect := func(method string) *spec.Operation {
+				return newOperation("connect"+strings.Title(strings.ToLower(method))+subIdent,
+					"connect "+method+" requests to "+sub.Name+" of "+r.Kind, "connect", gvk, "", false)
+			}
+			paths.Paths[subPath] = spec.PathItem{
+				PathItemProps: spec.PathItemProps{
+					Parameters: itemParams,
+					Get:        connect("GET"),
+					Put:        connect("PUT"),
+					Post:       connect("POST"),
+					Delete:     connect("DELETE"),
+					Options:    connect("OPTIONS"),
+					Head:       connect("HEAD"),
+					Patch:      connect("PATCH"),
+				},
+			}
+		default:
+			subGVK := gvk
+			if sub.Type == SubResourceScale {
+				subGVK = map[string]interface{}{"group": "autoscaling", "version": "v1", "kind": "Scale"}
+			}
+			paths.Paths[subPath] = spec.PathItem{
+				PathItemProps: spec.PathItemProps{
+					Parameters: itemParams,
+					Get:        newOperation("read"+subIdent, "read "+sub.Name+" of the specified "+r.Kind, "get", subGVK, sub.Definition, false),
+					Put:        newOperation("replace"+subIdent, "replace "+sub.Name+" of the specified "+r.Kind, "put", subGVK, sub.Definition, true),
+					Patch:      newOperation("patch"+subIdent, "partially update "+sub.Name+" of the specified "+r.Kind, "patch", subGVK, sub.Definition, false, patchBodyParameter()),
+				},
+			}
+		}
+	}
+}
+
+// operationIdent camel-cases the group version the same way as the kubernetes
+// operation ids, e.g. "wardle.example.com/v1alpha1" to "WardleExampleComV1alpha1".
+func operationIdent(gv schema.GroupVersion) string {
+	ident := ""
+	for _, s := range strings.FieldsFunc(gv.Group, func(r rune) bool { return r == '.' || r == '-' }) {
+		ident += strings.Title(s)
+	}
+	return ident + strings.Title(gv.Version)
+}
+
+func newOperation(id, description, action string, gvk map[string]interface{}, definition string, withBody bool, params ...spec.Parameter) *spec.Operation {
+	op := &spec.Operation{
+		OperationProps: spec.OperationProps{
+			ID:          id,
+			Description: description,
+			Consumes:    []string{"application/json", "application/yaml"},
+			Produces:    []string{"application/json", "application/yaml"},
+			Tags:        []string{fmt.Sprintf("%s_%s", gvk["group"], gvk["version"])},
+			Responses: &spec.Responses{
+				ResponsesProps: spec.ResponsesProps{
+					StatusCodeResponses: map[int]spec.Response{
+						200: {ResponseProps: spec.ResponseProps{Description: "OK"}},
+						401: {ResponseProps: spec.ResponseProps{Description: "Unauthorized"}},
+					},
+				},
+			},
+		},
+	}
+	op.AddExtension(extensionAction, action)
+	op.AddExtension(extensionGVK, gvk)
+	if withBody && len(definition) > 0 {
+		op.Parameters = append(op.Parameters, bodyParameter(definition))
+	}
+	op.Parameters = append(op.Parameters, params...)
+	if len(definition) > 0 {
+		ok := op.Responses.StatusCodeResponses[200]
+		ok.Schema = spec.RefSchema("#/definitions/" + definition)
+		op.Responses.StatusCodeResponses[200] = ok
+	}
+	return op
+}
+
+func newParameter(name, in, typ, description string) spec.Parameter {
+	return spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Name:        name,
+			In:          in,
+			Required:    in == "path",
+			Description: description,
+		},
+		SimpleSchema: spec.SimpleSchema{Type: typ},
+	}
+}
+
+func bodyParameter(definition string) spec.Parameter {
+	return spec.Parameter{
+		ParamProps: spec.ParamProps{
+			Name:     "body",
+			In:       "body",
+			Required: true,
+			Schema:   spec.RefSchema("#/definitions/" + definition),
+		},
+	}
+}
+
+func listParameters() []spec.Parameter {
+	return []spec.Parameter{
+		newParameter("labelSelector", "query", "string",
+			"A selector to restrict the list of returned objects by their labels."),
+		newParameter("fieldSelector", "query", "string",
+			"A selector to restrict the list of returned objects by their fields."),
+		newParameter("limit", "query", "integer",
+			"The maximum number of responses to return for a list call."),
+		newParameter("continue", "query", "string",
+			"The continue option should be set when retrieving more results from the server."),
+		newParameter("resourceVersion", "query", "string",
+			"Sets a constraint on what resource versions a request may be served from."),
+		newParameter("watch", "query", "boolean",
+			"Watch for changes to the described resources and return them as a stream of add, update, and remove notifications."),
+	}
+}
+
+func patchBodyParameter() spec.Parameter {
+	return bodyParameter(patchDefinitionName)
+}
+
+func objectSchema(description string) *spec.Schema {
+	return &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Type:        []string{"object"},
+			Description: description,
+		},
+	}
+}
+
+// scaleDefinitions are the definitions of the autoscaling/v1 Scale served by the scale
+// subresource, added when the project's generated definitions don't include them.
+func scaleDefinitions() spec.Definitions {
+	scale := objectSchema("Scale represents a scaling request for a resource.").
+		SetProperty("apiVersion", *spec.StringProperty()).
+		SetProperty("kind", *spec.StringProperty()).
+		SetProperty("metadata", *spec.RefSchema("#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta")).
+		SetProperty("spec", *spec.RefSchema("#/definitions/io.k8s.api.autoscaling.v1.ScaleSpec")).
+		SetProperty("status", *spec.RefSchema("#/definitions/io.k8s.api.autoscaling.v1.ScaleStatus"))
+	scale.AddExtension(extensionGVK, []interface{}{
+		map[string]interface{}{"group": "autoscaling", "version": "v1", "kind": "Scale"},
+	})
+	scaleSpec := objectSchema("ScaleSpec describes the attributes of a scale subresource.").
+		SetProperty("replicas", *spec.Int32Property().WithDescription("desired number of instances for the scaled object."))
+	scaleStatus := objectSchema("ScaleStatus represents the current status of a scale subresource.").
+		SetProperty("replicas", *spec.Int32Property().WithDescription("actual number of observed instances of the scaled object.")).
+		SetProperty("selector", *spec.StringProperty().WithDescription("label query over pods that should match the replicas count.")).
+		WithRequired("replicas")
+	return spec.Definitions{
+		scaleDefinitionName:                     *scale,
+		"io.k8s.api.autoscaling.v1.ScaleSpec":   *scaleSpec,
+		"io.k8s.api.autoscaling.v1.ScaleStatus": *scaleStatus,
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+type openapiHelperTemplateArgs struct {
+	Repo     string
+	Versions []schema.GroupVersion
+}
+
+// openapiHelperTemplate is a program compiled inside the project.  It loads the
+// generated openapi definitions and the api groups, and prints the definitions
+// together with the resources registered into the scheme as json.
+var openapiHelperTemplate = `
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	genericopenapi "k8s.io/apiserver/pkg/endpoints/openapi"
+	"k8s.io/kube-openapi/pkg/builder"
+	"k8s.io/kube-openapi/pkg/common"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource/resourcerest"
+
+	"{{ .Repo }}/pkg/openapi"
+{{- range .Versions }}
+	{{ .Group }}{{ .Version }} "{{ $.Repo }}/pkg/apis/{{ .Group }}/{{ .Version }}"
+{{- end }}
+)
+
+type subResource struct {
+	Name       string ` + "`" + `json:"name"` + "`" + `
+	Type       string ` + "`" + `json:"type"` + "`" + `
+	Definition string ` + "`" + `json:"definition,omitempty"` + "`" + `
+}
+
+type apiResource struct {
+	Group          string        ` + "`" + `json:"group"` + "`" + `
+	Version        string        ` + "`" + `json:"version"` + "`" + `
+	Kind           string        ` + "`" + `json:"kind"` + "`" + `
+	Resource       string        ` + "`" + `json:"resource"` + "`" + `
+	Namespaced     bool          ` + "`" + `json:"namespaced"` + "`" + `
+	StorageVersion bool          ` + "`" + `json:"storageVersion"` + "`" + `
+	CustomStorage  bool          ` + "`" + `json:"customStorage"` + "`" + `
+	ShortNames     []string      ` + "`" + `json:"shortNames,omitempty"` + "`" + `
+	Definition     string        ` + "`" + `json:"definition"` + "`" + `
+	ListDefinition string        ` + "`" + `json:"listDefinition"` + "`" + `
+	SubResources   []subResource ` + "`" + `json:"subResources,omitempty"` + "`" + `
+}
+
+func main() {
+	scheme := runtime.NewScheme()
+{{- range .Versions }}
+	if err := {{ .Group }}{{ .Version }}.AddToScheme(scheme); err != nil {
+		fail(err)
+	}
+{{- end }}
+
+	namer := genericopenapi.NewDefinitionNamer(scheme)
+	goName := func(t reflect.Type) string {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return t.PkgPath() + "." + t.Name()
+	}
+	defName := func(t reflect.Type) string {
+		name, _ := namer.GetDefinitionName(goName(t))
+		return name
+	}
+
+	var resources []apiResource
+	var names []string
+	for gvk, t := range scheme.AllKnownTypes() {
+		obj, ok := reflect.New(t).Interface().(resource.Object)
+		if !ok {
+			continue
+		}
+		listType := reflect.TypeOf(obj.NewList())
+		r := apiResource{
+			Group:          gvk.Group,
+			Version:        gvk.Version,
+			Kind:           gvk.Kind,
+			Resource:       obj.GetGroupVersionResource().Resource,
+			Namespaced:     obj.NamespaceScoped(),
+			StorageVersion: obj.IsStorageVersion(),
+			CustomStorage:  hasCustomStorage(obj),
+			Definition:     defName(t),
+			ListDefinition: defName(listType),
+		}
+		names = append(names, goName(t), goName(listType))
+		if p, ok := obj.(resourcerest.ShortNamesProvider); ok {
+			r.ShortNames = p.ShortNames()
+		}
+		if _, ok := obj.(resource.ObjectWithStatusSubResource); ok {
+			r.SubResources = append(r.SubResources, subResource{Name: "status", Type: "status", Definition: r.Definition})
+		}
+		if _, ok := obj.(resource.ObjectWithScaleSubResource); ok {
+			r.SubResources = append(r.SubResources, subResource{Name: "scale", Type: "scale", Definition: "io.k8s.api.autoscaling.v1.Scale"})
+		}
+		if o, ok := obj.(resource.ObjectWithArbitrarySubResource); ok {
+			for _, sub := range o.GetArbitrarySubResources() {
+				s := subResource{Name: sub.SubResourceName(), Type: "arbitrary"}
+				if _, ok := sub.(resourcerest.Connecter); ok {
+					s.Type = "connector"
+				}
+				if subObj := sub.New(); subObj != nil {
+					s.Definition = defName(reflect.TypeOf(subObj))
+					names = append(names, goName(reflect.TypeOf(subObj)))
+				}
+				r.SubResources = append(r.SubResources, s)
+			}
+		}
+		resources = append(resources, r)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		if resources[i].Version != resources[j].Version {
+			return resources[i].Version < resources[j].Version
+		}
+		return resources[i].Kind < resources[j].Kind
+	})
+
+	config := &common.Config{
+		GetDefinitions:    openapi.GetOpenAPIDefinitions,
+		GetDefinitionName: namer.GetDefinitionName,
+	}
+	definitions := map[string]interface{}{}
+	for _, name := range names {
+		swagger, err := builder.BuildOpenAPIDefinitionsForResources(config, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping definition %s: %v\n", name, err)
+			continue
+		}
+		for k, v := range swagger.Definitions {
+			definitions[k] = v
+		}
+	}
+
+	err := json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+		"definitions": definitions,
+		"resources":   resources,
+	})
+	if err != nil {
+		fail(err)
+	}
+}
+
+func hasCustomStorage(obj interface{}) bool {
+	switch obj.(type) {
+	case resourcerest.Getter, resourcerest.Lister, resourcerest.Creator, resourcerest.Updater,
+		resourcerest.GracefulDeleter, resourcerest.CollectionDeleter, resourcerest.Watcher:
+		return true
+	}
+	return false
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+`
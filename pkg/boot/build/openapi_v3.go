@@ -0,0 +1,217 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/spec3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const (
+	definitionsRefPrefix = "#/definitions/"
+	schemasRefPrefix     = "#/components/schemas/"
+)
+
+// OpenAPIV3Specs splits an OpenAPI v2 document into one OpenAPI v3 document per group version,
+// the same way the apiserver serves them under /openapi/v3/apis/<group>/<version>.
+func OpenAPIV3Specs(swagger *spec.Swagger) (map[schema.GroupVersion]*spec3.OpenAPI, error) {
+	schemas, err := convertDefinitions(swagger.Definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := map[schema.GroupVersion]*spec3.OpenAPI{}
+	for p, item := range swagger.Paths.Paths {
+		m := resourcePathMatch.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		gv := schema.GroupVersion{Group: m[1], Version: m[2]}
+		doc, found := specs[gv]
+		if !found {
+			doc = &spec3.OpenAPI{
+				Version: "3.0.0",
+				Info:    swagger.Info,
+				Paths:   &spec3.Paths{Paths: map[string]*spec3.Path{}},
+				Components: &spec3.Components{
+					Schemas: map[string]*spec.Schema{},
+				},
+			}
+			specs[gv] = doc
+		}
+		doc.Paths.Paths[p] = convertPathItem(item)
+	}
+
+	// only carry the schemas reachable from the paths of each group version
+	for _, doc := range specs {
+		var refs []string
+		for _, p := range doc.Paths.Paths {
+			for _, op := range []*spec3.Operation{p.Get, p.Put, p.Post, p.Delete, p.Options, p.Head, p.Patch} {
+				if op == nil {
+					continue
+				}
+				if op.RequestBody != nil {
+					for _, media := range op.RequestBody.Content {
+						refs = append(refs, media.Schema.Ref.String())
+					}
+				}
+				for _, r := range op.Responses.StatusCodeResponses {
+					for _, media := range r.Content {
+						refs = append(refs, media.Schema.Ref.String())
+					}
+				}
+			}
+		}
+		for _, ref := range refs {
+			addReachableSchemas(doc.Components.Schemas, schemas, ref)
+		}
+	}
+	return specs, nil
+}
+
+// convertDefinitions rewrites the references of the v2 definitions to the v3 components.
+func convertDefinitions(definitions spec.Definitions) (map[string]*spec.Schema, error) {
+	data, err := json.Marshal(definitions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed encoding the openapi definitions")
+	}
+	data = []byte(strings.ReplaceAll(string(data), `"`+definitionsRefPrefix, `"`+schemasRefPrefix))
+	schemas := map[string]*spec.Schema{}
+	if err := json.Unmarshal(data, &schemas); err != nil {
+		return nil, errors.Wrap(err, "failed decoding the openapi definitions")
+	}
+	return schemas, nil
+}
+
+func addReachableSchemas(target, schemas map[string]*spec.Schema, ref string) {
+	name := strings.TrimPrefix(ref, schemasRefPrefix)
+	if len(name) == 0 || target[name] != nil || schemas[name] == nil {
+		return
+	}
+	target[name] = schemas[name]
+
+	var walk func(s *spec.Schema)
+	walk = func(s *spec.Schema) {
+		if ref := s.Ref.String(); len(ref) > 0 {
+			addReachableSchemas(target, schemas, ref)
+		}
+		for _, p := range s.Properties {
+			p := p
+			walk(&p)
+		}
+		if s.Items != nil && s.Items.Schema != nil {
+			walk(s.Items.Schema)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			walk(s.AdditionalProperties.Schema)
+		}
+		for i := range s.AllOf {
+			walk(&s.AllOf[i])
+		}
+	}
+	walk(schemas[name])
+}
+
+func convertPathItem(item spec.PathItem) *spec3.Path {
+	p := &spec3.Path{}
+	for _, param := range item.Parameters {
+		p.Parameters = append(p.Parameters, convertParameter(param))
+	}
+	convert := func(op *spec.Operation) *spec3.Operation {
+		if op == nil {
+			return nil
+		}
+		return convertOperation(op)
+	}
+	p.Get = convert(item.Get)
+	p.Put = convert(item.Put)
+	p.Post = convert(item.Post)
+	p.Delete = convert(item.Delete)
+	p.Options = convert(item.Options)
+	p.Head = convert(item.Head)
+	p.Patch = convert(item.Patch)
+	return p
+}
+
+func convertOperation(op *spec.Operation) *spec3.Operation {
+	result := &spec3.Operation{
+		OperationProps: spec3.OperationProps{
+			Tags:        op.Tags,
+			Description: op.Description,
+			OperationId: op.ID,
+			Responses: &spec3.Responses{
+				ResponsesProps: spec3.ResponsesProps{
+					StatusCodeResponses: map[int]*spec3.Response{},
+				},
+			},
+		},
+		VendorExtensible: op.VendorExtensible,
+	}
+	for _, param := range op.Parameters {
+		if param.In != "body" {
+			result.Parameters = append(result.Parameters, convertParameter(param))
+			continue
+		}
+		result.RequestBody = &spec3.RequestBody{
+			RequestBodyProps: spec3.RequestBodyProps{
+				Required: param.Required,
+				Content:  convertContent(op.Consumes, param.Schema),
+			},
+		}
+	}
+	for code, r := range op.Responses.StatusCodeResponses {
+		response := &spec3.Response{
+			ResponseProps: spec3.ResponseProps{Description: r.Description},
+		}
+		if r.Schema != nil {
+			response.Content = convertContent(op.Produces, r.Schema)
+		}
+		result.Responses.StatusCodeResponses[code] = response
+	}
+	return result
+}
+
+func convertParameter(param spec.Parameter) *spec3.Parameter {
+	return &spec3.Parameter{
+		ParameterProps: spec3.ParameterProps{
+			Name:        param.Name,
+			In:          param.In,
+			Description: param.Description,
+			Required:    param.Required,
+			Schema:      &spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{param.Type}}},
+		},
+	}
+}
+
+func convertContent(mediaTypes []string, s *spec.Schema) map[string]*spec3.MediaType {
+	converted := *s
+	if ref := s.Ref.String(); strings.HasPrefix(ref, definitionsRefPrefix) {
+		converted.Ref = spec.MustCreateRef(schemasRefPrefix + strings.TrimPrefix(ref, definitionsRefPrefix))
+	}
+	content := map[string]*spec3.MediaType{}
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = &spec3.MediaType{
+			MediaTypeProps: spec3.MediaTypeProps{Schema: &converted},
+		}
+	}
+	return content
+}
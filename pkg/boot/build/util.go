@@ -17,6 +17,7 @@ limitations under the License.
 package build
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
 	"path/filepath"
@@ -31,24 +32,11 @@ var vendorDir string
 
 func initApis() {
 	if len(versionedAPIs) == 0 {
-		groups, err := ioutil.ReadDir(filepath.Join("pkg", "apis"))
+		versions, err := findVersionedAPIs(".")
 		if err != nil {
-			klog.Fatalf("could not read pkg/apis directory to find api Versions")
-		}
-		for _, g := range groups {
-			if g.IsDir() {
-				versionFiles, err := ioutil.ReadDir(filepath.Join("pkg", "apis", g.Name()))
-				if err != nil {
-					klog.Fatalf("could not read pkg/apis/%s directory to find api Versions", g.Name())
-				}
-				versionMatch := regexp.MustCompile("^v\\d+(alpha\\d+|beta\\d+)*$")
-				for _, v := range versionFiles {
-					if v.IsDir() && versionMatch.MatchString(v.Name()) {
-						versionedAPIs = append(versionedAPIs, filepath.Join(g.Name(), v.Name()))
-					}
-				}
-			}
+			klog.Fatal(err)
 		}
+		versionedAPIs = versions
 	}
 	u := map[string]bool{}
 	for _, a := range versionedAPIs {
@@ -58,3 +46,30 @@ func initApis() {
 		unversionedAPIs = append(unversionedAPIs, a)
 	}
 }
+
+// findVersionedAPIs returns the <group>/<version> directories under pkg/apis of the project in
+// the root directory.
+func findVersionedAPIs(root string) ([]string, error) {
+	apisDir := filepath.Join(root, "pkg", "apis")
+	groups, err := ioutil.ReadDir(apisDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read pkg/apis directory to find api Versions")
+	}
+	versionMatch := regexp.MustCompile("^v\\d+(alpha\\d+|beta\\d+)*$")
+	var versions []string
+	for _, g := range groups {
+		if !g.IsDir() {
+			continue
+		}
+		versionFiles, err := ioutil.ReadDir(filepath.Join(apisDir, g.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read pkg/apis/%s directory to find api Versions", g.Name())
+		}
+		for _, v := range versionFiles {
+			if v.IsDir() && versionMatch.MatchString(v.Name()) {
+				versions = append(versions, filepath.Join(g.Name(), v.Name()))
+			}
+		}
+	}
+	return versions, nil
+}
@@ -48,7 +48,10 @@ var createResourceCmd = &cobra.Command{
 	Long:  `Creates an API group, version and resource.  Will not recreate group or resource if they already exist.  Creates file pkg/apis/<group>/<version>/<kind>_types.go`,
 	Example: `# Create new resource "Bee" in the "insect" group with version "v1beta1"
 # Will automatically the group and version if they do not exist
-apiserver-boot create group version resource --group insect --version v1beta1 --kind Bee`,
+apiserver-boot create group version resource --group insect --version v1beta1 --kind Bee
+
+# Store the resource as json files instead of in the default storage of the project
+apiserver-boot create group version resource --group insect --version v1beta1 --kind Hive --storage filepath`,
 	Run: RunCreateResource,
 }
 
@@ -57,6 +60,8 @@ func AddCreateResource(cmd *cobra.Command) {
 
 	createResourceCmd.Flags().StringVar(&shortName, "short-name", "", "if set, add a short name for the resource. It must be all lowercase.")
 	createResourceCmd.Flags().BoolVar(&nonNamespacedKind, "non-namespaced", false, "if set, the API kind will be non namespaced")
+	createResourceCmd.Flags().StringVar(&resourceStorage, "storage", "",
+		"storage backend of the resource, one of "+strings.Join(util.StorageBackends, "|")+", defaults to the storage of the project")
 
 	createResourceCmd.Flags().BoolVar(&skipGenerateResource, "skip-resource", false, "if set, the resources will not be generated")
 	createResourceCmd.Flags().BoolVar(&skipGenerateController, "skip-controller", false, "if set, the controller will not be generated")
@@ -72,6 +77,7 @@ func RunCreateResource(cmd *cobra.Command, args []string) {
 
 	util.GetDomain()
 	ValidateResourceFlags()
+	validateResourceStorage()
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -146,7 +152,12 @@ func createResource(boilerplate string) {
 				klog.Fatal(err)
 			}
 
-			newRegister := fmt.Sprintf("WithResource(&%s%s.%s{}).", groupName, versionName, kindName)
+			if err := addStorageImports(mainFile, scaffoldImports, resourceStorage); err != nil {
+				klog.Fatal(err)
+			}
+
+			object := fmt.Sprintf("&%s%s.%s{}", groupName, versionName, kindName)
+			newRegister := storageRegister(object, resourceStorage)
 			if err := appendMixin(mainFile, scaffoldRegister, newRegister); err != nil {
 				klog.Fatal(err)
 			}
@@ -279,7 +290,7 @@ func (in *{{.Kind}}) GetObjectMeta() *metav1.ObjectMeta {
 }
 
 func (in *{{.Kind}}) NamespaceScoped() bool {
-	return false
+	return {{ not .NonNamespacedKind }}
 }
 
 func (in *{{.Kind}}) New() runtime.Object {
@@ -145,12 +145,19 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+// SchemeGroupVersion is group version used to register these objects
+var SchemeGroupVersion = schema.GroupVersion{
+	Group:   "{{.Group}}.{{.Domain}}",
+	Version: "{{.Version}}",
+}
+
+// Resource takes an unqualified resource and returns a Group qualified GroupResource
+func Resource(resource string) schema.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
 
 var AddToScheme = func(scheme *runtime.Scheme) error {
-	metav1.AddToGroupVersion(scheme, schema.GroupVersion{
-		Group:   "{{.Group}}.{{.Domain}}",
-		Version: "{{.Version}}",
-	})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	// +kubebuilder:scaffold:install
 	return nil
 }
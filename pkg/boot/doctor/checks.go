@@ -0,0 +1,342 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/show"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+// authenticationConfigMap is the configmap of the kube-apiserver holding the CAs of the client
+// certificates and of the front proxy, which the aggregated apiservers read.
+const authenticationConfigMap = "extension-apiserver-authentication"
+
+// doctor runs the checks of the APIServices, and counts the results.
+type doctor struct {
+	ctx          context.Context
+	kubeClient   kubernetes.Interface
+	prefixWriter utils.PrefixWriter
+
+	passed, failed int
+}
+
+func (d *doctor) pass(format string, a ...interface{}) {
+	d.passed++
+	d.prefixWriter.Write(utils.LEVEL_1, "%s %s\n", color.GreenString("[PASS]"), fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) fail(hint string, format string, a ...interface{}) {
+	d.failed++
+	d.prefixWriter.Write(utils.LEVEL_1, "%s %s\n", color.RedString("[FAIL]"), fmt.Sprintf(format, a...))
+	d.prefixWriter.Write(utils.LEVEL_3, "hint: %s\n", hint)
+}
+
+func (d *doctor) skip(format string, a ...interface{}) {
+	d.prefixWriter.Write(utils.LEVEL_1, "%s %s\n", color.YellowString("[SKIP]"), fmt.Sprintf(format, a...))
+}
+
+// diagnose checks the chain from the APIService to the pods serving it.
+func (d *doctor) diagnose(apiService *apiregistrationv1.APIService) {
+	d.prefixWriter.Write(utils.LEVEL_0, "APIService %s\n", apiService.Name)
+	d.checkAvailable(apiService)
+	if apiService.Spec.Service == nil {
+		d.skip("served by the kube-apiserver, no aggregated apiserver to check")
+		return
+	}
+
+	ref := apiService.Spec.Service
+	service, err := d.kubeClient.CoreV1().Services(ref.Namespace).Get(d.ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		d.fail("apply the Service of the aggregated apiserver, or fix spec.service of the APIService",
+			"Service %s/%s: %v", ref.Namespace, ref.Name, err)
+		return
+	}
+	d.pass("Service %s/%s exists", ref.Namespace, ref.Name)
+	servicePort := d.checkServicePort(apiService, service)
+
+	serverName := fmt.Sprintf("%s.%s.svc", ref.Name, ref.Namespace)
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		d.skip("Service is an ExternalName to %s, the pods aren't in the cluster", service.Spec.ExternalName)
+		return
+	}
+	pods := d.checkPods(service)
+	if servicePort != nil {
+		d.checkEndpoints(service, servicePort)
+	}
+	if len(pods) == 0 {
+		d.skip("no pods, the serving certificate and the permissions aren't checked")
+		return
+	}
+	// the pods may mount other certificates or run as other service accounts during a rollout,
+	// each one is checked once
+	checkedCertificates := sets.NewString()
+	checkedServiceAccounts := sets.NewString()
+	for i := range pods {
+		pod := &pods[i]
+		certData, err := show.GetServingCertificate(d.ctx, d.kubeClient, pod)
+		certificate := string(certData)
+		if err != nil {
+			certificate = err.Error()
+		}
+		if !checkedCertificates.Has(certificate) {
+			checkedCertificates.Insert(certificate)
+			d.checkServingCertificate(apiService, pod, certData, err, serverName)
+		}
+		if serviceAccount := podServiceAccount(pod); !checkedServiceAccounts.Has(serviceAccount) {
+			checkedServiceAccounts.Insert(serviceAccount)
+			d.checkPermissions(service, pod)
+		}
+	}
+}
+
+func (d *doctor) checkAvailable(apiService *apiregistrationv1.APIService) {
+	for _, cond := range apiService.Status.Conditions {
+		if cond.Type != apiregistrationv1.Available {
+			continue
+		}
+		if cond.Status == apiregistrationv1.ConditionTrue {
+			d.pass("available")
+		} else {
+			d.fail("the kube-apiserver can't reach the aggregated apiserver, see the failed checks below",
+				"not available: %s: %s", cond.Reason, cond.Message)
+		}
+		return
+	}
+	d.fail("check the kube-aggregator of the kube-apiserver is running", "no Available condition")
+}
+
+// checkServicePort checks the Service exposes the port of the APIService, and returns it.
+func (d *doctor) checkServicePort(apiService *apiregistrationv1.APIService, service *corev1.Service) *corev1.ServicePort {
+	port := int32(443)
+	if apiService.Spec.Service.Port != nil {
+		port = *apiService.Spec.Service.Port
+	}
+	for i, p := range service.Spec.Ports {
+		if p.Port == port {
+			d.pass("Service exposes port %d", port)
+			return &service.Spec.Ports[i]
+		}
+	}
+	d.fail("set spec.service.port of the APIService to a port of the Service, or add the port to the Service",
+		"Service doesn't expose port %d of the APIService", port)
+	return nil
+}
+
+// checkPods checks the Service selects pods which are all ready, and returns them.
+func (d *doctor) checkPods(service *corev1.Service) []corev1.Pod {
+	if len(service.Spec.Selector) == 0 {
+		d.fail("set the selector of the Service to the labels of the pods of the aggregated apiserver",
+			"Service has no selector")
+		return nil
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector).String()
+	podList, err := d.kubeClient.CoreV1().Pods(service.Namespace).List(d.ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		d.fail("check the permissions to list pods", "listing the pods of %s: %v", selector, err)
+		return nil
+	}
+	if len(podList.Items) == 0 {
+		d.fail(fmt.Sprintf("check the labels of the pod template match the selector, and the events of the Deployment with \"kubectl -n %s describe deployment\"", service.Namespace),
+			"Service selector %s matches no pods", selector)
+		return nil
+	}
+	var notReady []string
+	for _, pod := range podList.Items {
+		if !isPodReady(&pod) {
+			notReady = append(notReady, fmt.Sprintf("%s (%s)", pod.Name, utils.GetSpinnerPodStatus(&pod)))
+		}
+	}
+	if len(notReady) > 0 {
+		d.fail(fmt.Sprintf("check the events and logs of the pods with \"kubectl -n %s describe pod\" and \"kubectl -n %s logs\"", service.Namespace, service.Namespace),
+			"pods not ready: %s", strings.Join(notReady, ", "))
+	} else {
+		d.pass("%d pods selected by %s are ready", len(podList.Items), selector)
+	}
+	return podList.Items
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkEndpoints checks the Endpoints of the Service have ready addresses for the port.
+func (d *doctor) checkEndpoints(service *corev1.Service, servicePort *corev1.ServicePort) {
+	endpoints, err := d.kubeClient.CoreV1().Endpoints(service.Namespace).Get(d.ctx, service.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		d.fail("check the permissions to get endpoints", "getting the Endpoints of the Service: %v", err)
+		return
+	}
+	ready := 0
+	if err == nil {
+		for _, subset := range endpoints.Subsets {
+			for _, p := range subset.Ports {
+				if p.Name == servicePort.Name {
+					ready += len(subset.Addresses)
+				}
+			}
+		}
+	}
+	if ready == 0 {
+		d.fail("the pods must be ready, and the targetPort of the Service must be a port of the container",
+			"Service has no ready endpoints for port %d", servicePort.Port)
+		return
+	}
+	d.pass("Service has %d ready endpoints", ready)
+}
+
+// checkServingCertificate checks the serving certificate of the pod, as returned by
+// show.GetServingCertificate, is valid for the name the kube-apiserver verifies, and signed by the
+// caBundle of the APIService.
+func (d *doctor) checkServingCertificate(apiService *apiregistrationv1.APIService, pod *corev1.Pod, certData []byte, err error, serverName string) {
+	if err != nil {
+		d.fail("mount the secret of the serving certificate and set --tls-cert-file and --tls-private-key-file, see \"apiserver-boot build config\"",
+			"serving certificate of pod %s: %v", pod.Name, err)
+		return
+	}
+	if certData == nil {
+		if apiService.Spec.InsecureSkipTLSVerify {
+			d.pass("pod %s serves a self-signed certificate, not verified by the APIService", pod.Name)
+		} else {
+			d.fail("serve a certificate signed by the caBundle of the APIService with --tls-cert-file and --tls-private-key-file, see \"apiserver-boot build config\"",
+				"pod %s serves a self-signed certificate", pod.Name)
+		}
+		return
+	}
+	cert, err := show.ParseCertificate(certData)
+	if err != nil {
+		d.fail("recreate the secret of the serving certificate", "parsing the serving certificate of pod %s: %v", pod.Name, err)
+		return
+	}
+
+	if time.Now().After(cert.NotAfter) {
+		d.fail("renew the serving certificate, e.g. remove config/certificates/apiserver.crt and run \"apiserver-boot build config\" again",
+			"serving certificate of pod %s expired at %v", pod.Name, cert.NotAfter)
+	} else {
+		d.pass("serving certificate of pod %s is valid until %v", pod.Name, cert.NotAfter)
+	}
+	if err := cert.VerifyHostname(serverName); err != nil {
+		d.fail(fmt.Sprintf("issue the serving certificate for %s, e.g. by \"apiserver-boot build config\" with the --name and --namespace of the Service", serverName),
+			"serving certificate of pod %s isn't valid for %s, its names are %s", pod.Name, serverName, strings.Join(cert.DNSNames, ", "))
+	} else {
+		d.pass("serving certificate of pod %s is valid for %s", pod.Name, serverName)
+	}
+
+	if apiService.Spec.InsecureSkipTLSVerify {
+		d.skip("insecureSkipTLSVerify is set, the caBundle isn't checked")
+		return
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(apiService.Spec.CABundle) {
+		d.fail("set the caBundle of the APIService to the base64 CA signing the serving certificate, e.g. of config/certificates/apiserver_ca.crt",
+			"caBundle of the APIService has no certificate")
+		return
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName:   serverName,
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		d.fail("set the caBundle of the APIService to the CA signing the serving certificate, or re-issue the certificate with the CA",
+			"serving certificate of pod %s isn't verified by the caBundle: %v", pod.Name, err)
+		return
+	}
+	d.pass("serving certificate of pod %s is signed by the caBundle", pod.Name)
+}
+
+// checkPermissions checks the service account of the pod may delegate the authentication and
+// the authorization to the kube-apiserver, and read its client CAs.
+func (d *doctor) checkPermissions(service *corev1.Service, pod *corev1.Pod) {
+	serviceAccount := podServiceAccount(pod)
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, serviceAccount)
+	flag := fmt.Sprintf("--serviceaccount=%s:%s", pod.Namespace, serviceAccount)
+
+	delegator, err := d.allowed(pod.Namespace, user,
+		authorizationv1.ResourceAttributes{Group: "authentication.k8s.io", Resource: "tokenreviews", Verb: "create"},
+		authorizationv1.ResourceAttributes{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verb: "create"})
+	switch {
+	case err != nil:
+		d.fail("check the permissions to create subjectaccessreviews", "reviewing the access of %s: %v", user, err)
+	case delegator:
+		d.pass("%s may create tokenreviews and subjectaccessreviews", user)
+	default:
+		d.fail(fmt.Sprintf("kubectl create clusterrolebinding %s-apiserver-auth-delegator --clusterrole=system:auth-delegator %s", service.Name, flag),
+			"%s may not create tokenreviews and subjectaccessreviews, it isn't bound to system:auth-delegator", user)
+	}
+
+	reader, err := d.allowed(pod.Namespace, user, authorizationv1.ResourceAttributes{
+		Namespace: metav1.NamespaceSystem, Resource: "configmaps", Name: authenticationConfigMap, Verb: "get",
+	})
+	switch {
+	case err != nil:
+		d.fail("check the permissions to create subjectaccessreviews", "reviewing the access of %s: %v", user, err)
+	case reader:
+		d.pass("%s may read the configmap %s/%s", user, metav1.NamespaceSystem, authenticationConfigMap)
+	default:
+		d.fail(fmt.Sprintf("kubectl -n %s create rolebinding %s-apiserver-auth-reader --role=extension-apiserver-authentication-reader %s",
+			metav1.NamespaceSystem, service.Name, flag),
+			"%s may not read the configmap %s/%s", user, metav1.NamespaceSystem, authenticationConfigMap)
+	}
+}
+
+// podServiceAccount returns the name of the service account the pod runs as.
+func podServiceAccount(pod *corev1.Pod) string {
+	if len(pod.Spec.ServiceAccountName) == 0 {
+		return "default"
+	}
+	return pod.Spec.ServiceAccountName
+}
+
+// allowed returns whether the service account of the namespace is allowed all the attributes.
+func (d *doctor) allowed(namespace, user string, attributes ...authorizationv1.ResourceAttributes) (bool, error) {
+	for i := range attributes {
+		review, err := d.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(d.ctx, &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               user,
+				Groups:             []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"},
+				ResourceAttributes: &attributes[i],
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		if !review.Status.Allowed {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [group]",
+	Short: "Diagnose the aggregation of the api groups served by aggregated apiservers.",
+	Long: `Diagnose the aggregation of the api groups served by aggregated apiservers.
+
+For each APIService of the group, or of all the groups served by aggregated apiservers, checks:
+- the APIService is available
+- the Service exists and exposes the port of the APIService
+- the selector of the Service matches pods, which are ready and are endpoints of the Service
+- the serving certificate of the pods is valid for "<service>.<namespace>.svc" and signed by
+  the caBundle of the APIService
+- the service account of the pods may delegate the authentication and authorization, and read
+  the extension-apiserver-authentication configmap
+
+Each failed check is printed with a hint to fix it.  The command exits non-zero if any check
+failed, so it can be used in CI.`,
+	Example: `# Diagnose the api group foo.example.com
+apiserver-boot doctor foo.example.com
+
+# Diagnose all the aggregated api groups of the cluster
+apiserver-boot doctor`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  RunDoctor,
+}
+
+var kubeConfigFlags *genericclioptions.ConfigFlags
+
+func AddDoctor(cmd *cobra.Command) {
+	cmd.AddCommand(doctorCmd)
+
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	// the namespaces are the ones of the Services of the APIServices
+	kubeConfigFlags.Namespace = nil
+	kubeConfigFlags.AddFlags(doctorCmd.Flags())
+}
+
+func RunDoctor(cmd *cobra.Command, args []string) {
+	config, err := kubeConfigFlags.ToRESTConfig()
+	if err != nil {
+		klog.Fatalf("Failed building kube client config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed building kube client: %v", err)
+	}
+	aggregatorClient, err := clientset.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed building kube-aggregator client: %v", err)
+	}
+
+	ctx := context.Background()
+	apiServiceList, err := aggregatorClient.ApiregistrationV1().APIServices().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Fatalf("Failed listing APIServices: %v", err)
+	}
+	var apiServices []apiregistrationv1.APIService
+	for _, a := range apiServiceList.Items {
+		if len(args) > 0 && a.Spec.Group != args[0] {
+			continue
+		}
+		if len(args) == 0 && a.Spec.Service == nil {
+			// served by the kube-apiserver itself
+			continue
+		}
+		apiServices = append(apiServices, a)
+	}
+	if len(apiServices) == 0 {
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "No APIService found for the group %s\n", args[0])
+		} else {
+			fmt.Fprintf(os.Stderr, "No APIService served by an aggregated apiserver found\n")
+		}
+		os.Exit(1)
+	}
+	sort.Slice(apiServices, func(i, j int) bool {
+		return apiServices[i].Name < apiServices[j].Name
+	})
+
+	d := &doctor{
+		ctx:          ctx,
+		kubeClient:   kubeClient,
+		prefixWriter: utils.NewPrefixWriter(os.Stdout),
+	}
+	for i := range apiServices {
+		d.diagnose(&apiServices[i])
+	}
+
+	if d.failed > 0 {
+		fmt.Printf("%s\n", color.RedString("%d of %d checks failed", d.failed, d.failed+d.passed))
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", color.GreenString("All %d checks passed", d.passed))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// defaultEndpoint is the etcd started by "run local".
+const defaultEndpoint = "http://localhost:2379"
+
+var endpoint string
+
+var etcdCmd = &cobra.Command{
+	Use:   "etcd",
+	Short: "Command group for inspecting and restoring the etcd of run local.",
+	Long:  `Command group for inspecting and restoring the etcd of run local.`,
+	Example: `# Save the content of the local etcd
+apiserver-boot etcd snapshot save dataset.db
+
+# Show the objects stored by the aggregated apiserver
+apiserver-boot etcd get --prefix /registry/sample-apiserver/foo.example.com`,
+	Run: RunEtcd,
+}
+
+func AddEtcd(cmd *cobra.Command) {
+	cmd.AddCommand(etcdCmd)
+	AddSnapshot(etcdCmd)
+	AddGet(etcdCmd)
+}
+
+func RunEtcd(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
+
+func addEndpointFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&endpoint, "etcd", defaultEndpoint, "endpoint of the etcd")
+}
+
+func clientConfig() clientv3.Config {
+	return clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 10 * time.Second,
+	}
+}
+
+func newClient() (*clientv3.Client, error) {
+	client, err := clientv3.New(clientConfig())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed connecting to %s", endpoint)
+	}
+	return client, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
+	"sigs.k8s.io/yaml"
+)
+
+// Encodings of the stored values.
+const (
+	encodingJSON      = "json"
+	encodingProtobuf  = "protobuf"
+	encodingEncrypted = "encrypted"
+	encodingUnknown   = "unknown"
+)
+
+var (
+	prefix   string
+	keysOnly bool
+	output   string
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Show the objects stored in the etcd under a key or a prefix.",
+	Long: `Show the objects stored in the etcd under a key or a prefix.
+
+The objects stored as json are shown as they are, the objects stored as protobuf are decoded
+with the scheme of the api groups under pkg/apis by a helper program compiled inside the
+project.  Encrypted objects are only listed.
+
+The apiservers built with apiserver-runtime store the objects under
+"/registry/sample-apiserver/<group>/<resource>/[<namespace>/]<name>".  The command also reads the
+kine of "run local --storage mysql|sqlite", which is served at the same endpoint.`,
+	Example: `# Show all the objects of the group foo.example.com
+apiserver-boot etcd get --prefix /registry/sample-apiserver/foo.example.com
+
+# Show a single object as json
+apiserver-boot etcd get /registry/sample-apiserver/foo.example.com/bars/bar-1 -o json
+
+# List the keys of all the objects
+apiserver-boot etcd get --keys-only`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  RunGet,
+}
+
+func AddGet(cmd *cobra.Command) {
+	cmd.AddCommand(getCmd)
+	addEndpointFlag(getCmd)
+	getCmd.Flags().StringVar(&prefix, "prefix", "/registry/",
+		"prefix of the keys to show, ignored if a key is given")
+	getCmd.Flags().BoolVar(&keysOnly, "keys-only", false, "if true, only print the keys")
+	getCmd.Flags().StringVarP(&output, "output", "o", "yaml", "output format, one of yaml or json")
+}
+
+// storedValue is a value of the etcd as shown by get.
+type storedValue struct {
+	Key         string          `json:"key"`
+	ModRevision int64           `json:"modRevision"`
+	Encoding    string          `json:"encoding"`
+	Object      json.RawMessage `json:"object,omitempty"`
+	Value       string          `json:"value,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+func RunGet(cmd *cobra.Command, args []string) {
+	if output != "yaml" && output != "json" {
+		klog.Fatalf("Unsupported output %q, expected yaml or json", output)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer client.Close()
+
+	var resp *clientv3.GetResponse
+	if len(args) > 0 {
+		resp, err = client.Get(context.Background(), args[0])
+	} else {
+		resp, err = client.Get(context.Background(), prefix, clientv3.WithPrefix())
+	}
+	if err != nil {
+		klog.Fatalf("Failed reading %s: %v", endpoint, err)
+	}
+
+	if keysOnly {
+		for _, kv := range resp.Kvs {
+			fmt.Println(string(kv.Key))
+		}
+		return
+	}
+
+	values := []storedValue{}
+	protobufValues := map[string][]byte{}
+	for _, kv := range resp.Kvs {
+		v := storedValue{Key: string(kv.Key), ModRevision: kv.ModRevision}
+		switch {
+		case bytes.HasPrefix(kv.Value, []byte("k8s\x00")):
+			v.Encoding = encodingProtobuf
+			protobufValues[v.Key] = kv.Value
+		case bytes.HasPrefix(kv.Value, []byte("k8s:enc:")):
+			v.Encoding = encodingEncrypted
+		case json.Valid(kv.Value):
+			v.Encoding = encodingJSON
+			v.Object = kv.Value
+		default:
+			v.Encoding = encodingUnknown
+			v.Value = string(kv.Value)
+		}
+		values = append(values, v)
+	}
+
+	// only compiles the helper if the project's scheme is needed
+	if len(protobufValues) > 0 {
+		decoded, err := build.DecodeProjectObjects(protobufValues)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		for i := range values {
+			if d, ok := decoded[values[i].Key]; ok {
+				values[i].Object = d.Object
+				values[i].Error = d.Error
+			}
+		}
+	}
+
+	var data []byte
+	if output == "json" {
+		data, err = json.MarshalIndent(values, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(values)
+	}
+	if err != nil {
+		klog.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
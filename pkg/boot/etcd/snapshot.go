@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.uber.org/zap"
+	"k8s.io/klog/v2"
+)
+
+// defaultDataDir is the data directory of the etcd started by "run local", in the directory
+// of the project.
+const defaultDataDir = "default.etcd"
+
+var dataDir string
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore snapshots of the etcd of run local.",
+	Long: `Save and restore snapshots of the etcd of run local.
+
+The snapshots are only supported by the etcd storage, for the SQLite storage copy the database
+file of "run local --sqlite-file" instead.`,
+	Example: `# Save the content of the local etcd
+apiserver-boot etcd snapshot save dataset.db
+
+# Stop run local, and reset the local etcd to the snapshot
+apiserver-boot etcd snapshot restore dataset.db`,
+	Run: RunSnapshot,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:     "save <file>",
+	Short:   "Save a snapshot of the etcd to a file.",
+	Long:    `Save a snapshot of the etcd to a file.`,
+	Example: `apiserver-boot etcd snapshot save dataset.db`,
+	Args:    cobra.ExactArgs(1),
+	Run:     RunSnapshotSave,
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Replace the data directory of the local etcd with a snapshot.",
+	Long: `Replace the data directory of the local etcd with a snapshot.
+
+The etcd must be stopped, which is checked by connecting to the endpoint of --etcd.  The data
+directory is restored from the snapshot while the etcd is not running, and the etcd started
+again by "run local" serves the objects of the snapshot.  The current content of the data
+directory is removed.`,
+	Example: `# Restore the data directory of the etcd of run local
+apiserver-boot etcd snapshot restore dataset.db
+
+# Restore the data directory of an etcd listening on another port
+apiserver-boot etcd snapshot restore dataset.db --data-dir etcd-data --etcd http://localhost:22379`,
+	Args: cobra.ExactArgs(1),
+	Run:  RunSnapshotRestore,
+}
+
+func AddSnapshot(cmd *cobra.Command) {
+	cmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+
+	addEndpointFlag(snapshotSaveCmd)
+	addEndpointFlag(snapshotRestoreCmd)
+	snapshotRestoreCmd.Flags().StringVar(&dataDir, "data-dir", defaultDataDir,
+		"data directory of the etcd to restore")
+}
+
+func RunSnapshot(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
+
+func RunSnapshotSave(cmd *cobra.Command, args []string) {
+	file := args[0]
+	manager := snapshot.NewV3(zap.NewNop())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := manager.Save(ctx, clientConfig(), file); err != nil {
+		klog.Fatalf("Failed saving the snapshot of %s, only etcd supports snapshots: %v", endpoint, err)
+	}
+
+	status, err := manager.Status(file)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	fmt.Printf("Saved %s: revision %d, %d keys, %d bytes\n", file, status.Revision, status.TotalKey, status.TotalSize)
+}
+
+func RunSnapshotRestore(cmd *cobra.Command, args []string) {
+	file := args[0]
+	if isServing(endpoint) {
+		klog.Fatalf("%s is serving, stop the etcd of the data directory before restoring the snapshot", endpoint)
+	}
+
+	// restores aside first, so that the data directory is kept if the snapshot is invalid
+	restoredDir := dataDir + ".restore"
+	if err := os.RemoveAll(restoredDir); err != nil {
+		klog.Fatal(err)
+	}
+	manager := snapshot.NewV3(zap.NewNop())
+	err := manager.Restore(snapshot.RestoreConfig{
+		SnapshotPath:  file,
+		OutputDataDir: restoredDir,
+		// the defaults of etcd, which is started without flags by run local
+		Name:                "default",
+		PeerURLs:            []string{"http://localhost:2380"},
+		InitialCluster:      "default=http://localhost:2380",
+		InitialClusterToken: "etcd-cluster",
+	})
+	if err != nil {
+		os.RemoveAll(restoredDir)
+		klog.Fatalf("Failed restoring %s: %v", file, err)
+	}
+	if err := os.RemoveAll(dataDir); err != nil {
+		klog.Fatal(err)
+	}
+	if err := os.Rename(restoredDir, dataDir); err != nil {
+		klog.Fatal(err)
+	}
+	fmt.Printf("Restored %s to %s\n", file, dataDir)
+}
+
+// isServing returns whether something listens on the host of the endpoint.
+func isServing(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -19,6 +19,7 @@ package init_repo
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -30,16 +31,20 @@ import (
 )
 
 var repoCmd = &cobra.Command{
-	Use:     "repo",
-	Short:   "Initialize a repo with the apiserver scaffolding",
-	Long:    `Initialize a repo with the apiserver scaffolding`,
-	Example: `apiserver-boot init repo --domain mydomain`,
-	Run:     RunInitRepo,
+	Use:   "repo",
+	Short: "Initialize a repo with the apiserver scaffolding",
+	Long:  `Initialize a repo with the apiserver scaffolding`,
+	Example: `apiserver-boot init repo --domain mydomain
+
+# Store the resources in a MySQL database through kine instead of etcd by default
+apiserver-boot init repo --domain mydomain --storage mysql`,
+	Run: RunInitRepo,
 }
 
 var domain string
 var copyright string
 var moduleName string
+var storage string
 
 func AddInitRepo(cmd *cobra.Command) {
 	cmd.AddCommand(repoCmd)
@@ -49,12 +54,15 @@ func AddInitRepo(cmd *cobra.Command) {
 	repoCmd.Flags().StringVar(&copyright, "copyright", filepath.Join("hack", "boilerplate.go.txt"), "Location of copyright boilerplate file.")
 	repoCmd.Flags().StringVar(&moduleName, "module-name", "",
 		"the module name of the go mod project, required if the project uses go module outside GOPATH")
+	repoCmd.Flags().StringVar(&storage, "storage", util.StorageEtcd,
+		"default storage backend of the resources, one of "+strings.Join(util.StorageBackends, "|"))
 }
 
 func RunInitRepo(cmd *cobra.Command, args []string) {
 	if len(domain) == 0 {
 		klog.Fatal("Must specify --domain")
 	}
+	util.ValidateStorage(storage)
 
 	if len(moduleName) == 0 {
 		if err := util.LoadRepoFromGoPath(); err != nil {
@@ -116,6 +124,7 @@ func createApiserver(boilerplate string) {
 			domain,
 			boilerplate,
 			util.GetRepo(),
+			storage,
 		})
 
 }
@@ -151,6 +160,7 @@ func createAPIs(boilerplate string) {
 		apisDocTemplateArguments{
 			boilerplate,
 			domain,
+			storage,
 		})
 }
 
@@ -170,11 +180,13 @@ type apiserverTemplateArguments struct {
 	Domain      string
 	BoilerPlate string
 	Repo        string
+	Storage     string
 }
 
 type apisDocTemplateArguments struct {
 	BoilerPlate string
 	Domain      string
+	Storage     string
 }
 
 type goModTemplateArguments struct {
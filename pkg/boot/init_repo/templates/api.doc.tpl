@@ -5,5 +5,6 @@
 
 //
 // +domain={{.Domain}}
+// +storage={{.Storage}}
 
 package apis
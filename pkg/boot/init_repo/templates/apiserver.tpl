@@ -12,6 +12,9 @@ import (
 func main() {
 	err := builder.APIServer.
 		// +kubebuilder:scaffold:resource-register
+{{- if eq .Storage "filepath" }}
+		WithoutEtcd().
+{{- end }}
 		Execute()
 	if err != nil {
 		klog.Fatal(err)
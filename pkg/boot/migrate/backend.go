@@ -0,0 +1,202 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/run"
+)
+
+// storedObject is an object as encoded by the apiserver in a storage, with the revision it was
+// last written at for the etcd storages.
+type storedObject struct {
+	data     []byte
+	revision int64
+}
+
+// backend reads and writes the objects of the resources by their "<namespace>/<name>" key, or
+// "<name>" for the cluster-scoped resources.
+type backend interface {
+	List(ctx context.Context, gr schema.GroupResource) (map[string]storedObject, error)
+	// Put writes the object, if it's unchanged since it was listed at the revision, 0 if absent
+	Put(ctx context.Context, gr schema.GroupResource, key string, data []byte, revision int64) error
+	Close()
+}
+
+// newBackend connects to the storage of the url:
+//   - "http://" and "https://" are etcd endpoints
+//   - "mysql://", "postgres://" and "sqlite://" are SQL databases read and written through kine,
+//     with the same DSN as the --etcd-servers of the apiserver
+//   - "file://" is the root directory of the filepath storage
+func newBackend(ctx context.Context, url, etcdPrefix string) (backend, error) {
+	scheme := strings.SplitN(url, "://", 2)[0]
+	switch scheme {
+	case "file":
+		return &filepathBackend{root: strings.TrimPrefix(url, "file://")}, nil
+	case "http", "https":
+		return newEtcdBackend(url, etcdPrefix, func() {})
+	case "mysql", "postgres", "sqlite":
+		listenAddress, err := freeListenAddress()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		endpoint, err := run.RunKine(ctx, url, listenAddress)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return newEtcdBackend(endpoint, etcdPrefix, cancel)
+	}
+	return nil, fmt.Errorf("unsupported storage %q, expected an etcd endpoint, a kine DSN or a file:// directory", url)
+}
+
+// freeListenAddress returns a local address for kine, so that the source and the destination
+// can both be served by kine.
+func freeListenAddress() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return "tcp://" + l.Addr().String(), nil
+}
+
+// etcdBackend stores the objects under "<etcd-prefix>/<group>/<resource>/", the same keys as
+// the apiserver.
+type etcdBackend struct {
+	client *clientv3.Client
+	prefix string
+	stop   func()
+}
+
+func newEtcdBackend(endpoint, prefix string, stop func()) (*etcdBackend, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		stop()
+		return nil, errors.Wrapf(err, "failed connecting to %s", endpoint)
+	}
+	return &etcdBackend{client: client, prefix: prefix, stop: stop}, nil
+}
+
+func (b *etcdBackend) resourcePrefix(gr schema.GroupResource) string {
+	return path.Join(b.prefix, gr.Group, gr.Resource) + "/"
+}
+
+func (b *etcdBackend) List(ctx context.Context, gr schema.GroupResource) (map[string]storedObject, error) {
+	prefix := b.resourcePrefix(gr)
+	resp, err := b.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed listing %s", prefix)
+	}
+	objects := map[string]storedObject{}
+	for _, kv := range resp.Kvs {
+		objects[strings.TrimPrefix(string(kv.Key), prefix)] = storedObject{data: kv.Value, revision: kv.ModRevision}
+	}
+	return objects, nil
+}
+
+// Put writes the object by a transaction conditioned on its revision, a revision of 0 creating
+// it.  The transactions have the same form as those of the apiserver, the only ones kine
+// accepts: a create has no else branch, and an update gets the object in its else branch.
+func (b *etcdBackend) Put(ctx context.Context, gr schema.GroupResource, key string, data []byte, revision int64) error {
+	k := b.resourcePrefix(gr) + key
+	txn := b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(k), "=", revision)).
+		Then(clientv3.OpPut(k, string(data)))
+	if revision != 0 {
+		txn = txn.Else(clientv3.OpGet(k))
+	}
+	resp, err := txn.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "failed writing %s", k)
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("%s has been modified during the migration", k)
+	}
+	return nil
+}
+
+func (b *etcdBackend) Close() {
+	b.client.Close()
+	b.stop()
+}
+
+// filepathBackend stores the objects as "<root>/<group>/<resource>/[<namespace>/]<name>.json",
+// the same files as the filepath storage of apiserver-runtime.
+type filepathBackend struct {
+	root string
+}
+
+func (b *filepathBackend) resourceDir(gr schema.GroupResource) string {
+	return filepath.Join(b.root, gr.Group, gr.Resource)
+}
+
+func (b *filepathBackend) List(ctx context.Context, gr schema.GroupResource) (map[string]storedObject, error) {
+	dir := b.resourceDir(gr)
+	objects := map[string]storedObject{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() || filepath.Ext(p) != ".json" {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		objects[filepath.ToSlash(strings.TrimSuffix(rel, ".json"))] = storedObject{data: data}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed listing %s", dir)
+	}
+	return objects, nil
+}
+
+func (b *filepathBackend) Put(ctx context.Context, gr schema.GroupResource, key string, data []byte, revision int64) error {
+	file := filepath.Join(b.resourceDir(gr), filepath.FromSlash(key)+".json")
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return errors.Wrapf(err, "failed creating the directory of %s", file)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed writing %s", file)
+	}
+	return nil
+}
+
+func (b *filepathBackend) Close() {}
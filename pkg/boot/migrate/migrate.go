@@ -0,0 +1,40 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Command group for migrating the stored resources.",
+	Long:  `Command group for migrating the stored resources.`,
+	Example: `# Move the resources from the local etcd to a SQLite database
+apiserver-boot migrate storage --from http://127.0.0.1:2379 --to sqlite://db/state.db`,
+	Run: RunMigrate,
+}
+
+func AddMigrate(cmd *cobra.Command) {
+	cmd.AddCommand(migrateCmd)
+	AddMigrateStorage(migrateCmd)
+	AddMigrateStorageVersion(migrateCmd)
+}
+
+func RunMigrate(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+var (
+	storageVersionGroup    string
+	storageVersionResource string
+	storageVersionVersion  string
+	checkpointFile         string
+	pageSize               int64
+	qps                    float32
+	burst                  int
+)
+
+var kubeConfigFlags *genericclioptions.ConfigFlags
+
+var migrateStorageVersionCmd = &cobra.Command{
+	Use:   "storage-version",
+	Short: "Rewrite the objects of a resource so that they are stored in the current storage version.",
+	Long: `Rewrite the objects of a resource so that they are stored in the current storage version.
+
+The objects are only converted to the version returned by IsStorageVersion() when they are
+written, so after changing the storage version of a resource the objects already stored stay
+encoded in the previous version.  The command pages through the objects of the resource from the
+aggregated apiserver and updates each of them without any change, which makes the apiserver
+encode them again in the storage version.
+
+The updates are rate limited by --qps and --burst, and retried on conflicts with the latest
+object.  The objects deleted during the migration are skipped.
+
+The key of the last migrated object is written to the checkpoint file, so an interrupted
+migration is resumed from there by running the command again.  Rewriting an object again is
+harmless, so the objects ordered before the checkpoint by the storage are only skipped to save
+time.  The checkpoint file is removed when the migration completes.`,
+	Example: `# Rewrite all the bars of foo.example.com in their storage version
+apiserver-boot migrate storage-version --group foo.example.com --resource bars
+
+# Rewrite the bars through the local apiserver of "run local", at most 5 updates per second
+apiserver-boot migrate storage-version --group foo.example.com --resource bars \
+    --kubeconfig kubeconfig --qps 5`,
+	Run: RunMigrateStorageVersion,
+}
+
+func AddMigrateStorageVersion(cmd *cobra.Command) {
+	cmd.AddCommand(migrateStorageVersionCmd)
+
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	// all the namespaces are migrated
+	kubeConfigFlags.Namespace = nil
+	kubeConfigFlags.AddFlags(migrateStorageVersionCmd.Flags())
+
+	migrateStorageVersionCmd.Flags().StringVar(&storageVersionGroup, "group", "", "group of the migrated resource")
+	migrateStorageVersionCmd.Flags().StringVar(&storageVersionResource, "resource", "", "plural name of the migrated resource")
+	migrateStorageVersionCmd.Flags().StringVar(&storageVersionVersion, "version", "",
+		"version to read and write the objects with, defaults to the preferred version of the group")
+	migrateStorageVersionCmd.Flags().StringVar(&checkpointFile, "checkpoint-file", "",
+		"file to record the progress in, defaults to storage-version-<resource>.<group>.json")
+	migrateStorageVersionCmd.Flags().Int64Var(&pageSize, "page-size", 500, "number of objects to list per request")
+	migrateStorageVersionCmd.Flags().Float32Var(&qps, "qps", 10, "maximum number of updates per second")
+	migrateStorageVersionCmd.Flags().IntVar(&burst, "burst", 20, "maximum burst of updates")
+}
+
+// checkpoint is the progress of the migration of a resource.
+type checkpoint struct {
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+	// LastKey is the "<namespace>/<name>", or "<name>", of the last migrated object
+	LastKey string `json:"lastKey"`
+	// Migrated is the number of objects migrated so far
+	Migrated int `json:"migrated"`
+}
+
+func RunMigrateStorageVersion(cmd *cobra.Command, args []string) {
+	if len(storageVersionGroup) == 0 || len(storageVersionResource) == 0 {
+		klog.Fatal("Must specify --group and --resource")
+	}
+	if len(checkpointFile) == 0 {
+		checkpointFile = fmt.Sprintf("storage-version-%s.%s.json", storageVersionResource, storageVersionGroup)
+	}
+
+	config, err := kubeConfigFlags.ToRESTConfig()
+	if err != nil {
+		klog.Fatalf("Failed building kube client config: %v", err)
+	}
+	// the updates are limited by --qps, the lists and the retries shouldn't wait behind them
+	config.QPS = qps * 2
+	config.Burst = burst * 2
+
+	gvr, err := getStorageVersionResource(config)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed building dynamic client: %v", err)
+	}
+
+	cp, err := loadCheckpoint(checkpointFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if len(cp.LastKey) > 0 {
+		klog.Infof("Resuming the migration of %s after %q from %s", gvr.GroupResource(), cp.LastKey, checkpointFile)
+	}
+
+	ctx := util.CancelWhenSignaled(context.Background())
+	if err := migrateStorageVersion(ctx, client.Resource(gvr), gvr.GroupResource(), cp); err != nil {
+		klog.Fatalf("%v, run the command again to resume from %s", err, checkpointFile)
+	}
+	if err := os.Remove(checkpointFile); err != nil && !os.IsNotExist(err) {
+		klog.Fatal(err)
+	}
+	fmt.Printf("%s: migrated %d objects to the storage version of %s\n", gvr.GroupResource(), cp.Migrated, gvr.GroupVersion())
+}
+
+// getStorageVersionResource returns the resource of --group and --resource with the version of
+// --version, or the preferred version of the group.
+func getStorageVersionResource(config *rest.Config) (schema.GroupVersionResource, error) {
+	gvr := schema.GroupVersionResource{
+		Group:    storageVersionGroup,
+		Version:  storageVersionVersion,
+		Resource: storageVersionResource,
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return gvr, errors.Wrap(err, "failed building discovery client")
+	}
+	if len(gvr.Version) == 0 {
+		groups, err := discoveryClient.ServerGroups()
+		if err != nil {
+			return gvr, errors.Wrap(err, "failed api-discovery")
+		}
+		for _, g := range groups.Groups {
+			if g.Name == gvr.Group {
+				gvr.Version = g.PreferredVersion.Version
+			}
+		}
+		if len(gvr.Version) == 0 {
+			return gvr, fmt.Errorf("group %q is not served", gvr.Group)
+		}
+	}
+
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return gvr, errors.Wrapf(err, "failed api-discovery for %q", gvr.GroupVersion())
+	}
+	for _, r := range resources.APIResources {
+		if r.Name != gvr.Resource {
+			continue
+		}
+		if !hasVerbs(r.Verbs, "list", "update") {
+			return gvr, fmt.Errorf("%s doesn't support list and update, verbs: %v", gvr, r.Verbs)
+		}
+		return gvr, nil
+	}
+	return gvr, fmt.Errorf("resource %q is not served in %q", gvr.Resource, gvr.GroupVersion())
+}
+
+func hasVerbs(verbs metav1.Verbs, required ...string) bool {
+	for _, v := range required {
+		found := false
+		for _, verb := range verbs {
+			found = found || verb == v
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateStorageVersion updates every object of the resource listed after the last key of the
+// checkpoint, saving the checkpoint after each of them.
+func migrateStorageVersion(ctx context.Context, client dynamic.NamespaceableResourceInterface, gr schema.GroupResource, cp *checkpoint) error {
+	limiter := flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	defer limiter.Stop()
+
+	continueToken := ""
+	for {
+		list, err := client.List(ctx, metav1.ListOptions{Limit: pageSize, Continue: continueToken})
+		if apierrors.IsResourceExpired(err) {
+			// the continue token is compacted, lists again from the checkpoint
+			klog.Infof("The list of %s expired, listing again after %q", gr, cp.LastKey)
+			continueToken = ""
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed listing %s", gr)
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			key := objectKey(obj)
+			if len(cp.LastKey) > 0 && key <= cp.LastKey {
+				continue
+			}
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			if err := rewrite(ctx, client, obj); err != nil {
+				return errors.Wrapf(err, "failed migrating %s %s", gr, key)
+			}
+
+			cp.LastKey = key
+			cp.Migrated++
+			if err := saveCheckpoint(checkpointFile, cp); err != nil {
+				return err
+			}
+			if cp.Migrated%100 == 0 {
+				klog.Infof("Migrated %d %s", cp.Migrated, gr)
+			}
+		}
+
+		continueToken = list.GetContinue()
+		if len(continueToken) == 0 {
+			return nil
+		}
+	}
+}
+
+// rewrite updates the object without any change, so that the apiserver stores it again in the
+// storage version.  On conflicts the latest object is read and updated instead.
+func rewrite(ctx context.Context, client dynamic.NamespaceableResourceInterface, obj *unstructured.Unstructured) error {
+	resourceClient := client.Namespace(obj.GetNamespace())
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_, err := resourceClient.Update(ctx, obj, metav1.UpdateOptions{})
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		latest, getErr := resourceClient.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		obj = latest
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		// deleted since listed, nothing left to migrate
+		return nil
+	}
+	return err
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if len(obj.GetNamespace()) == 0 {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// loadCheckpoint reads the checkpoint of a previous migration of --group and --resource, or
+// returns an empty checkpoint if there is none.
+func loadCheckpoint(file string) (*checkpoint, error) {
+	cp := &checkpoint{Group: storageVersionGroup, Resource: storageVersionResource}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading checkpoint %s", file)
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding checkpoint %s", file)
+	}
+	if cp.Group != storageVersionGroup || cp.Resource != storageVersionResource {
+		return nil, fmt.Errorf("checkpoint %s is of %s.%s, remove it or specify another --checkpoint-file",
+			file, cp.Resource, cp.Group)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint replaces the checkpoint file at once so that it is never partially written.
+func saveCheckpoint(file string, cp *checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrapf(err, "failed writing checkpoint %s", file)
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+// profiles are the profiles fetched by --pprof, the heap first as it's returned at once.
+var profiles = []struct {
+	name string
+	path string
+}{
+	{name: "heap", path: "/debug/pprof/heap"},
+	{name: "cpu", path: "/debug/pprof/profile"},
+}
+
+// RunPprof fetches the profiles of the pod of --pod, or of the ready endpoints of the Service of
+// --service, at the same time.
+func RunPprof(config *rest.Config, namespace string) {
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed building kube client: %v", err)
+	}
+	var pods []utils.PodPort
+	if len(pod) > 0 {
+		ns, name := splitName(pod, namespace)
+		// fail early, instead of after the retries of the requests through the port-forward
+		if _, err := kubeClient.CoreV1().Pods(ns).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+			klog.Fatalf("Failed getting pod %s/%s: %v", ns, name, err)
+		}
+		pods = []utils.PodPort{{Namespace: ns, Name: name, Port: port}}
+	} else {
+		ns, name := splitName(service, namespace)
+		pods, err = utils.ServiceEndpoints(kubeClient, ns, name, port)
+		if err != nil {
+			klog.Fatalf("Failed getting the endpoints of service %s/%s: %v", ns, name, err)
+		}
+		if len(pods) == 0 {
+			klog.Fatalf("Service %s/%s has no ready endpoint", ns, name)
+		}
+	}
+	if err := os.MkdirAll(pprofDir, 0755); err != nil {
+		klog.Fatalf("Failed creating %s: %v", pprofDir, err)
+	}
+
+	fmt.Printf("Profiling %d replicas for %d seconds\n", len(pods), pprofSeconds)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	failed := false
+	for _, p := range pods {
+		wg.Add(1)
+		go func(p utils.PodPort) {
+			defer wg.Done()
+			files, err := fetchProfiles(config, p)
+			lock.Lock()
+			defer lock.Unlock()
+			for _, f := range files {
+				fmt.Printf("Wrote %s\n", f)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed profiling %s: %v\n", p, err)
+				failed = true
+			}
+		}(p)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fetchProfiles writes the profiles of the pod through a port-forward, and returns the files
+// written.
+func fetchProfiles(config *rest.Config, p utils.PodPort) ([]string, error) {
+	forwarder := utils.NewEphemeralLocalProxy(config, p.Namespace, p.Name, p.Port)
+	stopFunc, err := forwarder.Listen()
+	if err != nil {
+		return nil, fmt.Errorf("failed running local proxy to port %v: %v", p.Port, err)
+	}
+	defer stopFunc()
+	podClient, err := kubernetes.NewForConfig(podConfig(config, forwarder.Addr()))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Format("20060102-150405")
+	var files []string
+	for _, profile := range profiles {
+		req := podClient.Discovery().RESTClient().Get().AbsPath(profile.path)
+		if profile.name == "cpu" {
+			req = req.Param("seconds", strconv.Itoa(pprofSeconds)).
+				Timeout(time.Duration(pprofSeconds)*time.Second + time.Minute)
+		}
+		data, err := req.DoRaw(context.TODO())
+		switch {
+		case apierrors.IsNotFound(err):
+			return files, fmt.Errorf("%s not found, the profiling of the apiserver is disabled by --profiling=false", profile.path)
+		case err != nil:
+			return files, fmt.Errorf("failed getting %s: %v", profile.path, err)
+		}
+		file := filepath.Join(pprofDir, fmt.Sprintf("%s-%s-%s.pprof", p.Name, profile.name, timestamp))
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
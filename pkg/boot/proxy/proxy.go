@@ -0,0 +1,331 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Expose the aggregated apiserver locally, bypassing the aggregation of the kube-apiserver.",
+	Long: `Expose the aggregated apiserver locally, bypassing the aggregation of the kube-apiserver.
+
+The pod of --pod, or the ready endpoints of the Service of --service in turn, are port-forwarded
+to from a local port, and a temporary kubeconfig for the local port is written, so that kubectl,
+curl and pprof reach a replica of the aggregated apiserver directly.  The kubeconfig is removed
+when the command is stopped.
+
+With "--tls passthrough", the TLS connections are forwarded as-is, and the kubeconfig
+authenticates with the credentials of the current kubeconfig, skipping the verification of the
+serving certificate of the aggregated apiserver.  With "--tls terminate", the local port serves
+plain HTTP and the requests are sent over TLS with the credentials of the current kubeconfig, so
+that curl and "go tool pprof" need no credentials.
+
+With "--tls terminate", any local process, including those of the other users of the machine,
+may send requests through the local port with the identity of the current kubeconfig, as with
+"kubectl proxy", so only use it on a machine you don't share.  The requests to other hosts than
+localhost, 127.0.0.1 and [::1] are rejected, so that web pages can't reach the port by DNS
+rebinding.
+
+With --pprof, the heap and CPU profiles of /debug/pprof are fetched into files instead, from
+each ready replica with --service.`,
+	Example: `# Expose a replica of the aggregated apiserver of the Service default/creatures
+apiserver-boot proxy --service default/creatures
+
+# Expose a pod on the local port 8001 over plain HTTP, then profile its heap
+apiserver-boot proxy --pod default/creatures-apiserver-6d8b9c7f5-x2x4z --tls terminate --local-port 8001
+go tool pprof http://localhost:8001/debug/pprof/heap
+
+# Fetch the heap and 60 seconds CPU profiles of all the replicas into the profiles directory
+apiserver-boot proxy --service default/creatures --pprof --pprof-seconds 60 --pprof-dir profiles`,
+	Run: RunProxy,
+}
+
+const (
+	tlsPassthrough = "passthrough"
+	tlsTerminate   = "terminate"
+)
+
+var kubeConfigFlags *genericclioptions.ConfigFlags
+var pod string
+var service string
+var port int32
+var localPort int32
+var tlsMode string
+var pprof bool
+var pprofSeconds int
+var pprofDir string
+
+func AddProxy(cmd *cobra.Command) {
+	cmd.AddCommand(proxyCmd)
+
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	kubeConfigFlags.AddFlags(proxyCmd.Flags())
+	proxyCmd.Flags().StringVar(&pod, "pod", "",
+		"The [<namespace>/]<name> of the pod of the aggregated apiserver to expose")
+	proxyCmd.Flags().StringVar(&service, "service", "",
+		"The [<namespace>/]<name> of the Service of the aggregated apiserver, to expose its ready endpoints in turn")
+	proxyCmd.Flags().Int32Var(&port, "port", 443,
+		"The serving port of the pod with --pod, or the port of the Service with --service")
+	proxyCmd.Flags().Int32Var(&localPort, "local-port", 0,
+		"The local port to listen on, chosen by the system if 0")
+	proxyCmd.Flags().StringVar(&tlsMode, "tls", tlsPassthrough,
+		"How the local port handles TLS: passthrough to forward the TLS connections, or terminate to serve plain HTTP")
+	proxyCmd.Flags().BoolVar(&pprof, "pprof", false,
+		"Fetch the heap and CPU profiles of the aggregated apiserver into files, instead of exposing it")
+	proxyCmd.Flags().IntVar(&pprofSeconds, "pprof-seconds", 30,
+		"The duration of the CPU profile in seconds with --pprof")
+	proxyCmd.Flags().StringVar(&pprofDir, "pprof-dir", ".",
+		"The directory to write the profiles to with --pprof")
+}
+
+func validateProxy() error {
+	if (len(pod) > 0) == (len(service) > 0) {
+		return fmt.Errorf("should provide exactly one of --pod or --service")
+	}
+	if tlsMode != tlsPassthrough && tlsMode != tlsTerminate {
+		return fmt.Errorf("--tls should be %s or %s", tlsPassthrough, tlsTerminate)
+	}
+	if pprofSeconds <= 0 {
+		return fmt.Errorf("--pprof-seconds should be positive")
+	}
+	return nil
+}
+
+func RunProxy(cmd *cobra.Command, args []string) {
+	if err := validateProxy(); err != nil {
+		klog.Fatalf("Failed command validation: %v", err)
+	}
+	config, err := kubeConfigFlags.ToRESTConfig()
+	if err != nil {
+		klog.Fatalf("Failed building kube client config: %v", err)
+	}
+	namespace, _, err := kubeConfigFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		klog.Fatalf("Failed getting the namespace of the kubeconfig: %v", err)
+	}
+
+	if pprof {
+		RunPprof(config, namespace)
+		return
+	}
+
+	var target, addr string
+	var kubeconfig *clientcmdapi.Config
+	switch tlsMode {
+	case tlsPassthrough:
+		var forwarder utils.LocalProxyServer
+		target, forwarder = newForwarder(config, namespace, localPort)
+		stopFunc, err := forwarder.Listen()
+		if err != nil {
+			klog.Fatalf("Failed running local proxy to %s: %v", target, err)
+		}
+		defer stopFunc()
+		addr = forwarder.Addr()
+		kubeconfig = newKubeconfig("https://"+addr, config)
+	case tlsTerminate:
+		// the forwarder listens on an ephemeral port, behind the local port serving plain HTTP
+		var forwarder utils.LocalProxyServer
+		target, forwarder = newForwarder(config, namespace, 0)
+		stopFunc, err := forwarder.Listen()
+		if err != nil {
+			klog.Fatalf("Failed running local proxy to %s: %v", target, err)
+		}
+		defer stopFunc()
+		var stopServer func()
+		addr, stopServer, err = serveTerminatingTLS(config, forwarder.Addr(), localPort)
+		if err != nil {
+			klog.Fatalf("Failed serving the local port: %v", err)
+		}
+		defer stopServer()
+		kubeconfig = newKubeconfig("http://"+addr, nil)
+	}
+
+	kubeconfigFile, err := writeTempKubeconfig(kubeconfig)
+	if err != nil {
+		klog.Fatalf("Failed writing kubeconfig: %v", err)
+	}
+	defer os.Remove(kubeconfigFile)
+
+	fmt.Printf("Forwarding %s to %s with TLS %s\n", addr, target, tlsMode)
+	fmt.Printf("Wrote the kubeconfig of the local port to %s, e.g.\n", kubeconfigFile)
+	fmt.Printf("  kubectl --kubeconfig %s api-resources\n", kubeconfigFile)
+	fmt.Printf("  kubectl --kubeconfig %s get --raw /metrics\n", kubeconfigFile)
+	if tlsMode == tlsTerminate {
+		fmt.Printf("  curl http://%s/apis\n", addr)
+		fmt.Printf("  go tool pprof http://%s/debug/pprof/heap\n", addr)
+	}
+	fmt.Println("Press Ctrl-C to stop")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+}
+
+// newForwarder returns a description of the target and the proxy port-forwarding to it.
+func newForwarder(config *rest.Config, namespace string, localPort int32) (string, utils.LocalProxyServer) {
+	if len(pod) > 0 {
+		ns, name := splitName(pod, namespace)
+		return fmt.Sprintf("port %d of pod %s/%s", port, ns, name),
+			utils.NewPodLocalProxy(config, ns, name, port, localPort)
+	}
+	ns, name := splitName(service, namespace)
+	return fmt.Sprintf("port %d of service %s/%s", port, ns, name),
+		utils.NewServiceLocalProxy(config, ns, name, port, localPort)
+}
+
+// splitName returns the namespace and the name of "[<namespace>/]<name>".
+func splitName(s, defaultNamespace string) (string, string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return defaultNamespace, s
+}
+
+// podConfig returns the config of a client of the aggregated apiserver forwarded to by the local
+// address.  The serving certificate isn't verified, as it's not valid for the local address.
+func podConfig(config *rest.Config, addr string) *rest.Config {
+	podConfig := rest.CopyConfig(config)
+	podConfig.Host = "https://" + addr
+	podConfig.Insecure = true
+	podConfig.CAData = nil
+	podConfig.CAFile = ""
+	return podConfig
+}
+
+// serveTerminatingTLS serves plain HTTP on the local port, sending the requests to the
+// forwarded address over TLS with the credentials of the config.
+func serveTerminatingTLS(config *rest.Config, forwardedAddr string, localPort int32) (string, func(), error) {
+	upstream := podConfig(config, forwardedAddr)
+	transport, err := rest.TransportFor(upstream)
+	if err != nil {
+		return "", nil, err
+	}
+	target, err := url.Parse(upstream.Host)
+	if err != nil {
+		return "", nil, err
+	}
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	reverseProxy.Transport = transport
+	// stream the watches
+	reverseProxy.FlushInterval = -1
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(localPort))))
+	if err != nil {
+		return "", nil, err
+	}
+	server := &http.Server{Handler: acceptLocalHosts(reverseProxy)}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Failed serving %s: %v", listener.Addr(), err)
+		}
+	}()
+	return listener.Addr().String(), func() { server.Close() }, nil
+}
+
+// localHosts are the hosts accepted by the local port serving plain HTTP, as the default
+// --accept-hosts of kubectl proxy.
+var localHosts = sets.NewString("localhost", "127.0.0.1", "::1")
+
+// acceptLocalHosts rejects the requests to other hosts than localHosts, so that a web page
+// resolving its name to the loopback address can't send requests with the credentials of the
+// local port.
+func acceptLocalHosts(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if !localHosts.Has(host) {
+			klog.Warningf("Rejected a request to host %q from %s", req.Host, req.RemoteAddr)
+			http.Error(w, fmt.Sprintf("host %q is not accepted, only %s", req.Host, strings.Join(localHosts.List(), ", ")),
+				http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// newKubeconfig returns a kubeconfig for the server, with the credentials of the config if any.
+func newKubeconfig(server string, config *rest.Config) *clientcmdapi.Config {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["apiserver"] = &clientcmdapi.Cluster{
+		Server:                server,
+		InsecureSkipTLSVerify: strings.HasPrefix(server, "https://"),
+	}
+	authInfo := &clientcmdapi.AuthInfo{}
+	if config != nil {
+		authInfo = &clientcmdapi.AuthInfo{
+			ClientCertificate:     config.CertFile,
+			ClientCertificateData: config.CertData,
+			ClientKey:             config.KeyFile,
+			ClientKeyData:         config.KeyData,
+			Token:                 config.BearerToken,
+			TokenFile:             config.BearerTokenFile,
+			Username:              config.Username,
+			Password:              config.Password,
+			Impersonate:           config.Impersonate.UserName,
+			ImpersonateGroups:     config.Impersonate.Groups,
+			ImpersonateUserExtra:  config.Impersonate.Extra,
+			AuthProvider:          config.AuthProvider,
+			Exec:                  config.ExecProvider,
+		}
+	}
+	kubeconfig.AuthInfos["apiserver"] = authInfo
+	kubeconfig.Contexts["apiserver"] = &clientcmdapi.Context{
+		Cluster:  "apiserver",
+		AuthInfo: "apiserver",
+	}
+	kubeconfig.CurrentContext = "apiserver"
+	return kubeconfig
+}
+
+// writeTempKubeconfig writes the kubeconfig to a temporary file only readable by the user, as
+// it may hold credentials.
+func writeTempKubeconfig(kubeconfig *clientcmdapi.Config) (string, error) {
+	f, err := ioutil.TempFile("", "apiserver-proxy-*.kubeconfig")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := clientcmd.WriteToFile(*kubeconfig, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -17,47 +17,93 @@ limitations under the License.
 package run
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	aggregatorclientset "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
 )
 
 var runInClusterCmd = &cobra.Command{
 	Use:   "in-cluster",
 	Short: "run the etcd, apiserver and the controller-manager as an aggegrated apiserver in a cluster",
-	Long:  `run the etcd, apiserver and the controller-manager as an aggegrated apiserver in a cluster`,
+	Long: `run the etcd, apiserver and the controller-manager as an aggegrated apiserver in a cluster
+
+The container image is built and pushed, the config is built into --output and applied to the
+cluster of the current context by server-side apply.  The command then waits for the Deployments
+and the StatefulSets to roll out and for the APIServices to become available, and invalidates
+the discovery cache of kubectl for the cluster.
+
+With --delete, every object labeled "api: <name>" is removed from the cluster instead.`,
 	Example: `
 # Build a new image and run the apiserver and controller-manager in the cluster
 apiserver-boot run in-cluster --name example --namespace default --image gcr.io/myrepo/myimage:mytag
 
-# Clear the discovery cache for kubectl
-rm -rf ~/.kube/cache/discovery/
-
 # Run kubectl and check for the new version
 kubectl api-versions
 
 # Create an instance and fetch it
 nano -w samples/<type>.yaml
 kubectl apply -f samples/<type>.yaml
-kubectl get <type>`,
+kubectl get <type>
+
+# Remove the apiserver and the controller-manager from the cluster
+apiserver-boot run in-cluster --name example --namespace default --delete`,
 	Run: RunInCluster,
 }
 
 var buildImage bool
 var pushImage bool
+var deleteInCluster bool
+var inClusterKubeconfig string
+var rolloutTimeout time.Duration
 
 func AddInCluster(cmd *cobra.Command) {
 	cmd.AddCommand(runInClusterCmd)
 
 	build.AddBuildResourceConfigFlags(runInClusterCmd)
 	runInClusterCmd.Flags().BoolVar(&buildImage, "build-image", true, "if true, build the container image.")
-	runInClusterCmd.Flags().BoolVar(&buildImage, "push-image", true, "if true, push it to the image repo.")
+	runInClusterCmd.Flags().BoolVar(&pushImage, "push-image", true, "if true, push it to the image repo.")
+	runInClusterCmd.Flags().BoolVar(&deleteInCluster, "delete", false,
+		`if true, delete the objects labeled "api: <name>" from the cluster instead of running the apiserver`)
+	runInClusterCmd.Flags().StringVar(&inClusterKubeconfig, "kubeconfig", "",
+		"path to the kubeconfig of the cluster, defaults to $KUBECONFIG or ~/.kube/config")
+	runInClusterCmd.Flags().DurationVar(&rolloutTimeout, "timeout", 5*time.Minute,
+		"how long to wait for the rollout and the APIServices to become available")
 }
 
 func RunInCluster(cmd *cobra.Command, args []string) {
+	if deleteInCluster {
+		if len(build.Name) == 0 || len(build.Namespace) == 0 {
+			klog.Fatalf("must specify --name and --namespace")
+		}
+		restConfig := inClusterConfig()
+		deleteInClusterObjects(restConfig)
+		invalidateDiscoveryCache(restConfig)
+		return
+	}
+
 	if buildImage {
 		// Build the container first
 		build.RunBuildContainer(cmd, args)
@@ -72,5 +118,215 @@ func RunInCluster(cmd *cobra.Command, args []string) {
 	build.RunBuildResourceConfig(cmd, args)
 
 	// Apply the new config
-	util.DoCmd("kubectl", "apply", "-f", filepath.Join(build.ResourceConfigDir))
+	restConfig := inClusterConfig()
+	objects, err := readSeedObjects(build.ResourceConfigDir)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	var toApply []seedObject
+	for _, o := range objects {
+		// registers the apiserver running on the host, see build config --local-cluster
+		if filepath.Base(o.file) != build.LocalConfigFile {
+			toApply = append(toApply, o)
+		}
+	}
+	ctx := util.CancelWhenSignaled(context.Background())
+	if _, _, failed := applyObjects(ctx, restConfig, toApply, true); failed > 0 {
+		klog.Fatalf("Failed applying %d objects of %s", failed, build.ResourceConfigDir)
+	}
+
+	waitForInCluster(ctx, restConfig)
+	invalidateDiscoveryCache(restConfig)
+	klog.Infof("The aggregated apiserver %s is available, try \"kubectl api-resources\"", build.Name)
+}
+
+func inClusterConfig() *rest.Config {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = inClusterKubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		klog.Fatalf("Failed building kube client config: %v", err)
+	}
+	return restConfig
+}
+
+// apiSelector selects the objects of the config of build config.
+func apiSelector() string {
+	return "api=" + build.Name
+}
+
+// waitForInCluster waits for the Deployments and the StatefulSets of the config to roll out, and
+// then for the APIServices to become available.
+func waitForInCluster(ctx context.Context, restConfig *rest.Config) {
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("Failed building kube client: %v", err)
+	}
+	aggregatorClient, err := aggregatorclientset.NewForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("Failed building kube-aggregator client: %v", err)
+	}
+	listOptions := metav1.ListOptions{LabelSelector: apiSelector()}
+	ctx, cancel := context.WithTimeout(ctx, rolloutTimeout)
+	defer cancel()
+
+	waitWithSpinner(ctx, "Waiting for the rollout", func() (string, error) {
+		var pending []string
+		deployments, err := kubeClient.AppsV1().Deployments(build.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for _, d := range deployments.Items {
+			if status, done := deploymentRolloutStatus(&d); !done {
+				pending = append(pending, fmt.Sprintf("deployment/%s %s", d.Name, status))
+			}
+		}
+		statefulSets, err := kubeClient.AppsV1().StatefulSets(build.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for _, s := range statefulSets.Items {
+			if status, done := statefulSetRolloutStatus(&s); !done {
+				pending = append(pending, fmt.Sprintf("statefulset/%s %s", s.Name, status))
+			}
+		}
+		return strings.Join(pending, ", "), nil
+	})
+
+	waitWithSpinner(ctx, "Waiting for the APIServices", func() (string, error) {
+		var pending []string
+		apiServices, err := aggregatorClient.ApiregistrationV1().APIServices().List(ctx, listOptions)
+		if err != nil {
+			return "", err
+		}
+		for _, a := range apiServices.Items {
+			if reason, available := apiServiceAvailable(&a); !available {
+				pending = append(pending, fmt.Sprintf("%s %s", a.Name, reason))
+			}
+		}
+		return strings.Join(pending, ", "), nil
+	})
+}
+
+// waitWithSpinner polls the pending objects until there are none, showing them by a spinner.
+func waitWithSpinner(ctx context.Context, suffix string, pendingFunc func() (string, error)) {
+	var lock sync.Mutex
+	var pending string
+	s := utils.NewSpinnerWithStatus(suffix, 100*time.Millisecond, fmt.Sprintf("%s: done\n", suffix), func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		return pending
+	})
+	s.Start()
+	err := wait.PollImmediateUntil(2*time.Second, func() (bool, error) {
+		p, err := pendingFunc()
+		if err != nil {
+			klog.V(2).Infof("%s: %v", suffix, err)
+			return false, nil
+		}
+		lock.Lock()
+		pending = p
+		lock.Unlock()
+		return len(p) == 0, nil
+	}, ctx.Done())
+	if err != nil {
+		s.FinalMSG = ""
+		s.Stop()
+		klog.Fatalf("%s timed out after %v, pending: %s", suffix, rolloutTimeout, pending)
+	}
+	s.Stop()
+}
+
+func deploymentRolloutStatus(d *appsv1.Deployment) (string, bool) {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	status := fmt.Sprintf("%d/%d available", d.Status.AvailableReplicas, replicas)
+	return status, d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.AvailableReplicas == replicas &&
+		d.Status.Replicas == replicas
+}
+
+func statefulSetRolloutStatus(s *appsv1.StatefulSet) (string, bool) {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	status := fmt.Sprintf("%d/%d ready", s.Status.ReadyReplicas, replicas)
+	return status, s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.ReadyReplicas == replicas &&
+		s.Status.UpdatedReplicas == replicas
+}
+
+func apiServiceAvailable(a *apiregistrationv1.APIService) (string, bool) {
+	for _, cond := range a.Status.Conditions {
+		if cond.Type == apiregistrationv1.Available {
+			return cond.Reason, cond.Status == apiregistrationv1.ConditionTrue
+		}
+	}
+	return "Unknown", false
+}
+
+// deletedResources are the resources of the config of build config, the APIServices first so
+// that the cluster stops serving the api groups before the apiserver is removed.
+var deletedResources = []schema.GroupVersionResource{
+	apiregistrationv1.SchemeGroupVersion.WithResource("apiservices"),
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Version: "v1", Resource: "services"},
+	{Version: "v1", Resource: "secrets"},
+	{Version: "v1", Resource: "persistentvolumeclaims"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+}
+
+// deleteInClusterObjects deletes the objects labeled with the api name, in the namespace or
+// cluster-scoped.
+func deleteInClusterObjects(restConfig *rest.Config) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("Failed building dynamic client: %v", err)
+	}
+	ctx := context.Background()
+	propagation := metav1.DeletePropagationBackground
+	deleted := 0
+	for _, gvr := range deletedResources {
+		var resourceClient dynamic.ResourceInterface = client.Resource(gvr)
+		switch gvr.Resource {
+		case "apiservices", "clusterroles", "clusterrolebindings":
+		default:
+			resourceClient = client.Resource(gvr).Namespace(build.Namespace)
+		}
+		list, err := resourceClient.List(ctx, metav1.ListOptions{LabelSelector: apiSelector()})
+		if err != nil {
+			klog.Fatalf("Failed listing %s: %v", gvr.Resource, err)
+		}
+		for _, item := range list.Items {
+			err := resourceClient.Delete(ctx, item.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil {
+				klog.Fatalf("Failed deleting %s/%s: %v", gvr.Resource, item.GetName(), err)
+			}
+			deleted++
+			klog.Infof("Deleted %s/%s", gvr.Resource, item.GetName())
+		}
+	}
+	klog.Infof("Deleted %d objects labeled %s", deleted, apiSelector())
+}
+
+var discoveryCacheIllegalCharacters = regexp.MustCompile(`[^(\w/.)]`)
+
+// invalidateDiscoveryCache removes the discovery cache of kubectl for the host of the cluster,
+// so that kubectl discovers the added or removed api groups at once.
+func invalidateDiscoveryCache(restConfig *rest.Config) {
+	// the same directory as kubectl, see k8s.io/cli-runtime/pkg/genericclioptions
+	host := strings.Replace(strings.Replace(restConfig.Host, "https://", "", 1), "http://", "", 1)
+	dir := filepath.Join(homedir.HomeDir(), ".kube", "cache", "discovery",
+		discoveryCacheIllegalCharacters.ReplaceAllString(host, "_"))
+	if err := os.RemoveAll(dir); err != nil {
+		klog.Warningf("Failed removing the discovery cache %s: %v", dir, err)
+		return
+	}
+	klog.Infof("Removed the discovery cache %s", dir)
 }
@@ -0,0 +1,269 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+// localClusterDir holds the certificates of the local kube-apiserver and the aggregated
+// apiserver registered into it.
+var localClusterDir = filepath.Join("apiserver.local.config", "kube-apiserver")
+
+const (
+	// the Service of the APIServices, pointing at the aggregated apiserver on the host
+	localServiceName      = "apiserver"
+	localServiceNamespace = "default"
+
+	frontProxyClientName = "front-proxy-client"
+)
+
+var kubeApiserver string
+var kubeApiserverPort int32
+var kubeApiserverConfig string
+var printkubeapiserver bool
+
+// kubeApiserverCerts are the certificates of the local kube-apiserver, if run local starts it.
+var kubeApiserverCerts *localClusterCerts
+
+// findKubeApiserver returns the kube-apiserver of --kube-apiserver, of the envtest binaries in
+// $KUBEBUILDER_ASSETS, or in the PATH.
+func findKubeApiserver() string {
+	if len(kubeApiserver) > 0 {
+		return kubeApiserver
+	}
+	if assets := os.Getenv("KUBEBUILDER_ASSETS"); len(assets) > 0 {
+		if _, err := os.Stat(filepath.Join(assets, "kube-apiserver")); err == nil {
+			return filepath.Join(assets, "kube-apiserver")
+		}
+	}
+	if p, err := exec.LookPath("kube-apiserver"); err == nil {
+		return p
+	}
+	klog.Fatalf(`Could not find kube-apiserver, install the envtest binaries with:
+  go install sigs.k8s.io/controller-runtime/tools/setup-envtest@latest
+  export KUBEBUILDER_ASSETS=$(setup-envtest use -p path)`)
+	return ""
+}
+
+// localClusterCerts are the files of the certificates under localClusterDir.
+type localClusterCerts struct {
+	ca, frontProxyCA string
+	// serving certificates of the kube-apiserver and the aggregated apiserver
+	kubeApiserver, apiserver string
+	// client certificates of the admin and of the kube-apiserver proxying to the aggregated apiserver
+	admin, frontProxyClient string
+	serviceAccountKey       string
+}
+
+// writeLocalClusterCerts creates the certificates which don't exist yet under localClusterDir.
+func writeLocalClusterCerts() *localClusterCerts {
+	if err := os.MkdirAll(localClusterDir, 0700); err != nil {
+		klog.Fatal(err)
+	}
+	ca, caKey := ensureCA("ca", "apiserver-boot-ca")
+	frontProxyCA, frontProxyCAKey := ensureCA("front-proxy-ca", "apiserver-boot-front-proxy-ca")
+
+	kubeApiserverNames := util.AltNames{
+		DNSNames: []string{"localhost", "kubernetes", "kubernetes.default.svc"},
+		// the first address of --service-cluster-ip-range, of the kubernetes Service
+		IPs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.0.0.1")},
+	}
+	apiserverNames := util.AltNames{
+		// the kube-apiserver verifies the aggregated apiserver by the name of its Service
+		DNSNames: []string{"localhost", fmt.Sprintf("%s.%s.svc", localServiceName, localServiceNamespace)},
+		IPs:      []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	serving := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	client := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	ensureCert("kube-apiserver", ca, caKey, util.Config{CommonName: "kube-apiserver", AltNames: kubeApiserverNames, Usages: serving})
+	ensureCert("apiserver", ca, caKey, util.Config{CommonName: "apiserver", AltNames: apiserverNames, Usages: serving})
+	ensureCert("admin", ca, caKey, util.Config{CommonName: "admin", Organization: []string{"system:masters"}, Usages: client})
+	ensureCert(frontProxyClientName, frontProxyCA, frontProxyCAKey, util.Config{CommonName: frontProxyClientName, Usages: client})
+
+	serviceAccountKey := filepath.Join(localClusterDir, "sa.key")
+	if _, err := os.Stat(serviceAccountKey); os.IsNotExist(err) {
+		key, err := util.NewPrivateKey()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := ioutil.WriteFile(serviceAccountKey, util.EncodePrivateKeyPEM(key), 0600); err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	path := func(name string) string {
+		return filepath.Join(localClusterDir, name)
+	}
+	return &localClusterCerts{
+		ca:                path("ca"),
+		frontProxyCA:      path("front-proxy-ca"),
+		kubeApiserver:     path("kube-apiserver"),
+		apiserver:         path("apiserver"),
+		admin:             path("admin"),
+		frontProxyClient:  path(frontProxyClientName),
+		serviceAccountKey: serviceAccountKey,
+	}
+}
+
+func ensureCA(name, commonName string) (*x509.Certificate, *rsa.PrivateKey) {
+	if cert, key, err := util.TryLoadCertAndKeyFromDisk(localClusterDir, name); err == nil {
+		return cert, key
+	}
+	key, err := util.NewPrivateKey()
+	if err != nil {
+		klog.Fatal(err)
+	}
+	cert, err := certutil.NewSelfSignedCACert(certutil.Config{CommonName: commonName}, key)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	writeCertAndKey(name, cert, key)
+	return cert, key
+}
+
+func ensureCert(name string, ca *x509.Certificate, caKey *rsa.PrivateKey, config util.Config) {
+	if _, _, err := util.TryLoadCertAndKeyFromDisk(localClusterDir, name); err == nil {
+		return
+	}
+	cert, key, err := util.NewCertAndKey(ca, caKey, config)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	writeCertAndKey(name, cert, key)
+}
+
+func writeCertAndKey(name string, cert *x509.Certificate, key *rsa.PrivateKey) {
+	if err := ioutil.WriteFile(filepath.Join(localClusterDir, name+".crt"), util.EncodeCertPEM(cert), 0644); err != nil {
+		klog.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(localClusterDir, name+".key"), util.EncodePrivateKeyPEM(key), 0600); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+// RunKubeApiserver starts a kube-apiserver on the storage of the aggregated apiserver, which
+// authenticates the admin by its client certificate and proxies the requests of the APIServices
+// to the aggregated apiserver with the front-proxy client certificate.
+func RunKubeApiserver(ctx context.Context, cancel context.CancelFunc, certs *localClusterCerts) *exec.Cmd {
+	kubeApiserverCmd := exec.Command(findKubeApiserver(),
+		fmt.Sprintf("--etcd-servers=%s", etcd),
+		fmt.Sprintf("--secure-port=%v", kubeApiserverPort),
+		"--bind-address=127.0.0.1",
+		"--advertise-address=127.0.0.1",
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--authorization-mode=Node,RBAC",
+		"--allow-privileged=true",
+		fmt.Sprintf("--cert-dir=%s", localClusterDir),
+		fmt.Sprintf("--tls-cert-file=%s.crt", certs.kubeApiserver),
+		fmt.Sprintf("--tls-private-key-file=%s.key", certs.kubeApiserver),
+		fmt.Sprintf("--client-ca-file=%s.crt", certs.ca),
+		fmt.Sprintf("--service-account-key-file=%s", certs.serviceAccountKey),
+		fmt.Sprintf("--service-account-signing-key-file=%s", certs.serviceAccountKey),
+		"--service-account-issuer=https://kubernetes.default.svc",
+		fmt.Sprintf("--requestheader-client-ca-file=%s.crt", certs.frontProxyCA),
+		fmt.Sprintf("--requestheader-allowed-names=%s", frontProxyClientName),
+		"--requestheader-username-headers=X-Remote-User",
+		"--requestheader-group-headers=X-Remote-Group",
+		"--requestheader-extra-headers-prefix=X-Remote-Extra-",
+		fmt.Sprintf("--proxy-client-cert-file=%s.crt", certs.frontProxyClient),
+		fmt.Sprintf("--proxy-client-key-file=%s.key", certs.frontProxyClient),
+	)
+	if printkubeapiserver {
+		kubeApiserverCmd.Stderr = os.Stderr
+		kubeApiserverCmd.Stdout = os.Stdout
+	}
+
+	go runCommon(kubeApiserverCmd, ctx, cancel)
+
+	return kubeApiserverCmd
+}
+
+// WriteKubeApiserverConfig writes the kubeconfig of the admin of the local kube-apiserver.
+func WriteKubeApiserverConfig(certs *localClusterCerts) {
+	klog.Infof("Writing kubeconfig of the kube-apiserver to %s", kubeApiserverConfig)
+	abs := func(p string) string {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		return a
+	}
+	config := clientcmdapi.NewConfig()
+	config.Clusters["kube-apiserver"] = &clientcmdapi.Cluster{
+		Server:               fmt.Sprintf("https://127.0.0.1:%v", kubeApiserverPort),
+		CertificateAuthority: abs(certs.ca + ".crt"),
+	}
+	config.AuthInfos["admin"] = &clientcmdapi.AuthInfo{
+		ClientCertificate: abs(certs.admin + ".crt"),
+		ClientKey:         abs(certs.admin + ".key"),
+	}
+	config.Contexts["kube-apiserver"] = &clientcmdapi.Context{
+		Cluster:  "kube-apiserver",
+		AuthInfo: "admin",
+	}
+	config.CurrentContext = "kube-apiserver"
+	if err := clientcmd.WriteToFile(*config, kubeApiserverConfig); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+// registerAPIServices registers the aggregated apiserver running on the host into the local
+// kube-apiserver, by the APIServices of the project and an ExternalName Service.
+func registerAPIServices(ctx context.Context, certs *localClusterCerts) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeApiserverConfig)
+	if err != nil {
+		klog.Fatalf("Failed building client config from %s: %v", kubeApiserverConfig, err)
+	}
+	if err := waitForReady(ctx, restConfig); err != nil {
+		klog.Fatalf("The kube-apiserver isn't ready: %v", err)
+	}
+
+	caCert, err := ioutil.ReadFile(certs.ca + ".crt")
+	if err != nil {
+		klog.Fatal(err)
+	}
+	file := filepath.Join(localClusterDir, "apiservice.yaml")
+	build.WriteLocalConfig(file, localServiceName, localServiceNamespace, "127.0.0.1", securePort, caCert)
+	objects, err := readSeedFile(file)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	var toApply []seedObject
+	for _, obj := range objects {
+		toApply = append(toApply, seedObject{file: file, obj: obj})
+	}
+	if _, _, failed := applyObjects(ctx, restConfig, toApply, false); failed > 0 {
+		klog.Fatalf("Failed registering the aggregated apiserver into the kube-apiserver")
+	}
+}
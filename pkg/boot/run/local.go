@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -38,7 +39,29 @@ var localCmd = &cobra.Command{
 	Short: "run the etcd, apiserver and controller",
 	Long: `run the etcd, apiserver and controller, Note that the aggregated apiserver in the local mode 
 will not be attempting to delegate any requests to an acutal kube-apiserver, hence neither authentication 
-nor authorization will be performed.`,
+nor authorization will be performed, unless the "kube-apiserver" component runs too.
+
+The "kube-apiserver" component starts a kube-apiserver of the envtest binaries, found by
+--kube-apiserver, $KUBEBUILDER_ASSETS or the PATH, on the same storage as the aggregated apiserver.
+The aggregated apiserver then delegates the authentication and the authorization to it, and is
+registered into it by the APIServices of the project and an ExternalName Service, so the requests
+go through the aggregation, RBAC and admission of a real cluster.  The kubeconfig of its admin is
+written to --kube-apiserver-config, and is used by the controller-manager.
+
+The "etcd" component starts the storage of the project, as set by "init repo --storage", or
+the storage of --storage:
+- etcd: an etcd process
+- mysql: a MySQL container, served to the apiserver as etcd by kine
+- sqlite: the SQLite database of --sqlite-file, served to the apiserver as etcd by kine, which
+  needs no database server but an apiserver-boot built with cgo (CGO_ENABLED=1)
+- filepath: nothing, the apiserver stores the resources as json files under data/
+
+Kine runs inside apiserver-boot, so an etcd backed apiserver and its controllers run unchanged
+on mysql and sqlite.
+
+With --seed, the objects of the yaml files of the directory are applied once the apiserver is
+ready, the namespaces first, then the cluster-scoped objects and the namespaced objects last.
+Together with --reset, every run starts from the same dataset.`,
 	Example: `
 # Regenerate code and build binaries then run them. 
 
@@ -50,11 +73,25 @@ kubectl --kubeconfig kubeconfig api-versions
 # Run locally without rebuilding
 apiserver-boot run local --build=false
 
+# Run locally without etcd, storing the resources in db/state.db
+apiserver-boot run local --storage sqlite
+
+# Start from empty storage, and apply the objects of sample/ or config/samples once the apiserver is ready
+apiserver-boot run local --reset --seed
+
+# Apply the objects of another directory
+apiserver-boot run local --seed=hack/dataset
+
+# Run a kube-apiserver too, and access the resources through the aggregation
+apiserver-boot run local --run etcd,kube-apiserver,apiserver,controller
+kubectl --kubeconfig kube-apiserver.kubeconfig api-resources
+
 # Create an instance and fetch it
 nano -w samples/<type>.yaml
 kubectl --kubeconfig kubeconfig apply -f samples/<type>.yaml
 kubectl --kubeconfig kubeconfig get <type>`,
-	Run: RunLocal,
+	Args: cobra.NoArgs,
+	Run:  RunLocal,
 }
 
 var etcd string
@@ -70,6 +107,10 @@ var toRun []string
 var disableMTLS bool
 var certDir string
 var securePort int32
+var storageBackend string
+var sqliteFile string
+var seedDir string
+var reset bool
 
 func AddLocal(cmd *cobra.Command) {
 	localCmd.Flags().StringSliceVar(&toRun, "run", []string{"etcd", "apiserver", "controller"}, "path to apiserver binary to run")
@@ -80,12 +121,26 @@ func AddLocal(cmd *cobra.Command) {
 	localCmd.Flags().StringVar(&server, "apiserver", "", "path to apiserver binary to run")
 	localCmd.Flags().StringVar(&controllermanager, "controller-manager", "", "path to controller-manager binary to run")
 	localCmd.Flags().StringVar(&etcd, "etcd", "", "if non-empty, use this etcd instead of starting a new one")
+	localCmd.Flags().StringVar(&kubeApiserver, "kube-apiserver", "",
+		"path to the kube-apiserver binary of the kube-apiserver component, defaults to $KUBEBUILDER_ASSETS/kube-apiserver or the PATH")
+	localCmd.Flags().Int32Var(&kubeApiserverPort, "kube-apiserver-port", 6443, "secure port of the kube-apiserver component")
+	localCmd.Flags().StringVar(&kubeApiserverConfig, "kube-apiserver-config", "kube-apiserver.kubeconfig",
+		"path to the kubeconfig to write for using kubectl with the kube-apiserver component")
+	localCmd.Flags().StringVar(&storageBackend, "storage", "",
+		"storage to run the apiserver with, one of "+strings.Join(util.StorageBackends, "|")+", defaults to the storage of the project")
+	localCmd.Flags().StringVar(&sqliteFile, "sqlite-file", filepath.Join("db", "state.db"), "SQLite database of the sqlite storage")
+
+	localCmd.Flags().StringVar(&seedDir, "seed", "",
+		"directory of the yaml files to apply once the apiserver is ready as --seed=<dir>, --seed alone applies sample/ or config/samples")
+	localCmd.Flags().Lookup("seed").NoOptDefVal = seedDirAuto
+	localCmd.Flags().BoolVar(&reset, "reset", false, "if true, remove the data of the storage before starting it, only with the etcd component, or the apiserver component for the filepath storage")
 
 	localCmd.Flags().StringVar(&config, "config", "kubeconfig", "path to the kubeconfig to write for using kubectl")
 
 	localCmd.Flags().BoolVar(&printapiserver, "print-apiserver", true, "if true, pipe the apiserver stdout and stderr")
 	localCmd.Flags().BoolVar(&printcontrollermanager, "print-controller-manager", true, "if true, pipe the controller-manager stdout and stderr")
 	localCmd.Flags().BoolVar(&printetcd, "printetcd", false, "if true, pipe the etcd stdout and stderr")
+	localCmd.Flags().BoolVar(&printkubeapiserver, "print-kube-apiserver", false, "if true, pipe the kube-apiserver stdout and stderr")
 	localCmd.Flags().BoolVar(&buildBin, "build", true, "if true, build the binaries before running")
 
 	localCmd.Flags().Int32Var(&securePort, "secure-port", 9443, "Secure port from apiserver to serve requests")
@@ -95,6 +150,14 @@ func AddLocal(cmd *cobra.Command) {
 }
 
 func RunLocal(cmd *cobra.Command, args []string) {
+	validateLocalStorage()
+	if reset {
+		validateReset()
+	}
+	if len(seedDir) > 0 {
+		seedDir = getSeedDir()
+	}
+
 	if buildBin {
 		build.BuildTargets = toRun
 		build.RunBuildExecutables(cmd, args)
@@ -104,6 +167,7 @@ func RunLocal(cmd *cobra.Command, args []string) {
 
 	// parent context to indicate whether cmds quit
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	ctx = util.CancelWhenSignaled(ctx)
 
 	r := map[string]interface{}{}
@@ -118,11 +182,41 @@ func RunLocal(cmd *cobra.Command, args []string) {
 			WaitUntilCommandCompleted(cmd)
 		}
 	}()
-	// Start etcd
+	if reset {
+		resetLocalStorage()
+	}
+
+	// Start etcd, or the storage replacing it
 	if _, f := r["etcd"]; f {
-		etcd = "http://localhost:2379"
-		startedCommands["etcd"] = RunEtcd(ctx, cancel)
-		time.Sleep(time.Second * 2)
+		var err error
+		switch storageBackend {
+		case util.StorageEtcd:
+			etcd = "http://localhost:2379"
+			startedCommands["etcd"] = RunEtcd(ctx, cancel)
+			time.Sleep(time.Second * 2)
+		case util.StorageMySQL:
+			startedCommands["mysql"] = RunMySQL(ctx, cancel)
+			defer exec.Command("docker", "rm", "--force", localMySQLContainer).Run()
+			etcd, err = RunKine(ctx, fmt.Sprintf("mysql://root:%s@tcp(127.0.0.1:3306)/%s",
+				localMySQLPassword, localMySQLDatabase), kineListenAddress)
+		case util.StorageSQLite:
+			etcd, err = RunKine(ctx, sqliteEndpoint(sqliteFile), kineListenAddress)
+		}
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	// Start the kube-apiserver, and register the apiserver into it
+	if _, f := r["kube-apiserver"]; f {
+		if storageBackend == util.StorageFilepath || len(etcd) == 0 {
+			klog.Fatalf("The kube-apiserver needs the etcd, run the etcd component or specify --etcd")
+		}
+		kubeApiserverCerts = writeLocalClusterCerts()
+		startedCommands["kube-apiserver"] = RunKubeApiserver(ctx, cancel, kubeApiserverCerts)
+		WriteKubeApiserverConfig(kubeApiserverCerts)
+		registerAPIServices(ctx, kubeApiserverCerts)
+		klog.Info("Kube-apiserver successfully started")
 	}
 
 	// Start apiserver
@@ -138,6 +232,14 @@ func RunLocal(cmd *cobra.Command, args []string) {
 		klog.Info("Controller manager successfully started")
 	}
 
+	kubeconfig := config
+	if kubeApiserverCerts != nil {
+		kubeconfig = kubeApiserverConfig
+	}
+	if _, f := r["apiserver"]; f && len(seedDir) > 0 {
+		Seed(ctx, seedDir, kubeconfig)
+	}
+
 	klog.Infof(`
 ==================================================
 | Now you're all set!
@@ -146,7 +248,7 @@ func RunLocal(cmd *cobra.Command, args []string) {
 | >> "kubectl --kubeconfig %s api-versions" or "KUBECONFIG=%s kubectl api-resources"
 |
 ==================================================`,
-		config, config)
+		kubeconfig, kubeconfig)
 	<-ctx.Done() // wait forever
 }
 
@@ -162,31 +264,100 @@ func RunEtcd(ctx context.Context, cancel context.CancelFunc) *exec.Cmd {
 	return etcdCmd
 }
 
+// validateLocalStorage defaults --storage to the storage of the project, and checks the apiserver
+// can run with it.
+func validateLocalStorage() {
+	projectStorage := util.GetStorage()
+	if len(storageBackend) == 0 {
+		storageBackend = projectStorage
+	}
+	util.ValidateStorage(storageBackend)
+	if storageBackend != projectStorage &&
+		(storageBackend == util.StorageFilepath || projectStorage == util.StorageFilepath) {
+		// the filepath storage is compiled into the apiserver, unlike the others served as etcd
+		klog.Fatalf("the apiserver of the %s storage can't run with --storage %s", projectStorage, storageBackend)
+	}
+}
+
+const (
+	localMySQLContainer = "apiserver-boot-mysql"
+	localMySQLPassword  = "password"
+	localMySQLDatabase  = "apiserver"
+)
+
+// RunMySQL starts a MySQL container for the kine storage, and waits for it to accept connections.
+func RunMySQL(ctx context.Context, cancel context.CancelFunc) *exec.Cmd {
+	mysqlCmd := exec.Command("docker", "run", "--rm",
+		"--name", localMySQLContainer,
+		"--publish", "3306:3306",
+		"--env", "MYSQL_ROOT_PASSWORD="+localMySQLPassword,
+		"--env", "MYSQL_DATABASE="+localMySQLDatabase,
+		"mysql:8.0")
+	if printetcd {
+		mysqlCmd.Stderr = os.Stderr
+		mysqlCmd.Stdout = os.Stdout
+	}
+
+	go runCommon(mysqlCmd, ctx, cancel)
+
+	// the port is only opened after the database is initialized
+	err := wait.PollImmediate(time.Second, 2*time.Minute, func() (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		conn, err := net.Dial("tcp", "127.0.0.1:3306")
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	})
+	if err != nil {
+		klog.Fatalf("MySQL didn't start: %v", err)
+	}
+	return mysqlCmd
+}
+
 func RunApiserver(ctx context.Context, cancel context.CancelFunc) *exec.Cmd {
 	if len(server) == 0 {
 		server = "bin/apiserver"
 	}
 
-	// checking if apiserver supports local running
-	apiserverTestLocalCmd := exec.Command(server, "-h")
-	buf := &bytes.Buffer{}
-	apiserverTestLocalCmd.Stdout = buf
-	runCommon(apiserverTestLocalCmd, ctx, nil)
-	if !strings.Contains(string(buf.Bytes()), "--standalone-debug-mode") {
-		klog.Fatalf(`
+	// checking if apiserver supports local running, unless delegating to the kube-apiserver
+	if kubeApiserverCerts == nil {
+		apiserverTestLocalCmd := exec.Command(server, "-h")
+		buf := &bytes.Buffer{}
+		apiserverTestLocalCmd.Stdout = buf
+		runCommon(apiserverTestLocalCmd, ctx, nil)
+		if !strings.Contains(string(buf.Bytes()), "--standalone-debug-mode") {
+			klog.Fatalf(`
 The apiserver binary doesn't seem to support --standalone-debug-mode, 
 did you have WithLocalDebugExtension() in your apiserver? (if you're using kuberentes-sigs/apiserver-runtime')`)
+		}
+		klog.Info("The apiserver binary supports local-running, proceeding..")
 	}
-	klog.Info("The apiserver binary supports local-running, proceeding..")
 
 	// starting apiserver process
 	flags := []string{
-		fmt.Sprintf("--etcd-servers=%s", etcd),
 		fmt.Sprintf("--secure-port=%v", securePort),
 		fmt.Sprintf("--feature-gates=APIPriorityAndFairness=false"), // TODO: remove this line after https://github.com/kubernetes/kubernetes/pull/97957 merged
 	}
+	if storageBackend != util.StorageFilepath {
+		// the apiserver of the filepath storage runs without the etcd options
+		flags = append(flags, fmt.Sprintf("--etcd-servers=%s", etcd))
+	}
 
-	if disableMTLS {
+	if kubeApiserverCerts != nil {
+		// delegates to the kube-apiserver, which proxies to the Service name of the certificate
+		flags = append(flags,
+			"--bind-address=127.0.0.1",
+			fmt.Sprintf("--tls-cert-file=%s.crt", kubeApiserverCerts.apiserver),
+			fmt.Sprintf("--tls-private-key-file=%s.key", kubeApiserverCerts.apiserver),
+			fmt.Sprintf("--kubeconfig=%s", kubeApiserverConfig),
+			fmt.Sprintf("--authentication-kubeconfig=%s", kubeApiserverConfig),
+			fmt.Sprintf("--authorization-kubeconfig=%s", kubeApiserverConfig),
+		)
+	} else if disableMTLS {
 		flags = append(flags, "--standalone-debug-mode")
 		flags = append(flags, "--bind-address=127.0.0.1")
 	} else {
@@ -214,8 +385,12 @@ func RunControllerManager(ctx context.Context, cancel context.CancelFunc) *exec.
 		controllermanager = "bin/controller-manager"
 	}
 
+	kubeconfig := config
+	if kubeApiserverCerts != nil {
+		kubeconfig = kubeApiserverConfig
+	}
 	controllerManagerCmd := exec.Command(controllermanager,
-		fmt.Sprintf("--kubeconfig=%s", config),
+		fmt.Sprintf("--kubeconfig=%s", kubeconfig),
 	)
 	if printcontrollermanager {
 		controllerManagerCmd.Stderr = os.Stderr
@@ -0,0 +1,322 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+// seedDirAuto is the value of a --seed without directory, which seeds the first of
+// defaultSeedDirs found.
+const seedDirAuto = "auto"
+
+var defaultSeedDirs = []string{"sample", filepath.Join("config", "samples")}
+
+// applyFieldManager is the field manager of the objects applied by run local.
+const applyFieldManager = "apiserver-boot"
+
+// validateReset checks that --reset only removes the data of a storage started by this run, and
+// not e.g. of the etcd of --etcd used by the apiserver alone.
+func validateReset() {
+	component := "etcd"
+	if storageBackend == util.StorageFilepath {
+		// the files are written by the apiserver, without any storage component
+		component = "apiserver"
+	}
+	for _, c := range toRun {
+		if c == component {
+			return
+		}
+	}
+	klog.Fatalf("--reset only removes the data of the %s storage run by the %s component, which isn't in --run", storageBackend, component)
+}
+
+// resetLocalStorage removes the data of the storage started by run local.
+func resetLocalStorage() {
+	var paths []string
+	switch storageBackend {
+	case util.StorageEtcd:
+		// the default data directory of etcd, which run local starts without flags
+		paths = []string{"default.etcd"}
+	case util.StorageSQLite:
+		paths = []string{sqliteFile, sqliteFile + "-shm", sqliteFile + "-wal"}
+	case util.StorageFilepath:
+		paths = []string{"data"}
+	case util.StorageMySQL:
+		// the container is removed when run local stops, so the database always starts empty
+		return
+	}
+	for _, p := range paths {
+		klog.Infof("Removing %s", p)
+		if err := os.RemoveAll(p); err != nil {
+			klog.Fatal(err)
+		}
+	}
+}
+
+// getSeedDir returns the directory of --seed, or the first of the default directories found.
+func getSeedDir() string {
+	if seedDir != seedDirAuto {
+		return seedDir
+	}
+	for _, dir := range defaultSeedDirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	klog.Fatalf("None of %s found, specify the directory to seed with --seed=<dir>", strings.Join(defaultSeedDirs, ", "))
+	return ""
+}
+
+// seedObject is an object read from a file to apply.
+type seedObject struct {
+	file string
+	obj  *unstructured.Unstructured
+}
+
+// Seed applies the objects of the files in the directory to the apiserver of the kubeconfig,
+// once the apiserver is ready.  The failures are reported per object without stopping.
+func Seed(ctx context.Context, dir, kubeconfig string) {
+	objects, err := readSeedObjects(dir)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		klog.Fatalf("Failed building client config from %s: %v", kubeconfig, err)
+	}
+	if err := waitForReady(ctx, restConfig); err != nil {
+		klog.Errorf("Not seeding %s, the apiserver isn't ready: %v", dir, err)
+		return
+	}
+	applied, skipped, failed := applyObjects(ctx, restConfig, objects, false)
+	klog.Infof("Seeded %d objects from %s, %d skipped, %d failed", applied, dir, skipped, failed)
+}
+
+// applyObjects applies the objects in dependency order, and reports each of them.  With
+// serverSide, the objects are applied by server-side apply, which needs all the objects to be
+// served by a kube-apiserver.
+func applyObjects(ctx context.Context, restConfig *rest.Config, objects []seedObject, serverSide bool) (applied, skipped, failed int) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("Failed building discovery client: %v", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("Failed building dynamic client: %v", err)
+	}
+
+	mappings := map[*unstructured.Unstructured]*meta.RESTMapping{}
+	for _, o := range objects {
+		gvk := o.obj.GroupVersionKind()
+		if m, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+			mappings[o.obj] = m
+		}
+	}
+	// namespaces first, then the cluster-scoped objects and the namespaced objects last, so
+	// that the objects are applied after the objects they depend on
+	rank := func(o seedObject) int {
+		switch m, ok := mappings[o.obj]; {
+		case o.obj.GetKind() == "Namespace" && o.obj.GroupVersionKind().Group == "":
+			return 0
+		case ok && m.Scope.Name() == meta.RESTScopeNameRoot:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return rank(objects[i]) < rank(objects[j])
+	})
+
+	for _, o := range objects {
+		name := fmt.Sprintf("%s/%s", strings.ToLower(o.obj.GetKind()), o.obj.GetName())
+		m, ok := mappings[o.obj]
+		if !ok && rank(o) == 0 {
+			// the standalone apiserver serves no namespaces, nor requires them
+			skipped++
+			klog.Infof("Skipped %s from %s, namespaces are not served by the apiserver", name, o.file)
+			continue
+		}
+		if !ok {
+			failed++
+			klog.Errorf("Failed applying %s from %s: %s is not served by the apiserver", name, o.file, o.obj.GroupVersionKind())
+			continue
+		}
+		apply := applyObject
+		if serverSide {
+			apply = serverSideApplyObject
+		}
+		result, err := apply(ctx, resourceInterface(client, m, o.obj), o.obj)
+		if err != nil {
+			failed++
+			klog.Errorf("Failed applying %s from %s: %v", name, o.file, err)
+			continue
+		}
+		applied++
+		klog.Infof("Applied %s from %s: %s", name, o.file, result)
+	}
+	return applied, skipped, failed
+}
+
+// readSeedObjects reads the objects of the yaml and json files of the directory, in the order of
+// the files and of the objects in each file.
+func readSeedObjects(dir string) ([]seedObject, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading the seed directory %s", dir)
+	}
+	var objects []seedObject
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		fileObjects, err := readSeedFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading %s", file)
+		}
+		for _, obj := range fileObjects {
+			objects = append(objects, seedObject{file: file, obj: obj})
+		}
+	}
+	return objects, nil
+}
+
+func readSeedFile(file string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var objects []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			// empty document
+			continue
+		}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// waitForReady polls the readyz of the apiserver until it's ready, for at most 2 minutes.
+func waitForReady(ctx context.Context, restConfig *rest.Config) error {
+	client, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	return wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		result, err := client.RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+		return err == nil && string(result) == "ok", nil
+	}, ctx.Done())
+}
+
+// resourceInterface returns the client of the resource of the object, in the namespace of the
+// object, or the default namespace.
+func resourceInterface(client dynamic.Interface, m *meta.RESTMapping, obj *unstructured.Unstructured) dynamic.ResourceInterface {
+	if m.Scope.Name() != meta.RESTScopeNameNamespace {
+		return client.Resource(m.Resource)
+	}
+	if len(obj.GetNamespace()) == 0 {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+	return client.Resource(m.Resource).Namespace(obj.GetNamespace())
+}
+
+// applyObject creates the object, or replaces the existing object, and returns whether it
+// was created or configured.  The apiservers built with apiserver-runtime don't support
+// server-side apply, so the objects are applied as a whole.
+func applyObject(ctx context.Context, resourceClient dynamic.ResourceInterface, obj *unstructured.Unstructured) (string, error) {
+	existing, err := resourceClient.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := resourceClient.Create(ctx, obj, metav1.CreateOptions{FieldManager: applyFieldManager})
+		return "created", err
+	}
+	if err != nil {
+		return "", err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	_, err = resourceClient.Update(ctx, obj, metav1.UpdateOptions{FieldManager: applyFieldManager})
+	return "configured", err
+}
+
+// serverSideApplyObject applies the object by server-side apply, taking over the fields of
+// other managers, so that the fields set by the defaults of the cluster are kept.
+func serverSideApplyObject(ctx context.Context, resourceClient dynamic.ResourceInterface, obj *unstructured.Unstructured) (string, error) {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	force := true
+	_, err = resourceClient.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: applyFieldManager,
+		Force:        &force,
+	})
+	return "serverside-applied", err
+}
@@ -8,7 +8,9 @@ import (
 )
 
 var streams = genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
-var clientFactory *genericclioptions.ConfigFlags
+
+// clientFactory is shared by the show commands, by the persistent flags of the show command.
+var clientFactory = genericclioptions.NewConfigFlags(true).WithDeprecatedPasswordFlag()
 
 var showCmd = &cobra.Command{
 	Use:   "show",
@@ -17,14 +19,19 @@ var showCmd = &cobra.Command{
 	Example: `
 # Show the current status for foo resource.
 apiserver-boot show resource foo
+
+# Show the number and size of the objects of the aggregated resources by namespace.
+apiserver-boot show objects
 `,
 	Run: RunShow,
 }
 
 func AddShow(cmd *cobra.Command) {
 	cmd.AddCommand(showCmd)
+	clientFactory.AddFlags(showCmd.PersistentFlags())
 	AddShowResource(showCmd)
 	AddApiserver(showCmd)
+	AddShowObjects(showCmd)
 }
 
 func RunShow(cmd *cobra.Command, args []string) {
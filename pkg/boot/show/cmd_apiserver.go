@@ -3,44 +3,96 @@ package show
 import (
 	"context"
 	"fmt"
-	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
 )
 
 var showApiserverCmd = &cobra.Command{
-	Use:     "apiserver",
-	Short:   "Show the running detail of the aggregated apiserver.",
-	Long:    "Show the running detail of the aggregated apiserver.",
-	Example: `apiserver-boot show apiserver -n <pod namespace> <pod name>`,
-	Run:     RunShowApiserver,
+	Use:   "apiserver [pod name]",
+	Short: "Show the running detail of the aggregated apiserver.",
+	Long: `Show the running detail of the aggregated apiserver.
+
+The detail is shown for a pod, or for all the replicas of an aggregated apiserver, the pods
+selected by the Service of --service or of the APIServices of the group of --api.  The replicas
+are port-forwarded to at the same time, each on an ephemeral local port, and the replicas whose
+discovery differs from the other replicas are highlighted.
+
+The health is broken down by the named checks of /livez, /readyz and /healthz, with the time
+since each check was last seen failing by --watch, to tell why a replica is not ready.
+
+The metrics are summarized since the apiserver started, or since the previous refresh with
+--watch: the rate, error rate and latency percentiles of the requests by resource and verb, the
+objects stored by resource, the latency of the requests to etcd, the open watches, the
+goroutines and the garbage collection pauses.`,
+	Example: `apiserver-boot show apiserver -n <pod namespace> <pod name>
+
+# Show the running detail as json
+apiserver-boot show apiserver -n <pod namespace> <pod name> -o json
+
+# Compare all the replicas of the aggregated apiserver serving the group foo.example.com
+apiserver-boot show apiserver --api foo.example.com
+
+# Refresh the request rates and latencies every 10 seconds
+apiserver-boot show apiserver --api foo.example.com --watch --watch-interval 10s`,
+	Run: RunShowApiserver,
 }
 
 var port int32
+var service string
+var apiGroup string
+var watch bool
+var watchInterval time.Duration
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
 
 func AddApiserver(cmd *cobra.Command) {
 	cmd.AddCommand(showApiserverCmd)
 
-	kubeConfigFlags := genericclioptions.NewConfigFlags(true).WithDeprecatedPasswordFlag()
-	kubeConfigFlags.AddFlags(showApiserverCmd.Flags())
-
-	clientFactory = kubeConfigFlags
 	showApiserverCmd.Flags().Int32VarP(&port, "port", "p", 443,
-		"The serving port of the target aggregated apiserver")
+		"The serving port of the target aggregated apiserver, defaults to the target port of the Service with --service or --api")
+	showApiserverCmd.Flags().StringVar(&service, "service", "",
+		"The <namespace>/<name> of the Service of the aggregated apiserver, to show all its replicas")
+	showApiserverCmd.Flags().StringVar(&apiGroup, "api", "",
+		"The group of the APIServices of the aggregated apiserver, to show all its replicas")
+	showApiserverCmd.Flags().BoolVarP(&watch, "watch", "w", false,
+		"Refresh at every --watch-interval, with the metrics summarized since the previous refresh")
+	showApiserverCmd.Flags().DurationVar(&watchInterval, "watch-interval", 5*time.Second,
+		"The interval of the refreshes with --watch")
+	addOutputFlag(showApiserverCmd)
 }
 
 func ValidateApiserver(args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("should at least provide one pod name")
+	if err := validateOutput(); err != nil {
+		return err
+	}
+	selected := 0
+	for _, s := range []bool{len(args) > 0, len(service) > 0, len(apiGroup) > 0} {
+		if s {
+			selected++
+		}
+	}
+	if selected != 1 {
+		return fmt.Errorf("should provide exactly one of a pod name, --service or --api")
+	}
+	if len(service) > 0 && len(strings.Split(service, "/")) != 2 {
+		return fmt.Errorf("--service should be <namespace>/<name>")
 	}
 	return nil
 }
@@ -50,6 +102,7 @@ func RunShowApiserver(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(streams.ErrOut, "failed command validation: %v", err)
 		return
 	}
+	disableColorsUnlessTerminal()
 
 	kubeClientConfig, err := clientFactory.ToRESTConfig()
 	if err != nil {
@@ -61,107 +114,559 @@ func RunShowApiserver(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(streams.ErrOut, "Failed building kube client: %v", err)
 		return
 	}
+	var services []*corev1.Service
+	if len(args) == 0 {
+		services, err = getApiserverServices(kubeClientConfig, kubeClient)
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "%v", err)
+			return
+		}
+	}
+
+	// the histories of the replicas by pod, to summarize the metrics and the failed checks since
+	// the previous refreshes with --watch
+	histories := map[string]*replicaHistory{}
+	for {
+		if watch && isTerminal() && output == outputHuman {
+			fmt.Fprint(streams.Out, clearScreen)
+		}
+		if len(args) > 0 {
+			err = showApiserverPod(kubeClientConfig, kubeClient, args[0], histories)
+		} else {
+			err = showApiserverReplicas(kubeClientConfig, kubeClient, services, cmd.Flags().Changed("port"), histories)
+		}
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "%v\n", err)
+			if !watch {
+				return
+			}
+		}
+		if !watch {
+			return
+		}
+		time.Sleep(watchInterval)
+		if !isTerminal() || output != outputHuman {
+			fmt.Fprintln(streams.Out)
+		}
+	}
+}
 
-	podName := args[0]
+func showApiserverPod(kubeClientConfig *rest.Config, kubeClient kubernetes.Interface, podName string, histories map[string]*replicaHistory) error {
 	podNamespace := corev1.NamespaceDefault
-	if clientFactory.Namespace != nil {
+	if clientFactory.Namespace != nil && len(*clientFactory.Namespace) > 0 {
 		podNamespace = *clientFactory.Namespace
 	}
-
 	pod, err := kubeClient.CoreV1().Pods(podNamespace).
 		Get(context.TODO(), podName, metav1.GetOptions{})
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "Failed getting pod %v/%v: %v", podNamespace, podName, err)
-		return
+		return fmt.Errorf("failed getting pod %v/%v: %v", podNamespace, podName, err)
+	}
+	key := pod.Namespace + "/" + pod.Name
+	if histories[key] == nil {
+		histories[key] = newReplicaHistory()
+	}
+	status, err := getApiserverStatus(kubeClientConfig, pod, port, histories[key])
+	if err != nil {
+		return err
+	}
+	if err := printApiserverStatus(status); err != nil {
+		return fmt.Errorf("failed printing: %v", err)
+	}
+	return nil
+}
+
+func showApiserverReplicas(kubeClientConfig *rest.Config, kubeClient kubernetes.Interface, services []*corev1.Service, portChanged bool, histories map[string]*replicaHistory) error {
+	list := &ApiserverStatusList{Items: []ApiserverStatus{}}
+	for _, svc := range services {
+		pods, err := getServicePods(kubeClient, svc)
+		if err != nil {
+			return err
+		}
+		targetPort := port
+		if !portChanged {
+			targetPort = serviceTargetPort(svc)
+		}
+		list.Items = append(list.Items, getReplicaStatuses(kubeClientConfig, pods, targetPort, histories)...)
+	}
+	if len(list.Items) == 0 {
+		return fmt.Errorf("no pods found for the aggregated apiserver")
+	}
+	compareDiscovery(list.Items)
+	if err := printApiserverStatusList(list); err != nil {
+		return fmt.Errorf("failed printing: %v", err)
+	}
+	return nil
+}
+
+// getApiserverServices returns the Service of --service, or the Services of the APIServices of
+// --api.
+func getApiserverServices(kubeClientConfig *rest.Config, kubeClient kubernetes.Interface) ([]*corev1.Service, error) {
+	var refs []string
+	if len(service) > 0 {
+		refs = []string{service}
+	} else {
+		kubeAggregatorClient, err := clientset.NewForConfig(kubeClientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed building kube-aggregator client: %v", err)
+		}
+		apiServices, err := kubeAggregatorClient.ApiregistrationV1().APIServices().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed listing APIServices: %v", err)
+		}
+		seen := map[string]bool{}
+		for _, a := range apiServices.Items {
+			if a.Spec.Group != apiGroup || a.Spec.Service == nil {
+				continue
+			}
+			ref := a.Spec.Service.Namespace + "/" + a.Spec.Service.Name
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			return nil, fmt.Errorf("no APIService of the group %q served by an aggregated apiserver", apiGroup)
+		}
+	}
+
+	var services []*corev1.Service
+	for _, ref := range refs {
+		parts := strings.Split(ref, "/")
+		svc, err := kubeClient.CoreV1().Services(parts[0]).Get(context.TODO(), parts[1], metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed getting service %v: %v", ref, err)
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// getServicePods returns the pods selected by the Service.
+func getServicePods(kubeClient kubernetes.Interface, svc *corev1.Service) ([]corev1.Pod, error) {
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %v/%v has no selector", svc.Namespace, svc.Name)
+	}
+	podList, err := kubeClient.CoreV1().Pods(svc.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing pods: %v", err)
+	}
+	return podList.Items, nil
+}
+
+// serviceTargetPort returns the target port of the first port of the Service, or --port if the
+// target port is named.
+func serviceTargetPort(svc *corev1.Service) int32 {
+	if len(svc.Spec.Ports) == 0 || svc.Spec.Ports[0].TargetPort.Type != intstr.Int {
+		return port
+	}
+	if svc.Spec.Ports[0].TargetPort.IntVal == 0 {
+		return svc.Spec.Ports[0].Port
+	}
+	return svc.Spec.Ports[0].TargetPort.IntVal
+}
+
+// getReplicaStatuses port-forwards to all the pods at the same time, and returns their statuses
+// in the order of the pods.  The replicas which can't be reached are returned with the error.
+func getReplicaStatuses(kubeClientConfig *rest.Config, pods []corev1.Pod, targetPort int32, histories map[string]*replicaHistory) []ApiserverStatus {
+	statuses := make([]ApiserverStatus, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		key := pods[i].Namespace + "/" + pods[i].Name
+		if histories[key] == nil {
+			histories[key] = newReplicaHistory()
+		}
+		wg.Add(1)
+		go func(i int, history *replicaHistory) {
+			defer wg.Done()
+			status, err := getApiserverStatus(kubeClientConfig, &pods[i], targetPort, history)
+			if err != nil {
+				status = &ApiserverStatus{
+					Namespace: pods[i].Namespace,
+					Name:      pods[i].Name,
+					Error:     err.Error(),
+				}
+			}
+			statuses[i] = *status
+		}(i, histories[key])
 	}
+	wg.Wait()
+	return statuses
+}
 
-	proxy := utils.NewLocalProxy(kubeClientConfig, podNamespace, podName, port)
+// getApiserverStatus port-forwards to the pod on an ephemeral local port, and returns its health,
+// version, discovery and metrics, with the metrics summarized since the previous sample of the
+// history.
+func getApiserverStatus(kubeClientConfig *rest.Config, pod *corev1.Pod, targetPort int32, history *replicaHistory) (*ApiserverStatus, error) {
+	proxy := utils.NewEphemeralLocalProxy(kubeClientConfig, pod.Namespace, pod.Name, targetPort)
 	stopFunc, err := proxy.Listen()
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "Failed running local proxy to port %v: %v", port, err)
-		return
+		return nil, fmt.Errorf("failed running local proxy to port %v: %v", targetPort, err)
 	}
 	defer stopFunc()
 
 	podClientConfig := rest.CopyConfig(kubeClientConfig)
 	podClientConfig.Insecure = true
 	podClientConfig.CAData = nil
-	podClientConfig.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+	podClientConfig.CAFile = ""
+	podClientConfig.Host = proxy.Addr()
 
 	podClient, err := kubernetes.NewForConfig(podClientConfig)
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "Failed building aggregated apiserver client: %v", err)
-		return
+		return nil, fmt.Errorf("failed building aggregated apiserver client: %v", err)
 	}
 
-	resultData, err := podClient.RESTClient().Get().
-		AbsPath("/healthz").DoRaw(context.TODO())
+	health, err := getHealth(podClient, history)
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "Failed calling aggregated apiserver: %v", err)
-		return
+		return nil, err
+	}
+	status := &ApiserverStatus{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Healthy:   true,
+		Health:    health,
+	}
+	for _, h := range health {
+		status.Healthy = status.Healthy && h.Healthy
+	}
+	if version, err := podClient.Discovery().ServerVersion(); err == nil {
+		status.Version = version.GitVersion
 	}
-	isHealthy := string(resultData) == "ok"
-	// group -> version -> resource
-	apiVersions := make(map[string]map[string][]string)
 	_, apiResourceLists, err := podClient.Discovery().ServerGroupsAndResources()
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "Failed calling api-discovery upon the aggregated apiserver: %v", err)
-		return
+		return nil, fmt.Errorf("failed calling api-discovery upon the aggregated apiserver: %v", err)
 	}
 	for _, apiResourceList := range apiResourceLists {
-		if apiVersions[apiResourceList.GroupVersion] == nil {
-			apiVersions[apiResourceList.GroupVersion] = make(map[string][]string)
-		}
+		gvResources := GroupVersionResources{GroupVersion: apiResourceList.GroupVersion}
 		for _, apiResource := range apiResourceList.APIResources {
-			apiVersions[apiResourceList.GroupVersion][apiResource.Name] = apiResource.Verbs
+			gvResources.Resources = append(gvResources.Resources, ResourceVerbs{
+				Name:  apiResource.Name,
+				Verbs: apiResource.Verbs,
+			})
+		}
+		sort.Slice(gvResources.Resources, func(i, j int) bool {
+			return gvResources.Resources[i].Name < gvResources.Resources[j].Name
+		})
+		status.Resources = append(status.Resources, gvResources)
+	}
+	sort.Slice(status.Resources, func(i, j int) bool {
+		return status.Resources[i].GroupVersion < status.Resources[j].GroupVersion
+	})
+
+	status.Memory = MemoryStatus{Request: "<none>", InUse: "<none>", Limit: "<none>"}
+	for _, c := range pod.Spec.Containers {
+		if assignedMemReq, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+			status.Memory.Request = assignedMemReq.String()
+		}
+		if assignedMemLimits, ok := c.Resources.Limits[corev1.ResourceMemory]; ok {
+			status.Memory.Limit = assignedMemLimits.String()
 		}
 	}
 
 	metricsData, err := podClient.RESTClient().Get().
 		AbsPath("/metrics").DoRaw(context.TODO())
+	if err != nil {
+		return status, nil
+	}
+	sample, err := parseMetrics(metricsData, time.Now())
+	if err != nil {
+		return status, nil
+	}
+	status.Memory.InUse = sample.inUseMemory()
+	status.Metrics = summarizeMetrics(sample, history.sample)
+	status.FeatureGates = enabledFeatureGates(sample)
+	history.sample = sample
+	return status, nil
+}
 
-	prefixWriter := utils.NewPrefixWriter(streams.Out)
-	prefixWriter.Write(utils.LEVEL_0, "Pod Namespace: %v\n", podNamespace)
-	prefixWriter.Write(utils.LEVEL_0, "Pod Name: %v\n", podName)
-	prefixWriter.Write(utils.LEVEL_0, "Healthiness: %v\n", strconv.FormatBool(isHealthy))
+// discoveryResources returns the served resources with their verbs, as
+// "<resource>.<group version> [<verbs>]".
+func discoveryResources(status *ApiserverStatus) []string {
+	var resources []string
+	for _, gv := range status.Resources {
+		for _, r := range gv.Resources {
+			verbs := append([]string{}, r.Verbs...)
+			sort.Strings(verbs)
+			resources = append(resources, fmt.Sprintf("%s.%s %v", r.Name, gv.GroupVersion, verbs))
+		}
+	}
+	return resources
+}
+
+// compareDiscovery compares the discovery of the reachable replicas against the discovery served
+// by the most replicas, and records the differences of the others.
+func compareDiscovery(statuses []ApiserverStatus) {
+	counts := map[string]int{}
+	reference := ""
+	for i := range statuses {
+		if len(statuses[i].Error) > 0 {
+			continue
+		}
+		key := strings.Join(discoveryResources(&statuses[i]), "\n")
+		counts[key]++
+		if counts[key] > counts[reference] {
+			reference = key
+		}
+	}
+	referenceResources := sets.NewString(strings.Split(reference, "\n")...)
+	for i := range statuses {
+		if len(statuses[i].Error) > 0 {
+			continue
+		}
+		resources := sets.NewString(discoveryResources(&statuses[i])...)
+		for _, r := range resources.Difference(referenceResources).List() {
+			statuses[i].DiscoveryDiff = append(statuses[i].DiscoveryDiff, "+"+r)
+		}
+		for _, r := range referenceResources.Difference(resources).List() {
+			statuses[i].DiscoveryDiff = append(statuses[i].DiscoveryDiff, "-"+r)
+		}
+	}
+}
+
+func printApiserverStatus(status *ApiserverStatus) error {
+	if ok, err := printStructured(status); ok {
+		return err
+	}
+	if output == outputWide {
+		w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tNAME\tVERSION\tHEALTHY\tMEMORY REQUEST\tMEMORY IN USE\tMEMORY LIMIT\tRATE\tERRORS\tP99\tGOROUTINES\tRESOURCES")
+		var resources []string
+		for _, gv := range status.Resources {
+			for _, r := range gv.Resources {
+				resources = append(resources, r.Name+"."+gv.GroupVersion)
+			}
+		}
+		rate, errorRate, p99 := requestColumns(status.Metrics)
+		goroutines := "<none>"
+		if status.Metrics != nil {
+			goroutines = strconv.FormatInt(status.Metrics.Goroutines, 10)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", status.Namespace, status.Name,
+			stringOrNone(status.Version), status.Healthy,
+			status.Memory.Request, status.Memory.InUse, status.Memory.Limit, rate, errorRate, p99, goroutines,
+			strings.Join(resources, ","))
+		return w.Flush()
+	}
+
+	w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+	prefixWriter := utils.NewPrefixWriter(w)
+	prefixWriter.Write(utils.LEVEL_0, "Pod Namespace: %v\n", status.Namespace)
+	prefixWriter.Write(utils.LEVEL_0, "Pod Name: %v\n", status.Name)
+	prefixWriter.Write(utils.LEVEL_0, "Version: %v\n", stringOrNone(status.Version))
+	prefixWriter.Write(utils.LEVEL_0, "Healthiness: %v\n", strconv.FormatBool(status.Healthy))
+	printHealth(prefixWriter, status.Health)
+	printFeatureGates(prefixWriter, status.FeatureGates)
 	prefixWriter.Write(utils.LEVEL_0, "Resources:\n")
-	for apiVersion, resources := range apiVersions {
-		prefixWriter.Write(utils.LEVEL_1, "APIVersion: %v\n", apiVersion)
-		for resource, verbs := range resources {
-			prefixWriter.Write(utils.LEVEL_2, "Resource: %v %v\n", resource, verbs)
+	for _, gv := range status.Resources {
+		prefixWriter.Write(utils.LEVEL_1, "APIVersion: %v\n", gv.GroupVersion)
+		for _, r := range gv.Resources {
+			prefixWriter.Write(utils.LEVEL_2, "Resource: %v %v\n", r.Name, r.Verbs)
 		}
 	}
 	prefixWriter.Write(utils.LEVEL_0, "Metrics:\n")
-	memReq := "<none>"
-	memLimit := "<none>"
-	rss, _ := parseInUsedMemory(string(metricsData))
-	for _, c := range pod.Spec.Containers {
-		if assignedMemReq, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
-			memReq = assignedMemReq.String()
+	prefixWriter.Write(utils.LEVEL_1, "Memory: [%v|%v|%v]\n", status.Memory.Request, status.Memory.InUse, status.Memory.Limit)
+	if status.Metrics != nil {
+		printMetrics(prefixWriter, status.Metrics)
+	}
+	prefixWriter.Flush()
+	return nil
+}
+
+// printHealth prints the checks of the health endpoints, with the time since the checks last
+// failed.
+func printHealth(prefixWriter utils.PrefixWriter, health []HealthStatus) {
+	for _, h := range health {
+		result := color.GreenString("ok")
+		if !h.Healthy {
+			result = color.RedString("failed")
 		}
-		if assignedMemLimits, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
-			memLimit = assignedMemLimits.String()
+		prefixWriter.Write(utils.LEVEL_1, "%v: %v\n", h.Endpoint, result)
+		for _, c := range h.Checks {
+			line := color.GreenString("[+]") + c.Name
+			if !c.Healthy {
+				line = color.RedString("[-]") + c.Name + " " + c.Reason
+			}
+			if c.LastFailure != nil {
+				line += fmt.Sprintf(" (last failed %v ago)", time.Since(c.LastFailure.Time).Round(time.Second))
+			}
+			prefixWriter.Write(utils.LEVEL_2, "%v\n", line)
 		}
 	}
-	prefixWriter.Write(utils.LEVEL_1, "Memory: [%v|%v|%v]\n", memReq, rss, memLimit)
 }
 
-const (
-	inUsedMemoryMetricsName = "process_resident_memory_bytes"
-)
+// printFeatureGates prints the enabled feature gates by stage.
+func printFeatureGates(prefixWriter utils.PrefixWriter, gates []FeatureGate) {
+	if len(gates) == 0 {
+		return
+	}
+	prefixWriter.Write(utils.LEVEL_0, "Feature Gates:\n")
+	var stages []string
+	byStage := map[string][]string{}
+	for _, g := range gates {
+		stage := g.Stage
+		if len(stage) == 0 {
+			stage = "GA"
+		}
+		if _, ok := byStage[stage]; !ok {
+			stages = append(stages, stage)
+		}
+		byStage[stage] = append(byStage[stage], g.Name)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		prefixWriter.Write(utils.LEVEL_1, "%v: %v\n", stage, strings.Join(byStage[stage], ", "))
+	}
+}
 
-func parseInUsedMemory(metricsData string) (string, bool) {
-	lines := strings.Split(metricsData, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, inUsedMemoryMetricsName) {
-			bytesStr := strings.TrimSpace(strings.TrimPrefix(line, inUsedMemoryMetricsName))
-			q, err := resource.ParseQuantity(bytesStr)
-			if err != nil {
-				return "", false
+// failedChecks returns the failed checks as "<endpoint>/<check>".
+func failedChecks(health []HealthStatus) []string {
+	var failed []string
+	for _, h := range health {
+		for _, c := range h.Checks {
+			if !c.Healthy {
+				failed = append(failed, h.Endpoint+"/"+c.Name)
 			}
-			rssInMega := q.ScaledValue(resource.Mega)
-			return strconv.Itoa(int(rssInMega)) + "Mb", true
 		}
 	}
-	return "", false
+	return failed
+}
+
+func stringOrNone(s string) string {
+	if len(s) == 0 {
+		return "<none>"
+	}
+	return s
+}
+
+// printMetrics prints the summary of the metrics, with the tables aligned by the tabwriter
+// underlying the prefixWriter.
+func printMetrics(prefixWriter utils.PrefixWriter, m *MetricsStatus) {
+	window := (time.Duration(m.WindowSeconds) * time.Second).String()
+	if m.SincePrevious {
+		prefixWriter.Write(utils.LEVEL_1, "Window: last %v\n", window)
+	} else {
+		prefixWriter.Write(utils.LEVEL_1, "Window: since start, %v ago\n", window)
+	}
+	prefixWriter.Write(utils.LEVEL_1, "Goroutines: %v\n", m.Goroutines)
+	prefixWriter.Write(utils.LEVEL_1, "GC Pauses: %v collections, p50 %v, max %v\n",
+		m.GCPause.Count, formatSeconds(m.GCPause.P50), formatSeconds(m.GCPause.Max))
+
+	prefixWriter.Write(utils.LEVEL_1, "Requests:\n")
+	prefixWriter.Write(utils.LEVEL_2, "RESOURCE\tVERB\tRATE\tERRORS\tP50\tP90\tP99\n")
+	for _, r := range m.Requests {
+		prefixWriter.Write(utils.LEVEL_2, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.Resource, r.Verb,
+			formatRate(r.Rate), formatErrorRate(r.ErrorRate),
+			formatSeconds(r.Latency.P50), formatSeconds(r.Latency.P90), formatSeconds(r.Latency.P99))
+	}
+	if t := m.RequestTotal; t != nil {
+		prefixWriter.Write(utils.LEVEL_2, "<total>\t\t%v\t%v\t%v\t%v\t%v\n",
+			formatRate(t.Rate), formatErrorRate(t.ErrorRate),
+			formatSeconds(t.Latency.P50), formatSeconds(t.Latency.P90), formatSeconds(t.Latency.P99))
+	}
+
+	if len(m.StorageObjects) > 0 {
+		prefixWriter.Write(utils.LEVEL_1, "Storage Objects:\n")
+		for _, r := range m.StorageObjects {
+			prefixWriter.Write(utils.LEVEL_2, "%v\t%v\n", r.Resource, r.Count)
+		}
+	}
+	if len(m.Etcd) > 0 {
+		prefixWriter.Write(utils.LEVEL_1, "Etcd Requests:\n")
+		prefixWriter.Write(utils.LEVEL_2, "TYPE\tOPERATION\tRATE\tP50\tP90\tP99\n")
+		for _, r := range m.Etcd {
+			prefixWriter.Write(utils.LEVEL_2, "%v\t%v\t%v\t%v\t%v\t%v\n", r.Type, r.Operation, formatRate(r.Rate),
+				formatSeconds(r.Latency.P50), formatSeconds(r.Latency.P90), formatSeconds(r.Latency.P99))
+		}
+	}
+	if len(m.Watches) > 0 {
+		prefixWriter.Write(utils.LEVEL_1, "Watches:\n")
+		for _, r := range m.Watches {
+			prefixWriter.Write(utils.LEVEL_2, "%v\t%v\n", r.Resource, r.Count)
+		}
+	}
+}
+
+// requestColumns returns the rate, error rate and p99 latency of all the resource requests.
+func requestColumns(m *MetricsStatus) (string, string, string) {
+	if m == nil || m.RequestTotal == nil {
+		return "<none>", "<none>", "<none>"
+	}
+	t := m.RequestTotal
+	return formatRate(t.Rate), formatErrorRate(t.ErrorRate), formatSeconds(t.Latency.P99)
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', 2, 64) + "/s"
+}
+
+func formatErrorRate(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 1, 64) + "%"
+}
+
+// printApiserverStatusList prints the replicas side by side, one per row.
+func printApiserverStatusList(list *ApiserverStatusList) error {
+	if ok, err := printStructured(list); ok {
+		return err
+	}
+	w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+	header := "NAMESPACE\tNAME\tVERSION\tHEALTHY\tMEMORY\tRATE\tERRORS\tP99\tRESOURCES\tDISCOVERY"
+	if output == outputWide {
+		header += "\tSERVED"
+	}
+	fmt.Fprintln(w, header)
+	for _, status := range list.Items {
+		if len(status.Error) > 0 {
+			fmt.Fprintf(w, "%v\t%v\t\t%v\t\t\t\t\t\t%v\n", status.Namespace, status.Name, color.RedString("unknown"), color.RedString(status.Error))
+			continue
+		}
+		healthy := color.GreenString("true")
+		if !status.Healthy {
+			healthy = color.RedString("false")
+		}
+		discovery := color.GreenString("consistent")
+		if len(status.DiscoveryDiff) > 0 {
+			discovery = color.RedString("differs")
+		}
+		resources := discoveryResources(&status)
+		rate, errorRate, p99 := requestColumns(status.Metrics)
+		row := fmt.Sprintf("%v\t%v\t%v\t%v\t%v|%v|%v\t%v\t%v\t%v\t%v\t%v", status.Namespace, status.Name,
+			stringOrNone(status.Version), healthy,
+			status.Memory.Request, status.Memory.InUse, status.Memory.Limit, rate, errorRate, p99, len(resources), discovery)
+		if output == outputWide {
+			var served []string
+			for _, gv := range status.Resources {
+				for _, r := range gv.Resources {
+					served = append(served, r.Name+"."+gv.GroupVersion)
+				}
+			}
+			row += "\t" + strings.Join(served, ",")
+		}
+		fmt.Fprintln(w, row)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	prefixWriter := utils.NewPrefixWriter(streams.Out)
+	for _, status := range list.Items {
+		if failed := failedChecks(status.Health); len(failed) > 0 {
+			prefixWriter.Write(utils.LEVEL_0, "\nFailed checks of %v:\n", status.Name)
+			for _, f := range failed {
+				prefixWriter.Write(utils.LEVEL_1, "%v\n", color.RedString(f))
+			}
+		}
+		if len(status.DiscoveryDiff) == 0 {
+			continue
+		}
+		prefixWriter.Write(utils.LEVEL_0, "\nDiscovery of %v differs from the other replicas:\n", status.Name)
+		for _, d := range status.DiscoveryDiff {
+			if strings.HasPrefix(d, "+") {
+				prefixWriter.Write(utils.LEVEL_1, "%v\n", color.GreenString(d))
+			} else {
+				prefixWriter.Write(utils.LEVEL_1, "%v\n", color.RedString(d))
+			}
+		}
+	}
+	return nil
 }
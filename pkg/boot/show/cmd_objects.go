@@ -0,0 +1,314 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+var showObjectsCmd = &cobra.Command{
+	Use:   "objects",
+	Short: "Show the number and size of the objects of the aggregated resources.",
+	Long: `Show the number and size of the objects of the aggregated resources.
+
+The resources of every group served by an aggregated apiserver are listed by pages, in the
+preferred version of the group, and summarized by resource and namespace: the number of objects,
+their total and largest size as json, the oldest and newest objects, and the objects stuck
+terminating with a deletionTimestamp or held by finalizers.`,
+	Example: `apiserver-boot show objects
+
+# Show the objects of the group foo.example.com
+apiserver-boot show objects --api foo.example.com
+
+# Export the inventory for capacity planning
+apiserver-boot show objects -o json > inventory.json`,
+	Run: RunShowObjects,
+}
+
+var objectsAPIGroup string
+var pageSize int64
+
+func AddShowObjects(cmd *cobra.Command) {
+	cmd.AddCommand(showObjectsCmd)
+
+	showObjectsCmd.Flags().StringVar(&objectsAPIGroup, "api", "",
+		"The group of the aggregated resources to show, defaults to all the groups served by aggregated apiservers")
+	showObjectsCmd.Flags().Int64Var(&pageSize, "page-size", 500,
+		"The number of objects listed by page")
+	addOutputFlag(showObjectsCmd)
+}
+
+func ValidateShowObjects() error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+	if pageSize <= 0 {
+		return fmt.Errorf("--page-size must be positive")
+	}
+	return nil
+}
+
+func RunShowObjects(cmd *cobra.Command, args []string) {
+	if err := ValidateShowObjects(); err != nil {
+		fmt.Fprintf(streams.ErrOut, "failed command validation: %v", err)
+		return
+	}
+	disableColorsUnlessTerminal()
+
+	kubeClientConfig, err := clientFactory.ToRESTConfig()
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed building kube client config: %v", err)
+		return
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeClientConfig)
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed building discovery client: %v", err)
+		return
+	}
+	dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed building dynamic client: %v", err)
+		return
+	}
+	kubeAggregatorClient, err := clientset.NewForConfig(kubeClientConfig)
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed building kube-aggregator client: %v", err)
+		return
+	}
+
+	groups, err := aggregatedGroups(kubeAggregatorClient)
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "%v", err)
+		return
+	}
+	if len(objectsAPIGroup) > 0 {
+		if !groups.Has(objectsAPIGroup) {
+			fmt.Fprintf(streams.ErrOut, "No aggregated apiserver serves the group %q", objectsAPIGroup)
+			return
+		}
+		groups = sets.NewString(objectsAPIGroup)
+	}
+
+	serverGroups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed api-discovery: %v", err)
+		return
+	}
+	inventory := &ObjectInventory{Resources: []ResourceInventory{}}
+	for _, group := range serverGroups.Groups {
+		if !groups.Has(group.Name) {
+			continue
+		}
+		gv := group.PreferredVersion.GroupVersion
+		apiResourceList, err := discoveryClient.ServerResourcesForGroupVersion(gv)
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "Failed api-discovery for %q: %v", gv, err)
+			return
+		}
+		for _, apiResource := range apiResourceList.APIResources {
+			if strings.Contains(apiResource.Name, "/") || !sets.NewString(apiResource.Verbs...).Has("list") {
+				continue
+			}
+			gvr := schema.GroupVersionResource{Group: group.Name, Version: group.PreferredVersion.Version, Resource: apiResource.Name}
+			inventory.Resources = append(inventory.Resources, getResourceInventory(dynamicClient, gvr, apiResource.Namespaced))
+		}
+	}
+
+	if err := printObjectInventory(inventory); err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed printing: %v", err)
+	}
+}
+
+// aggregatedGroups returns the groups of the APIServices served by aggregated apiservers.
+func aggregatedGroups(kubeAggregatorClient clientset.Interface) (sets.String, error) {
+	apiServices, err := kubeAggregatorClient.ApiregistrationV1().APIServices().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing APIServices: %v", err)
+	}
+	groups := sets.NewString()
+	for _, apiService := range apiServices.Items {
+		if apiService.Spec.Service != nil {
+			groups.Insert(apiService.Spec.Group)
+		}
+	}
+	return groups, nil
+}
+
+// getResourceInventory lists the objects of the resource in all the namespaces by pages, and
+// summarizes them.  The failure to list is recorded in the inventory.
+func getResourceInventory(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, namespaced bool) ResourceInventory {
+	inventory := ResourceInventory{
+		Resource:   gvr.GroupResource().String(),
+		Version:    gvr.Version,
+		Namespaced: namespaced,
+		Namespaces: []NamespaceInventory{},
+	}
+	namespaces := map[string]*NamespaceInventory{}
+	p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return dynamicClient.Resource(gvr).List(ctx, opts)
+	})
+	p.PageSize = pageSize
+	err := p.EachListItem(context.TODO(), metav1.ListOptions{}, func(obj runtime.Object) error {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("unexpected object %T", obj)
+		}
+		data, err := json.Marshal(u.Object)
+		if err != nil {
+			return err
+		}
+		size := int64(len(data))
+		ref := ObjectRef{Namespace: u.GetNamespace(), Name: u.GetName()}
+
+		inventory.Count++
+		inventory.TotalBytes += size
+		if inventory.Largest == nil || size > inventory.Largest.Bytes {
+			largest := ref
+			largest.Bytes = size
+			inventory.Largest = &largest
+		}
+		created := u.GetCreationTimestamp()
+		if inventory.Oldest == nil || created.Before(inventory.Oldest.CreationTimestamp) {
+			oldest := ref
+			oldest.CreationTimestamp = &created
+			inventory.Oldest = &oldest
+		}
+		if inventory.Newest == nil || inventory.Newest.CreationTimestamp.Before(&created) {
+			newest := ref
+			newest.CreationTimestamp = &created
+			inventory.Newest = &newest
+		}
+		if len(u.GetFinalizers()) > 0 {
+			inventory.WithFinalizers++
+		}
+		if deleted := u.GetDeletionTimestamp(); deleted != nil {
+			inventory.Terminating = append(inventory.Terminating, TerminatingObject{
+				Namespace:         u.GetNamespace(),
+				Name:              u.GetName(),
+				DeletionTimestamp: *deleted,
+				Finalizers:        u.GetFinalizers(),
+			})
+		}
+
+		if !namespaced {
+			return nil
+		}
+		ns := namespaces[u.GetNamespace()]
+		if ns == nil {
+			ns = &NamespaceInventory{Namespace: u.GetNamespace()}
+			namespaces[u.GetNamespace()] = ns
+		}
+		ns.Count++
+		ns.TotalBytes += size
+		return nil
+	})
+	if err != nil {
+		inventory.Error = err.Error()
+	}
+	for _, ns := range namespaces {
+		inventory.Namespaces = append(inventory.Namespaces, *ns)
+	}
+	sort.Slice(inventory.Namespaces, func(i, j int) bool {
+		return inventory.Namespaces[i].Namespace < inventory.Namespaces[j].Namespace
+	})
+	return inventory
+}
+
+func printObjectInventory(inventory *ObjectInventory) error {
+	if ok, err := printStructured(inventory); ok {
+		return err
+	}
+	if output == outputWide {
+		w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tVERSION\tNAMESPACE\tCOUNT\tSIZE")
+		for _, r := range inventory.Resources {
+			if !r.Namespaced {
+				fmt.Fprintf(w, "%v\t%v\t<cluster>\t%v\t%v\n", r.Resource, r.Version, r.Count, formatBytes(r.TotalBytes))
+				continue
+			}
+			if len(r.Namespaces) == 0 {
+				fmt.Fprintf(w, "%v\t%v\t<none>\t0\t0B\n", r.Resource, r.Version)
+			}
+			for _, ns := range r.Namespaces {
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", r.Resource, r.Version, ns.Namespace, ns.Count, formatBytes(ns.TotalBytes))
+			}
+		}
+		return w.Flush()
+	}
+
+	w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+	prefixWriter := utils.NewPrefixWriter(w)
+	for _, r := range inventory.Resources {
+		prefixWriter.Write(utils.LEVEL_0, "Resource: %v\n", r.Resource)
+		prefixWriter.Write(utils.LEVEL_1, "Version: %v\n", r.Version)
+		if len(r.Error) > 0 {
+			prefixWriter.Write(utils.LEVEL_1, "Error: %v\n", color.RedString(r.Error))
+		}
+		prefixWriter.Write(utils.LEVEL_1, "Objects: %v\n", r.Count)
+		prefixWriter.Write(utils.LEVEL_1, "Total Size: %v\n", formatBytes(r.TotalBytes))
+		if r.Largest != nil {
+			prefixWriter.Write(utils.LEVEL_1, "Largest: %v (%v)\n", r.Largest, formatBytes(r.Largest.Bytes))
+		}
+		if r.Oldest != nil {
+			prefixWriter.Write(utils.LEVEL_1, "Oldest: %v (%v)\n", r.Oldest, r.Oldest.CreationTimestamp)
+		}
+		if r.Newest != nil {
+			prefixWriter.Write(utils.LEVEL_1, "Newest: %v (%v)\n", r.Newest, r.Newest.CreationTimestamp)
+		}
+		if len(r.Namespaces) > 0 {
+			prefixWriter.Write(utils.LEVEL_1, "Namespaces:\n")
+			prefixWriter.Write(utils.LEVEL_2, "NAMESPACE\tCOUNT\tSIZE\n")
+			for _, ns := range r.Namespaces {
+				prefixWriter.Write(utils.LEVEL_2, "%v\t%v\t%v\n", ns.Namespace, ns.Count, formatBytes(ns.TotalBytes))
+			}
+		}
+		prefixWriter.Write(utils.LEVEL_1, "With Finalizers: %v\n", r.WithFinalizers)
+		if len(r.Terminating) > 0 {
+			prefixWriter.Write(utils.LEVEL_1, "Terminating:\n")
+			for _, t := range r.Terminating {
+				ref := ObjectRef{Namespace: t.Namespace, Name: t.Name}
+				prefixWriter.Write(utils.LEVEL_2, "%v\n", color.RedString("%v deleted %v ago, finalizers: %v",
+					ref, time.Since(t.DeletionTimestamp.Time).Round(time.Second), strings.Join(t.Finalizers, ", ")))
+			}
+		}
+	}
+	prefixWriter.Flush()
+	return nil
+}
+
+func (r ObjectRef) String() string {
+	if len(r.Namespace) == 0 {
+		return r.Name
+	}
+	return r.Namespace + "/" + r.Name
+}
+
+func formatBytes(bytes int64) string {
+	size := float64(bytes)
+	for _, unit := range []string{"B", "KiB", "MiB"} {
+		if size < 1024 {
+			return strconv.FormatFloat(size, 'f', -1, 64) + unit
+		}
+		size = math.Round(size/1024*10) / 10
+	}
+	return strconv.FormatFloat(size, 'f', -1, 64) + "GiB"
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
@@ -16,19 +19,26 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/selection"
-	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
 )
 
 var showResourceCmd = &cobra.Command{
-	Use:     "resource",
-	Short:   "Show the status of an aggregated resource.",
-	Long:    "Show the status of an aggregated resource.",
-	Example: `apiserver-boot show resource foo`,
-	Run:     RunShowResource,
+	Use:   "resource",
+	Short: "Show the status of an aggregated resource.",
+	Long: `Show the status of an aggregated resource.
+
+The serving certificate of each pod is inspected by a TLS handshake through a port-forward, and
+the served chain is verified against the caBundle of the APIService for <service>.<namespace>.svc
+like the kube-apiserver does.`,
+	Example: `apiserver-boot show resource foo
+
+# Show the status as a table with a row per pod
+apiserver-boot show resource foo -o wide`,
+	Run: RunShowResource,
 }
 
 var apiVersion string
@@ -36,15 +46,15 @@ var apiVersion string
 func AddShowResource(cmd *cobra.Command) {
 	cmd.AddCommand(showResourceCmd)
 
-	kubeConfigFlags := genericclioptions.NewConfigFlags(true).WithDeprecatedPasswordFlag()
-	kubeConfigFlags.AddFlags(showResourceCmd.Flags())
-
-	clientFactory = kubeConfigFlags
 	showResourceCmd.Flags().StringVar(&apiVersion, "api-version", "",
 		"The apiVersion of the showing resource.")
+	addOutputFlag(showResourceCmd)
 }
 
 func ValidateShowResource(args []string) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		return fmt.Errorf("should at least provide one resource name")
 	}
@@ -56,6 +66,7 @@ func RunShowResource(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(streams.ErrOut, "failed command validation: %v", err)
 		return
 	}
+	disableColorsUnlessTerminal()
 
 	kubeClientConfig, err := clientFactory.ToRESTConfig()
 	if err != nil {
@@ -118,6 +129,10 @@ func RunShowResource(cmd *cobra.Command, args []string) {
 
 	service, err := kubeClient.CoreV1().Services(serviceNamespace).
 		Get(context.TODO(), serviceName, metav1.GetOptions{})
+	if err != nil {
+		fmt.Fprintf(streams.ErrOut, "failed getting service %v/%v: %v", serviceNamespace, serviceName, err)
+		return
+	}
 	selector := labels.NewSelector()
 	for k, v := range service.Spec.Selector {
 		req, err := labels.NewRequirement(k, selection.Equals, []string{v})
@@ -135,61 +150,217 @@ func RunShowResource(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	prefixWriter := utils.NewPrefixWriter(streams.Out)
-	prefixWriter.Write(utils.LEVEL_0, "Resource Name: %v\n", resourceName)
-	prefixWriter.Write(utils.LEVEL_0, "APIService:\n")
-	prefixWriter.Write(utils.LEVEL_1, "Name: %v\n", apiService.Name)
-	prefixWriter.Write(utils.LEVEL_1, "Service: %v/%v\n", apiService.Spec.Service.Namespace, apiService.Spec.Service.Name)
-	available := ""
+	status := &ResourceStatus{
+		Resource: resourceName,
+		APIService: APIServiceStatus{
+			Name:    apiService.Name,
+			Service: apiService.Spec.Service.Namespace + "/" + apiService.Spec.Service.Name,
+		},
+		Pods: []PodStatus{},
+	}
 	for _, cond := range apiService.Status.Conditions {
 		if cond.Type == apiregistrationv1.Available {
-			available = string(cond.Status)
+			status.APIService.Available = string(cond.Status)
 		}
 	}
-	prefixWriter.Write(utils.LEVEL_1, "Available: %v\n", available)
-	prefixWriter.Write(utils.LEVEL_0, "Pods:\n")
-	for _, pod := range podList.Items {
-		printPod(kubeClient, &pod, prefixWriter)
+	for i := range podList.Items {
+		podStatus, err := getPodStatus(kubeClientConfig, kubeClient, apiService, service, &podList.Items[i])
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "%v", err)
+			return
+		}
+		status.Pods = append(status.Pods, *podStatus)
+	}
+
+	if err := printResourceStatus(status); err != nil {
+		fmt.Fprintf(streams.ErrOut, "Failed printing: %v", err)
 	}
+}
 
+func getPodStatus(kubeClientConfig *rest.Config, kubeClient kubernetes.Interface, apiService *apiregistrationv1.APIService, service *corev1.Service, pod *corev1.Pod) (*PodStatus, error) {
+	status := &PodStatus{
+		Name:  pod.Name,
+		State: string(pod.Status.Phase),
+	}
+	certData, err := GetServingCertificate(context.TODO(), kubeClient, pod)
+	switch {
+	case err == ErrCertificateNotFound:
+		status.Certificate.NotFound = true
+	case err != nil:
+		return nil, err
+	case certData == nil:
+		status.Certificate.SelfSigned = true
+	default:
+		notAfter, err := parseCertificateExpiry(certData)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing certificate of pod %v: %v", pod.Name, err)
+		}
+		status.Certificate.NotAfter = &metav1.Time{Time: *notAfter}
+		status.Certificate.Expired = time.Now().After(*notAfter)
+	}
+
+	serverName := fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace)
+	status.Certificate.ServerName = serverName
+	chain, err := getServedCertificates(kubeClientConfig, pod, podServingPort(apiService, service, pod), serverName)
+	if err != nil {
+		status.Certificate.HandshakeError = err.Error()
+		return status, nil
+	}
+	for _, cert := range chain {
+		status.Certificate.Served = append(status.Certificate.Served, certificateInfo(cert))
+	}
+	if certData != nil && len(chain) > 0 && !sameCertificate(certData, chain[0]) {
+		status.Certificate.MountedMismatch = true
+	}
+	if apiService.Spec.InsecureSkipTLSVerify {
+		status.Certificate.VerifySkipped = true
+		return status, nil
+	}
+	if err := verifyServedCertificates(chain, apiService.Spec.CABundle, serverName); err != nil {
+		status.Certificate.VerifyError = err.Error()
+	} else {
+		status.Certificate.Verified = true
+	}
+	return status, nil
+}
+
+func printResourceStatus(status *ResourceStatus) error {
+	if ok, err := printStructured(status); ok {
+		return err
+	}
+	if output == outputWide {
+		w := tabwriter.NewWriter(streams.Out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tAPISERVICE\tSERVICE\tAVAILABLE\tPOD\tSTATE\tCERTIFICATE NOT AFTER\tCERTIFICATE VERIFIED")
+		for _, pod := range status.Pods {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", status.Resource, status.APIService.Name,
+				status.APIService.Service, status.APIService.Available, pod.Name, pod.State,
+				certificateNotAfter(pod.Certificate), certificateVerified(pod.Certificate))
+		}
+		if len(status.Pods) == 0 {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t<none>\t\t\t\n", status.Resource, status.APIService.Name,
+				status.APIService.Service, status.APIService.Available)
+		}
+		return w.Flush()
+	}
+
+	prefixWriter := utils.NewPrefixWriter(streams.Out)
+	prefixWriter.Write(utils.LEVEL_0, "Resource Name: %v\n", status.Resource)
+	prefixWriter.Write(utils.LEVEL_0, "APIService:\n")
+	prefixWriter.Write(utils.LEVEL_1, "Name: %v\n", status.APIService.Name)
+	prefixWriter.Write(utils.LEVEL_1, "Service: %v\n", status.APIService.Service)
+	prefixWriter.Write(utils.LEVEL_1, "Available: %v\n", status.APIService.Available)
+	prefixWriter.Write(utils.LEVEL_0, "Pods:\n")
+	for _, pod := range status.Pods {
+		printPod(pod, prefixWriter)
+	}
+	return nil
 }
 
-func printPod(kubeClient kubernetes.Interface, pod *corev1.Pod, prefixWriter utils.PrefixWriter) {
+func printPod(pod PodStatus, prefixWriter utils.PrefixWriter) {
 	prefixWriter.Write(utils.LEVEL_1, "Name: %v\n", pod.Name)
-	prefixWriter.Write(utils.LEVEL_2, "State: %v\n", pod.Status.Phase)
+	prefixWriter.Write(utils.LEVEL_2, "State: %v\n", pod.State)
+	prefixWriter.Write(utils.LEVEL_2, "Certificate:\n")
+	switch {
+	case pod.Certificate.SelfSigned:
+		prefixWriter.Write(utils.LEVEL_3, "Mounted: %v\n", color.RedString("<self-signed>"))
+	case pod.Certificate.NotFound:
+		prefixWriter.Write(utils.LEVEL_3, "Mounted: %v\n", color.RedString("<not-found>"))
+	case pod.Certificate.NotAfter != nil:
+		if pod.Certificate.Expired {
+			prefixWriter.Write(utils.LEVEL_3, "Mounted Not After: %v\n", color.RedString(pod.Certificate.NotAfter.String()))
+		} else {
+			prefixWriter.Write(utils.LEVEL_3, "Mounted Not After: %v\n", color.GreenString(pod.Certificate.NotAfter.String()))
+		}
+	}
+
+	if len(pod.Certificate.HandshakeError) > 0 {
+		prefixWriter.Write(utils.LEVEL_3, "Served: %v\n", color.RedString(pod.Certificate.HandshakeError))
+		return
+	}
+	prefixWriter.Write(utils.LEVEL_3, "Served:\n")
+	for _, cert := range pod.Certificate.Served {
+		prefixWriter.Write(utils.LEVEL_4, "Subject: %v\n", cert.Subject)
+		prefixWriter.Write(utils.LEVEL_4, "  Issuer: %v\n", cert.Issuer)
+		names := append(append([]string{}, cert.DNSNames...), cert.IPAddresses...)
+		if len(names) > 0 {
+			prefixWriter.Write(utils.LEVEL_4, "  SANs: %v\n", strings.Join(names, ", "))
+		}
+		prefixWriter.Write(utils.LEVEL_4, "  Key: %v\n", cert.KeyType)
+		prefixWriter.Write(utils.LEVEL_4, "  Not Before: %v\n", cert.NotBefore)
+		if time.Now().After(cert.NotAfter.Time) {
+			prefixWriter.Write(utils.LEVEL_4, "  Not After: %v\n", color.RedString(cert.NotAfter.String()))
+		} else {
+			prefixWriter.Write(utils.LEVEL_4, "  Not After: %v\n", cert.NotAfter)
+		}
+	}
+	switch {
+	case pod.Certificate.VerifySkipped:
+		prefixWriter.Write(utils.LEVEL_3, "Verified: %v\n", color.YellowString("skipped by insecureSkipTLSVerify"))
+	case pod.Certificate.Verified:
+		prefixWriter.Write(utils.LEVEL_3, "Verified: %v\n",
+			color.GreenString("valid for %v and signed by the caBundle", pod.Certificate.ServerName))
+	default:
+		prefixWriter.Write(utils.LEVEL_3, "Verified: %v\n", color.RedString(pod.Certificate.VerifyError))
+	}
+	if pod.Certificate.MountedMismatch {
+		prefixWriter.Write(utils.LEVEL_3, "Mismatch: %v\n",
+			color.RedString("the served certificate differs from the mounted secret, restart the pod to serve it"))
+	}
+}
+
+// certificateVerified returns whether the served certificate is verified for the wide output.
+func certificateVerified(cert CertificateStatus) string {
+	switch {
+	case len(cert.HandshakeError) > 0:
+		return "<unknown>"
+	case cert.VerifySkipped:
+		return "<skipped>"
+	case cert.MountedMismatch:
+		return "mismatch"
+	}
+	return strconv.FormatBool(cert.Verified)
+}
+
+func certificateNotAfter(cert CertificateStatus) string {
+	switch {
+	case cert.SelfSigned:
+		return "<self-signed>"
+	case cert.NotFound:
+		return "<not-found>"
+	case cert.NotAfter != nil:
+		return cert.NotAfter.UTC().Format(time.RFC3339)
+	case len(cert.Served) > 0:
+		return cert.Served[0].NotAfter.UTC().Format(time.RFC3339)
+	}
+	return "<none>"
+}
+
+// ErrCertificateNotFound is returned by GetServingCertificate if the serving certificate of the
+// apiserver isn't mounted from a secret.
+var ErrCertificateNotFound = errors.New("serving certificate not found in the secrets mounted by the pod")
+
+// GetServingCertificate returns the PEM serving certificate of the aggregated apiserver of the
+// pod, read from the secret mounted at --tls-cert-file or --cert-dir.  It returns nil if the
+// apiserver serves a self-signed certificate.
+func GetServingCertificate(ctx context.Context, kubeClient kubernetes.Interface, pod *corev1.Pod) ([]byte, error) {
 	secretDir, certFile, isSelfSigned := isSelfSignedServerCertificate(pod)
 	if isSelfSigned {
-		prefixWriter.Write(utils.LEVEL_2, "Certificate: %v\n", color.RedString("<self-signed>"))
-		return
+		return nil, nil
 	}
 	secretName, found := findMountedSecretFromDir(pod, secretDir)
 	if !found {
-		prefixWriter.Write(utils.LEVEL_2, "Certificate: %v\n", color.RedString("<not-found>"))
-		return
+		return nil, ErrCertificateNotFound
 	}
 	secret, err := kubeClient.CoreV1().Secrets(pod.Namespace).
-		Get(context.TODO(), secretName, metav1.GetOptions{})
+		Get(ctx, secretName, metav1.GetOptions{})
 	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "failed getting secret %v: %v", secretName, err)
-		return
+		return nil, fmt.Errorf("failed getting secret %v: %v", secretName, err)
 	}
 	certData, ok := secret.Data[certFile]
 	if !ok {
-		fmt.Fprintf(streams.ErrOut, "failed getting secret data %v[%v]: %v", secretName, certFile, err)
-		return
-	}
-	notAfter, err := parseCertificateExpiry(certData)
-	if err != nil {
-		fmt.Fprintf(streams.ErrOut, "failed parsing certificate %v: %v", certFile, err)
-		return
+		return nil, fmt.Errorf("failed getting secret data %v[%v]", secretName, certFile)
 	}
-	prefixWriter.Write(utils.LEVEL_2, "Certificate:\n")
-	if time.Now().After(*notAfter) {
-		prefixWriter.Write(utils.LEVEL_3, "Not After: %v\n", color.RedString(notAfter.String()))
-	} else {
-		prefixWriter.Write(utils.LEVEL_3, "Not After: %v\n", color.GreenString(notAfter.String()))
-	}
-
+	return certData, nil
 }
 
 func find(resourceName string, apiResources []metav1.APIResource) *metav1.APIResource {
@@ -262,7 +433,7 @@ func findMountedSecretFromDir(pod *corev1.Pod, path string) (string, bool) {
 			if strings.HasPrefix(path, dirname) {
 				volumeName := m.Name
 				for _, v := range pod.Spec.Volumes {
-					if v.Name == volumeName {
+					if v.Name == volumeName && v.Secret != nil {
 						return v.Secret.SecretName, true
 					}
 				}
@@ -273,10 +444,18 @@ func findMountedSecretFromDir(pod *corev1.Pod, path string) (string, bool) {
 }
 
 func parseCertificateExpiry(pemCertData []byte) (*time.Time, error) {
-	b, _ := pem.Decode(pemCertData)
-	x509Cert, err := x509.ParseCertificate(b.Bytes)
+	x509Cert, err := ParseCertificate(pemCertData)
 	if err != nil {
 		return nil, err
 	}
 	return &x509Cert.NotAfter, nil
 }
+
+// ParseCertificate parses the first certificate of the PEM data.
+func ParseCertificate(pemCertData []byte) (*x509.Certificate, error) {
+	b, _ := pem.Decode(pemCertData)
+	if b == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return x509.ParseCertificate(b.Bytes)
+}
@@ -0,0 +1,109 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const featureEnabledMetricsName = "kubernetes_feature_enabled"
+
+// healthEndpoints are the health endpoints of the apiserver, queried with ?verbose.
+var healthEndpoints = []string{"livez", "readyz", "healthz"}
+
+// replicaHistory is what's remembered of a replica between the refreshes of --watch.
+type replicaHistory struct {
+	// sample is the previous sample of the metrics.
+	sample *metricsSample
+	// lastFailures are the times the checks were last seen failing, by "<endpoint>/<check>".
+	lastFailures map[string]time.Time
+}
+
+func newReplicaHistory() *replicaHistory {
+	return &replicaHistory{lastFailures: map[string]time.Time{}}
+}
+
+// getHealth queries the named checks of the health endpoints, skipping the endpoints not served
+// by older apiservers, and records the failing checks in the history.
+func getHealth(podClient kubernetes.Interface, history *replicaHistory) ([]HealthStatus, error) {
+	var statuses []HealthStatus
+	for _, endpoint := range healthEndpoints {
+		var code int
+		body, err := podClient.Discovery().RESTClient().Get().
+			AbsPath("/"+endpoint).Param("verbose", "").
+			Do(context.TODO()).StatusCode(&code).Raw()
+		switch {
+		case code == 0:
+			return nil, fmt.Errorf("failed calling aggregated apiserver: %v", err)
+		case code == http.StatusNotFound:
+			continue
+		}
+
+		now := time.Now()
+		status := HealthStatus{
+			Endpoint: endpoint,
+			Healthy:  code == http.StatusOK,
+			Checks:   parseHealthChecks(string(body)),
+		}
+		for i, c := range status.Checks {
+			key := endpoint + "/" + c.Name
+			if !c.Healthy {
+				history.lastFailures[key] = now
+			}
+			if t, ok := history.lastFailures[key]; ok {
+				status.Checks[i].LastFailure = &metav1.Time{Time: t}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// parseHealthChecks parses the verbose output of a health endpoint, with a line per check as
+// "[+]<name> ok", "[+]<name> excluded: ok" or "[-]<name> failed: <reason>".
+func parseHealthChecks(body string) []HealthCheck {
+	var checks []HealthCheck
+	for _, line := range strings.Split(body, "\n") {
+		var healthy bool
+		switch {
+		case strings.HasPrefix(line, "[+]"):
+			healthy = true
+		case strings.HasPrefix(line, "[-]"):
+			healthy = false
+		default:
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(line[3:]), " ", 2)
+		check := HealthCheck{Name: fields[0], Healthy: healthy}
+		if !healthy && len(fields) > 1 {
+			check.Reason = strings.TrimPrefix(strings.TrimPrefix(fields[1], "failed"), ": ")
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// enabledFeatureGates returns the enabled feature gates reported by the metrics of the newer
+// apiservers.
+func enabledFeatureGates(sample *metricsSample) []FeatureGate {
+	var gates []FeatureGate
+	for _, m := range sample.metrics(featureEnabledMetricsName) {
+		if value(m) != 1 {
+			continue
+		}
+		gates = append(gates, FeatureGate{
+			Name:  labelValue(m, "name"),
+			Stage: labelValue(m, "stage"),
+		})
+	}
+	sort.Slice(gates, func(i, j int) bool {
+		return gates[i].Name < gates[j].Name
+	})
+	return gates
+}
@@ -0,0 +1,45 @@
+package show
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHealthChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []HealthCheck
+	}{
+		{
+			name: "healthy",
+			body: "[+]ping ok\n[+]etcd ok\n[+]poststarthook/start-apiextensions-informers excluded: ok\nreadyz check passed\n",
+			expected: []HealthCheck{
+				{Name: "ping", Healthy: true},
+				{Name: "etcd", Healthy: true},
+				{Name: "poststarthook/start-apiextensions-informers", Healthy: true},
+			},
+		},
+		{
+			name: "failed checks with their reasons",
+			body: "[+]ping ok\n[-]etcd failed: reason withheld\n[-]informer-sync failed\nreadyz check failed\n",
+			expected: []HealthCheck{
+				{Name: "ping", Healthy: true},
+				{Name: "etcd", Healthy: false, Reason: "reason withheld"},
+				{Name: "informer-sync", Healthy: false, Reason: ""},
+			},
+		},
+		{
+			name: "not verbose",
+			body: "ok",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checks := parseHealthChecks(test.body)
+			if !reflect.DeepEqual(checks, test.expected) {
+				t.Errorf("got %+v, expected %+v", checks, test.expected)
+			}
+		})
+	}
+}
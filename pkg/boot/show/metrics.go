@@ -0,0 +1,370 @@
+package show
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	inUsedMemoryMetricsName    = "process_resident_memory_bytes"
+	startTimeMetricsName       = "process_start_time_seconds"
+	goroutinesMetricsName      = "go_goroutines"
+	gcDurationMetricsName      = "go_gc_duration_seconds"
+	requestTotalMetricsName    = "apiserver_request_total"
+	requestDurationMetricsName = "apiserver_request_duration_seconds"
+	longRunningMetricsName     = "apiserver_longrunning_requests"
+	storageObjectsMetricsName  = "apiserver_storage_objects"
+	etcdDurationMetricsName    = "etcd_request_duration_seconds"
+
+	// longRunningGaugeMetricsName is the deprecated name of apiserver_longrunning_requests,
+	// the only one served by the older apiservers
+	longRunningGaugeMetricsName = "apiserver_longrunning_gauge"
+)
+
+// metricsSample is a scrape of the /metrics of a replica.
+type metricsSample struct {
+	families map[string]*dto.MetricFamily
+	time     time.Time
+}
+
+func parseMetrics(data []byte, scrapeTime time.Time) (*metricsSample, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &metricsSample{families: families, time: scrapeTime}, nil
+}
+
+// inUseMemory returns the resident memory of the process, "<none>" if unknown.
+func (s *metricsSample) inUseMemory() string {
+	for _, m := range s.metrics(inUsedMemoryMetricsName) {
+		q := resource.NewQuantity(int64(value(m)), resource.BinarySI)
+		return strconv.Itoa(int(q.ScaledValue(resource.Mega))) + "Mb"
+	}
+	return "<none>"
+}
+
+// startTime returns the start time of the process, zero if unknown.
+func (s *metricsSample) startTime() time.Time {
+	for _, m := range s.metrics(startTimeMetricsName) {
+		return time.Unix(int64(value(m)), 0)
+	}
+	return time.Time{}
+}
+
+func (s *metricsSample) metrics(name string) []*dto.Metric {
+	if f, ok := s.families[name]; ok {
+		return f.Metric
+	}
+	return nil
+}
+
+// find returns the metric of the family with the same labels as m, nil if there's none.
+func (s *metricsSample) find(name string, m *dto.Metric) *dto.Metric {
+	key := labelsKey(m)
+	for _, other := range s.metrics(name) {
+		if labelsKey(other) == key {
+			return other
+		}
+	}
+	return nil
+}
+
+// summarizeMetrics summarizes the sample, over the time since the previous sample of the same
+// process, or since the process started if there's no previous sample.
+func summarizeMetrics(current, previous *metricsSample) *MetricsStatus {
+	if previous != nil && !previous.startTime().Equal(current.startTime()) {
+		previous = nil
+	}
+	var window time.Duration
+	switch {
+	case previous != nil:
+		window = current.time.Sub(previous.time)
+	case !current.startTime().IsZero():
+		window = current.time.Sub(current.startTime())
+	}
+	summary := &MetricsStatus{
+		SincePrevious: previous != nil,
+		WindowSeconds: window.Seconds(),
+	}
+	for _, m := range current.metrics(goroutinesMetricsName) {
+		summary.Goroutines = int64(value(m))
+	}
+	for _, m := range current.metrics(gcDurationMetricsName) {
+		summary.GCPause = gcPauseStatus(m.GetSummary())
+	}
+	summary.Requests, summary.RequestTotal = requestMetrics(current, previous, window)
+	summary.Etcd = etcdMetrics(current, previous, window)
+
+	for _, m := range current.metrics(storageObjectsMetricsName) {
+		summary.StorageObjects = append(summary.StorageObjects, ResourceCount{
+			Resource: labelValue(m, "resource"),
+			Count:    int64(value(m)),
+		})
+	}
+	longRunning := current.metrics(longRunningMetricsName)
+	if len(longRunning) == 0 {
+		longRunning = current.metrics(longRunningGaugeMetricsName)
+	}
+	watches := map[string]int64{}
+	for _, m := range longRunning {
+		if labelValue(m, "verb") == "WATCH" && len(labelValue(m, "resource")) > 0 {
+			watches[resourceName(m)] += int64(value(m))
+		}
+	}
+	for r, c := range watches {
+		summary.Watches = append(summary.Watches, ResourceCount{Resource: r, Count: c})
+	}
+	sort.Slice(summary.StorageObjects, func(i, j int) bool {
+		return summary.StorageObjects[i].Resource < summary.StorageObjects[j].Resource
+	})
+	sort.Slice(summary.Watches, func(i, j int) bool {
+		return summary.Watches[i].Resource < summary.Watches[j].Resource
+	})
+	return summary
+}
+
+// requestMetrics returns the rate, error rate and latencies of the resource requests by
+// resource and verb, and of all the resource requests.
+func requestMetrics(current, previous *metricsSample, window time.Duration) ([]RequestMetrics, *RequestMetrics) {
+	type requestKey struct{ resource, verb string }
+	counts := map[requestKey]float64{}
+	errors := map[requestKey]float64{}
+	var totalCount, totalErrors float64
+	for _, m := range current.metrics(requestTotalMetricsName) {
+		if len(labelValue(m, "resource")) == 0 {
+			continue
+		}
+		count := value(m)
+		if previous != nil {
+			// a counter lower than previously has been reset, and counts since the reset
+			if p := previous.find(requestTotalMetricsName, m); p != nil && value(p) <= count {
+				count -= value(p)
+			}
+		}
+		key := requestKey{resourceName(m), labelValue(m, "verb")}
+		counts[key] += count
+		totalCount += count
+		if code, _ := strconv.Atoi(labelValue(m, "code")); code >= 500 {
+			errors[key] += count
+			totalErrors += count
+		}
+	}
+
+	latencies := map[requestKey]*histogram{}
+	total := &histogram{}
+	for _, m := range current.metrics(requestDurationMetricsName) {
+		if len(labelValue(m, "resource")) == 0 {
+			continue
+		}
+		key := requestKey{resourceName(m), labelValue(m, "verb")}
+		if latencies[key] == nil {
+			latencies[key] = &histogram{}
+		}
+		var p *dto.Metric
+		if previous != nil {
+			p = previous.find(requestDurationMetricsName, m)
+		}
+		latencies[key].add(m, p)
+		total.add(m, p)
+	}
+
+	var requests []RequestMetrics
+	for key, count := range counts {
+		requests = append(requests, RequestMetrics{
+			Resource:  key.resource,
+			Verb:      key.verb,
+			Rate:      rate(count, window),
+			ErrorRate: ratio(errors[key], count),
+			Latency:   latencies[key].latency(),
+		})
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].Resource != requests[j].Resource {
+			return requests[i].Resource < requests[j].Resource
+		}
+		return requests[i].Verb < requests[j].Verb
+	})
+	return requests, &RequestMetrics{
+		Rate:      rate(totalCount, window),
+		ErrorRate: ratio(totalErrors, totalCount),
+		Latency:   total.latency(),
+	}
+}
+
+// etcdMetrics returns the rate and latencies of the requests to etcd by operation and type.
+func etcdMetrics(current, previous *metricsSample, window time.Duration) []EtcdMetrics {
+	var requests []EtcdMetrics
+	for _, m := range current.metrics(etcdDurationMetricsName) {
+		h := &histogram{}
+		var p *dto.Metric
+		if previous != nil {
+			p = previous.find(etcdDurationMetricsName, m)
+		}
+		h.add(m, p)
+		requests = append(requests, EtcdMetrics{
+			Operation: labelValue(m, "operation"),
+			Type:      labelValue(m, "type"),
+			Rate:      rate(h.count, window),
+			Latency:   h.latency(),
+		})
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].Type != requests[j].Type {
+			return requests[i].Type < requests[j].Type
+		}
+		return requests[i].Operation < requests[j].Operation
+	})
+	return requests
+}
+
+func gcPauseStatus(s *dto.Summary) GCPauseStatus {
+	status := GCPauseStatus{Count: int64(s.GetSampleCount())}
+	for _, q := range s.GetQuantile() {
+		switch q.GetQuantile() {
+		case 0.5:
+			status.P50 = q.GetValue()
+		case 1:
+			status.Max = q.GetValue()
+		}
+	}
+	return status
+}
+
+// histogram sums the buckets of histograms with the same buckets, by upper bound.
+type histogram struct {
+	count   float64
+	buckets map[float64]float64
+}
+
+// add adds the histogram of m, less the histogram of previous if not nil and not reset since.
+func (h *histogram) add(m, previous *dto.Metric) {
+	if h.buckets == nil {
+		h.buckets = map[float64]float64{}
+	}
+	if previous.GetHistogram().GetSampleCount() > m.GetHistogram().GetSampleCount() {
+		previous = nil
+	}
+	h.count += float64(m.GetHistogram().GetSampleCount())
+	for _, b := range m.GetHistogram().GetBucket() {
+		h.buckets[b.GetUpperBound()] += float64(b.GetCumulativeCount())
+	}
+	if previous != nil {
+		h.count -= float64(previous.GetHistogram().GetSampleCount())
+		for _, b := range previous.GetHistogram().GetBucket() {
+			h.buckets[b.GetUpperBound()] -= float64(b.GetCumulativeCount())
+		}
+	}
+}
+
+// quantile estimates the quantile like the histogram_quantile of prometheus, by interpolating
+// linearly within the bucket.
+func (h *histogram) quantile(q float64) float64 {
+	var bounds []float64
+	for b := range h.buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	rank := q * h.count
+	lower, lowerCount := 0.0, 0.0
+	for _, b := range bounds {
+		count := h.buckets[b]
+		if count >= rank {
+			if math.IsInf(b, 1) {
+				return lower
+			}
+			if count == lowerCount {
+				return b
+			}
+			return lower + (b-lower)*(rank-lowerCount)/(count-lowerCount)
+		}
+		lower, lowerCount = b, count
+	}
+	return lower
+}
+
+func (h *histogram) latency() LatencyStatus {
+	if h == nil || h.count <= 0 {
+		return LatencyStatus{}
+	}
+	return LatencyStatus{
+		P50: h.quantile(0.5),
+		P90: h.quantile(0.9),
+		P99: h.quantile(0.99),
+	}
+}
+
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func labelsKey(m *dto.Metric) string {
+	var pairs []string
+	for _, l := range m.GetLabel() {
+		pairs = append(pairs, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// resourceName returns the resource of the request metric as <resource>[/<subresource>][.<group>].
+func resourceName(m *dto.Metric) string {
+	name := labelValue(m, "resource")
+	if sub := labelValue(m, "subresource"); len(sub) > 0 {
+		name += "/" + sub
+	}
+	if group := labelValue(m, "group"); len(group) > 0 {
+		name += "." + group
+	}
+	return name
+}
+
+func rate(count float64, window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	return count / window.Seconds()
+}
+
+func ratio(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return part / total
+}
+
+// formatSeconds formats the seconds as a duration rounded for reading.
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
@@ -0,0 +1,187 @@
+package show
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustParseMetrics(t *testing.T, text string, scrapeTime time.Time) *metricsSample {
+	if len(text) == 0 {
+		return nil
+	}
+	sample, err := parseMetrics([]byte(text), scrapeTime)
+	if err != nil {
+		t.Fatalf("failed parsing the fixture: %v", err)
+	}
+	return sample
+}
+
+// durationHistogram returns the exposition of a request duration histogram of bars, with the
+// cumulative counts of the buckets 0.1, 0.5, 1 and +Inf.
+func durationHistogram(b01, b05, b1, inf int) string {
+	return fmt.Sprintf(`# TYPE apiserver_request_duration_seconds histogram
+apiserver_request_duration_seconds_bucket{resource="bars",verb="LIST",le="0.1"} %d
+apiserver_request_duration_seconds_bucket{resource="bars",verb="LIST",le="0.5"} %d
+apiserver_request_duration_seconds_bucket{resource="bars",verb="LIST",le="1"} %d
+apiserver_request_duration_seconds_bucket{resource="bars",verb="LIST",le="+Inf"} %d
+apiserver_request_duration_seconds_sum{resource="bars",verb="LIST"} 1
+apiserver_request_duration_seconds_count{resource="bars",verb="LIST"} %d
+`, b01, b05, b1, inf, inf)
+}
+
+func TestHistogramLatency(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		previous string
+		count    float64
+		expected LatencyStatus
+	}{
+		{
+			name:     "interpolated within the buckets",
+			current:  durationHistogram(0, 10, 20, 20),
+			count:    20,
+			expected: LatencyStatus{P50: 0.5, P90: 0.9, P99: 0.99},
+		},
+		{
+			name:     "+Inf bucket returns the highest finite bound",
+			current:  durationHistogram(5, 5, 5, 10),
+			count:    10,
+			expected: LatencyStatus{P50: 0.1, P90: 1, P99: 1},
+		},
+		{
+			name:     "since the previous sample",
+			current:  durationHistogram(10, 10, 20, 20),
+			previous: durationHistogram(10, 10, 10, 10),
+			count:    10,
+			expected: LatencyStatus{P50: 0.75, P90: 0.95, P99: 0.995},
+		},
+		{
+			name:     "counter reset ignores the previous sample",
+			current:  durationHistogram(0, 10, 20, 20),
+			previous: durationHistogram(10, 20, 30, 30),
+			count:    20,
+			expected: LatencyStatus{P50: 0.5, P90: 0.9, P99: 0.99},
+		},
+		{
+			name:     "empty window",
+			current:  durationHistogram(0, 10, 20, 20),
+			previous: durationHistogram(0, 10, 20, 20),
+			count:    0,
+			expected: LatencyStatus{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := mustParseMetrics(t, test.current, time.Now())
+			previous := mustParseMetrics(t, test.previous, time.Now())
+			h := &histogram{}
+			for _, m := range current.metrics(requestDurationMetricsName) {
+				if previous != nil {
+					h.add(m, previous.find(requestDurationMetricsName, m))
+				} else {
+					h.add(m, nil)
+				}
+			}
+			if h.count != test.count {
+				t.Errorf("got count %v, expected %v", h.count, test.count)
+			}
+			latency := h.latency()
+			for _, q := range []struct {
+				name          string
+				got, expected float64
+			}{
+				{"p50", latency.P50, test.expected.P50},
+				{"p90", latency.P90, test.expected.P90},
+				{"p99", latency.P99, test.expected.P99},
+			} {
+				if math.Abs(q.got-q.expected) > 1e-9 {
+					t.Errorf("got %s %v, expected %v", q.name, q.got, q.expected)
+				}
+			}
+		})
+	}
+}
+
+const startTime = `# TYPE process_start_time_seconds gauge
+process_start_time_seconds 1000
+`
+
+func TestSummarizeMetrics(t *testing.T) {
+	scrapeTime := time.Unix(1100, 0)
+	tests := []struct {
+		name            string
+		current         string
+		previous        string
+		expectedWatches []ResourceCount
+		expectedRate    float64
+	}{
+		{
+			name: "watches of apiserver_longrunning_requests",
+			current: startTime + `# TYPE apiserver_longrunning_requests gauge
+apiserver_longrunning_requests{group="foo.example.com",resource="bars",verb="WATCH"} 3
+apiserver_longrunning_requests{group="foo.example.com",resource="bars",verb="CONNECT"} 1
+`,
+			expectedWatches: []ResourceCount{{Resource: "bars.foo.example.com", Count: 3}},
+		},
+		{
+			name: "watches of the deprecated apiserver_longrunning_gauge",
+			current: startTime + `# TYPE apiserver_longrunning_gauge gauge
+apiserver_longrunning_gauge{group="foo.example.com",resource="bars",verb="WATCH"} 2
+`,
+			expectedWatches: []ResourceCount{{Resource: "bars.foo.example.com", Count: 2}},
+		},
+		{
+			name: "watches not counted twice by both metrics",
+			current: startTime + `# TYPE apiserver_longrunning_requests gauge
+apiserver_longrunning_requests{group="foo.example.com",resource="bars",verb="WATCH"} 3
+# TYPE apiserver_longrunning_gauge gauge
+apiserver_longrunning_gauge{group="foo.example.com",resource="bars",verb="WATCH"} 3
+`,
+			expectedWatches: []ResourceCount{{Resource: "bars.foo.example.com", Count: 3}},
+		},
+		{
+			name: "request rate since the process started",
+			current: startTime + `# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",resource="bars",verb="LIST"} 50
+`,
+			expectedRate: 0.5,
+		},
+		{
+			name: "request rate since the previous sample",
+			current: startTime + `# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",resource="bars",verb="LIST"} 50
+`,
+			previous: startTime + `# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",resource="bars",verb="LIST"} 40
+`,
+			expectedRate: 0.2,
+		},
+		{
+			name: "request counter reset since the previous sample",
+			current: startTime + `# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",resource="bars",verb="LIST"} 5
+`,
+			previous: startTime + `# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",resource="bars",verb="LIST"} 40
+`,
+			expectedRate: 0.1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := mustParseMetrics(t, test.current, scrapeTime)
+			previous := mustParseMetrics(t, test.previous, scrapeTime.Add(-50*time.Second))
+			summary := summarizeMetrics(current, previous)
+			if !reflect.DeepEqual(summary.Watches, test.expectedWatches) {
+				t.Errorf("got watches %v, expected %v", summary.Watches, test.expectedWatches)
+			}
+			if math.Abs(summary.RequestTotal.Rate-test.expectedRate) > 1e-9 {
+				t.Errorf("got request rate %v, expected %v", summary.RequestTotal.Rate, test.expectedRate)
+			}
+		})
+	}
+}
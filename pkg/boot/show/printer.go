@@ -0,0 +1,68 @@
+package show
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of the show commands, the human readable output by default.
+const (
+	outputHuman = ""
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputWide  = "wide"
+)
+
+var output string
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&output, "output", "o", outputHuman,
+		"Output format, one of json|yaml|wide. Defaults to the human readable output.")
+}
+
+func validateOutput() error {
+	switch output {
+	case outputHuman, outputJSON, outputYAML, outputWide:
+		return nil
+	}
+	return fmt.Errorf("unsupported output %q, expected one of json|yaml|wide", output)
+}
+
+// disableColorsUnlessTerminal disables the colors unless the output is a terminal, so that the
+// output of scripts and pipes has no escape codes.
+func disableColorsUnlessTerminal() {
+	if !isTerminal() {
+		color.NoColor = true
+	}
+}
+
+func isTerminal() bool {
+	f, ok := streams.Out.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+// printStructured prints the result as json or yaml, and returns false for the other outputs.
+func printStructured(result interface{}) (bool, error) {
+	var data []byte
+	var err error
+	switch output {
+	case outputJSON:
+		data, err = json.MarshalIndent(result, "", "  ")
+		data = append(data, '\n')
+	case outputYAML:
+		data, err = yaml.Marshal(result)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	_, err = streams.Out.Write(data)
+	return true, err
+}
@@ -0,0 +1,128 @@
+package show
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// podServingPort returns the port of the pod the Service forwards the port of the APIService to,
+// 443 by default.
+func podServingPort(apiService *apiregistrationv1.APIService, service *corev1.Service, pod *corev1.Pod) int32 {
+	port := int32(443)
+	if apiService.Spec.Service.Port != nil {
+		port = *apiService.Spec.Service.Port
+	}
+	for _, p := range service.Spec.Ports {
+		if p.Port != port {
+			continue
+		}
+		switch {
+		case p.TargetPort.Type == intstr.String:
+			for _, c := range pod.Spec.Containers {
+				for _, cp := range c.Ports {
+					if cp.Name == p.TargetPort.StrVal {
+						return cp.ContainerPort
+					}
+				}
+			}
+		case p.TargetPort.IntVal > 0:
+			return p.TargetPort.IntVal
+		}
+	}
+	return port
+}
+
+// getServedCertificates does a TLS handshake with the pod through a port-forward, and returns
+// the certificate chain served for the server name.
+func getServedCertificates(kubeClientConfig *rest.Config, pod *corev1.Pod, port int32, serverName string) ([]*x509.Certificate, error) {
+	proxy := utils.NewEphemeralLocalProxy(kubeClientConfig, pod.Namespace, pod.Name, port)
+	stopFunc, err := proxy.Listen()
+	if err != nil {
+		return nil, fmt.Errorf("failed running local proxy to port %v: %v", port, err)
+	}
+	defer stopFunc()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: handshakeTimeout}, "tcp", proxy.Addr(), &tls.Config{
+		ServerName: serverName,
+		// the chain is verified against the caBundle afterwards, to report why it's not valid
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed TLS handshake with port %v: %v", port, err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// verifyServedCertificates verifies the served chain is valid for the server name and signed by
+// the CA bundle, like the kube-apiserver does when proxying to the aggregated apiserver.
+func verifyServedCertificates(chain []*x509.Certificate, caBundle []byte, serverName string) error {
+	if len(chain) == 0 {
+		return errors.New("no certificate served")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("caBundle of the APIService has no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+func certificateInfo(cert *x509.Certificate) CertificateInfo {
+	info := CertificateInfo{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: metav1.Time{Time: cert.NotBefore},
+		NotAfter:  metav1.Time{Time: cert.NotAfter},
+		KeyType:   keyType(cert),
+		DNSNames:  cert.DNSNames,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+func keyType(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// sameCertificate returns whether the PEM certificate is the served certificate.
+func sameCertificate(pemCertData []byte, served *x509.Certificate) bool {
+	cert, err := ParseCertificate(pemCertData)
+	return err == nil && bytes.Equal(cert.Raw, served.Raw)
+}
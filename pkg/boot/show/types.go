@@ -0,0 +1,227 @@
+package show
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApiserverStatus is the running detail of a pod of an aggregated apiserver shown by
+// "show apiserver".
+type ApiserverStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Version is the git version of the apiserver.
+	Version string `json:"version,omitempty"`
+	// Healthy is whether all the health endpoints passed.
+	Healthy bool           `json:"healthy"`
+	Health  []HealthStatus `json:"health,omitempty"`
+	// FeatureGates are the enabled feature gates, reported by the metrics of the newer apiservers.
+	FeatureGates []FeatureGate `json:"featureGates,omitempty"`
+	// Resources are the resources served, by group version.
+	Resources []GroupVersionResources `json:"resources"`
+	Memory    MemoryStatus            `json:"memory"`
+	// Metrics is the summary of the /metrics, nil if the metrics can't be read.
+	Metrics *MetricsStatus `json:"metrics,omitempty"`
+	// DiscoveryDiff are the resources served only by this replica, prefixed by "+", and the
+	// resources served by the other replicas but not this one, prefixed by "-".
+	DiscoveryDiff []string `json:"discoveryDiff,omitempty"`
+	// Error is the failure reaching the replica, whose status is otherwise unknown.
+	Error string `json:"error,omitempty"`
+}
+
+// ApiserverStatusList are the replicas of an aggregated apiserver.
+type ApiserverStatusList struct {
+	Items []ApiserverStatus `json:"items"`
+}
+
+// HealthStatus is the result of a health endpoint, livez, readyz or healthz, by named check.
+type HealthStatus struct {
+	Endpoint string        `json:"endpoint"`
+	Healthy  bool          `json:"healthy"`
+	Checks   []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Reason  string `json:"reason,omitempty"`
+	// LastFailure is when the check was last seen failing, by this or a previous refresh of
+	// --watch.
+	LastFailure *metav1.Time `json:"lastFailure,omitempty"`
+}
+
+type FeatureGate struct {
+	Name  string `json:"name"`
+	Stage string `json:"stage,omitempty"`
+}
+
+type GroupVersionResources struct {
+	GroupVersion string          `json:"groupVersion"`
+	Resources    []ResourceVerbs `json:"resources"`
+}
+
+type ResourceVerbs struct {
+	Name  string   `json:"name"`
+	Verbs []string `json:"verbs"`
+}
+
+// MemoryStatus is the memory requested, in use and limited of the apiserver container, "<none>"
+// if unknown.
+type MemoryStatus struct {
+	Request string `json:"request"`
+	InUse   string `json:"inUse"`
+	Limit   string `json:"limit"`
+}
+
+// MetricsStatus summarizes the metrics of an aggregated apiserver over a window of time, since
+// the previous refresh with --watch, or since the apiserver started otherwise.
+type MetricsStatus struct {
+	SincePrevious bool    `json:"sincePrevious"`
+	WindowSeconds float64 `json:"windowSeconds"`
+	// Requests are the requests to the resources, by resource and verb.
+	Requests     []RequestMetrics `json:"requests,omitempty"`
+	RequestTotal *RequestMetrics  `json:"requestTotal,omitempty"`
+	// StorageObjects are the numbers of objects stored, by resource.
+	StorageObjects []ResourceCount `json:"storageObjects,omitempty"`
+	Etcd           []EtcdMetrics   `json:"etcd,omitempty"`
+	// Watches are the numbers of open watches, by resource.
+	Watches    []ResourceCount `json:"watches,omitempty"`
+	Goroutines int64           `json:"goroutines"`
+	GCPause    GCPauseStatus   `json:"gcPause"`
+}
+
+type RequestMetrics struct {
+	Resource string `json:"resource,omitempty"`
+	Verb     string `json:"verb,omitempty"`
+	// Rate is the number of requests per second.
+	Rate float64 `json:"rate"`
+	// ErrorRate is the ratio of the requests failed with a 5xx code.
+	ErrorRate float64       `json:"errorRate"`
+	Latency   LatencyStatus `json:"latency"`
+}
+
+type EtcdMetrics struct {
+	Operation string        `json:"operation"`
+	Type      string        `json:"type"`
+	Rate      float64       `json:"rate"`
+	Latency   LatencyStatus `json:"latency"`
+}
+
+// LatencyStatus are the percentiles of the latency in seconds, estimated from the buckets of the
+// histogram.
+type LatencyStatus struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+type ResourceCount struct {
+	Resource string `json:"resource"`
+	Count    int64  `json:"count"`
+}
+
+// GCPauseStatus are the number of garbage collections, and the median and maximum of the recent
+// pauses in seconds.
+type GCPauseStatus struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	Max   float64 `json:"max"`
+}
+
+// ResourceStatus is the status of an aggregated resource shown by "show resource".
+type ResourceStatus struct {
+	Resource   string           `json:"resource"`
+	APIService APIServiceStatus `json:"apiService"`
+	Pods       []PodStatus      `json:"pods"`
+}
+
+type APIServiceStatus struct {
+	Name string `json:"name"`
+	// Service is the "<namespace>/<name>" of the Service of the APIService.
+	Service   string `json:"service"`
+	Available string `json:"available"`
+}
+
+type PodStatus struct {
+	Name        string            `json:"name"`
+	State       string            `json:"state"`
+	Certificate CertificateStatus `json:"certificate"`
+}
+
+// CertificateStatus is the serving certificate of a pod, either self-signed, not found, or
+// mounted from a secret and expiring at NotAfter, and the chain actually served by the pod.
+type CertificateStatus struct {
+	SelfSigned bool         `json:"selfSigned,omitempty"`
+	NotFound   bool         `json:"notFound,omitempty"`
+	NotAfter   *metav1.Time `json:"notAfter,omitempty"`
+	Expired    bool         `json:"expired,omitempty"`
+
+	// Served is the chain served in a TLS handshake with the pod, the leaf first.
+	Served         []CertificateInfo `json:"served,omitempty"`
+	HandshakeError string            `json:"handshakeError,omitempty"`
+	// ServerName is the name the kube-apiserver verifies the served certificate for,
+	// <service>.<namespace>.svc.
+	ServerName string `json:"serverName,omitempty"`
+	// Verified is whether the served chain is verified by the caBundle of the APIService, unless
+	// VerifySkipped by insecureSkipTLSVerify.
+	Verified      bool   `json:"verified"`
+	VerifySkipped bool   `json:"verifySkipped,omitempty"`
+	VerifyError   string `json:"verifyError,omitempty"`
+	// MountedMismatch is whether the served certificate differs from the one mounted from the
+	// secret, e.g. if the secret was renewed but the pod not restarted.
+	MountedMismatch bool `json:"mountedMismatch,omitempty"`
+}
+
+type CertificateInfo struct {
+	Subject     string      `json:"subject"`
+	Issuer      string      `json:"issuer"`
+	DNSNames    []string    `json:"dnsNames,omitempty"`
+	IPAddresses []string    `json:"ipAddresses,omitempty"`
+	NotBefore   metav1.Time `json:"notBefore"`
+	NotAfter    metav1.Time `json:"notAfter"`
+	KeyType     string      `json:"keyType"`
+}
+
+// ObjectInventory is the objects of the aggregated resources shown by "show objects".
+type ObjectInventory struct {
+	Resources []ResourceInventory `json:"resources"`
+}
+
+type ResourceInventory struct {
+	// Resource is <resource>.<group>.
+	Resource   string `json:"resource"`
+	Version    string `json:"version"`
+	Namespaced bool   `json:"namespaced"`
+	Count      int    `json:"count"`
+	// TotalBytes is the total size of the objects as json.
+	TotalBytes int64                `json:"totalBytes"`
+	Largest    *ObjectRef           `json:"largest,omitempty"`
+	Oldest     *ObjectRef           `json:"oldest,omitempty"`
+	Newest     *ObjectRef           `json:"newest,omitempty"`
+	Namespaces []NamespaceInventory `json:"namespaces"`
+	// WithFinalizers is the number of objects with finalizers.
+	WithFinalizers int `json:"withFinalizers"`
+	// Terminating are the objects with a deletionTimestamp, held by their finalizers.
+	Terminating []TerminatingObject `json:"terminating,omitempty"`
+	// Error is the failure listing the objects, the summary being of the objects listed before.
+	Error string `json:"error,omitempty"`
+}
+
+type NamespaceInventory struct {
+	Namespace  string `json:"namespace"`
+	Count      int    `json:"count"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+type ObjectRef struct {
+	Namespace         string       `json:"namespace,omitempty"`
+	Name              string       `json:"name"`
+	Bytes             int64        `json:"bytes,omitempty"`
+	CreationTimestamp *metav1.Time `json:"creationTimestamp,omitempty"`
+}
+
+type TerminatingObject struct {
+	Namespace         string      `json:"namespace,omitempty"`
+	Name              string      `json:"name"`
+	DeletionTimestamp metav1.Time `json:"deletionTimestamp"`
+	Finalizers        []string    `json:"finalizers"`
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// RunHelperProgram renders a throwaway main package into the current project and
+// runs it with "go run". The helper is built inside the project's module so that it
+// can import the project's own packages, e.g. the generated openapi definitions or
+// the api groups for building a scheme.
+func RunHelperProgram(name, templateValue string, data interface{}, stdout io.Writer, args ...string) error {
+	dir, err := ioutil.TempDir(".", ".apiserver-boot-"+name+"-")
+	if err != nil {
+		return errors.Wrapf(err, "failed creating directory for helper %s", name)
+	}
+	defer os.RemoveAll(dir)
+
+	mainFile := filepath.Join(dir, "main.go")
+	Overwrite(mainFile, name, templateValue, data)
+
+	c := exec.Command("go", append([]string{"run", "-mod=mod", "./" + mainFile}, args...)...)
+	c.Stderr = os.Stderr
+	c.Stdout = stdout
+	klog.Infof("%s", strings.Join(c.Args, " "))
+	if err := c.Run(); err != nil {
+		return errors.Wrapf(err, "helper %s failed", name)
+	}
+	return nil
+}
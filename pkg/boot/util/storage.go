@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// The storage backends of the resources.  Etcd, mysql and sqlite are all served to the apiserver
+// through --etcd-servers, the latter two by an embedded kine, while filepath stores the resources
+// as json files without any etcd options.
+const (
+	StorageEtcd     = "etcd"
+	StorageMySQL    = "mysql"
+	StorageSQLite   = "sqlite"
+	StorageFilepath = "filepath"
+)
+
+var StorageBackends = []string{StorageEtcd, StorageMySQL, StorageSQLite, StorageFilepath}
+
+// Storage is the default storage backend of the project
+var Storage string
+
+// ValidateStorage exits if the storage backend isn't known.
+func ValidateStorage(storage string) {
+	for _, s := range StorageBackends {
+		if s == storage {
+			return
+		}
+	}
+	klog.Fatalf("--storage must be one of %s, got %q", strings.Join(StorageBackends, "|"), storage)
+}
+
+// IsKineStorage returns true if the storage backend is a SQL database served by kine.
+func IsKineStorage(storage string) bool {
+	return storage == StorageMySQL || storage == StorageSQLite
+}
+
+// GetStorage returns the default storage backend of the project from the "+storage" comment
+// in pkg/apis/doc.go, and etcd for the projects without it.
+func GetStorage() string {
+	b, err := ioutil.ReadFile(filepath.Join("pkg", "apis", "doc.go"))
+	if err != nil {
+		klog.Fatalf("Could not find pkg/apis/doc.go.  First run `apiserver-boot init --domain <domain>`.")
+	}
+	Storage = StorageEtcd
+	if l := regexp.MustCompile(`\+storage=(.*)`).FindSubmatch(b); len(l) > 1 {
+		Storage = strings.TrimSpace(string(l[1]))
+	}
+	ValidateStorage(Storage)
+	return Storage
+}
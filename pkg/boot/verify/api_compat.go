@@ -0,0 +1,364 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/util"
+)
+
+var base string
+
+var apiCompatCmd = &cobra.Command{
+	Use:   "api-compat",
+	Short: "Check the apis for changes breaking the clients.",
+	Long: `Check the apis for changes breaking the clients.
+
+The openapi schemas of every served group version are built from the generated pkg/openapi
+package and compared against the baseline, which is either a git ref of the project or an
+OpenAPI v2 spec written by "apiserver-boot build openapi".
+
+The following changes are reported:
+- removed resources and subresources
+- removed fields
+- changed field types
+- newly required fields
+- removed enum values
+
+The changes are errors in GA versions (e.g. v1), warnings in beta versions (e.g. v1beta1)
+and allowed in alpha versions (e.g. v1alpha1).  The command fails if any error is found.`,
+	Example: `# Compare the apis against the last release
+apiserver-boot verify api-compat --base v1.0.0
+
+# Compare the apis against the main branch
+apiserver-boot verify api-compat --base origin/main
+
+# Compare the apis against a published spec
+apiserver-boot verify api-compat --base docs/openapi-spec/swagger.json`,
+	Run: RunAPICompat,
+}
+
+func AddAPICompat(cmd *cobra.Command) {
+	cmd.AddCommand(apiCompatCmd)
+	apiCompatCmd.Flags().StringVar(&base, "base", "", "git ref or OpenAPI v2 spec file to compare the apis against")
+}
+
+func RunAPICompat(cmd *cobra.Command, args []string) {
+	if len(base) == 0 {
+		klog.Fatal("Must specify --base")
+	}
+
+	baseSpec, err := loadBaseSpec(base)
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	apis, err := build.LoadProjectAPIs()
+	if err != nil {
+		klog.Fatalf("error: %v", err)
+	}
+	currentSpec := build.BuildOpenAPISpec(apis, path.Base(util.GetRepo()))
+
+	findings := checkAPICompat(baseSpec, currentSpec)
+	errorCount := 0
+	for _, f := range findings {
+		if f.severity == severityError {
+			errorCount++
+		}
+		fmt.Println(f)
+	}
+	if errorCount > 0 {
+		fmt.Fprintf(os.Stderr, "found %d incompatible changes to GA apis since %s\n", errorCount, base)
+		os.Exit(1)
+	}
+	fmt.Printf("no incompatible changes to GA apis since %s\n", base)
+}
+
+// loadBaseSpec reads the base spec from a file, or builds it from a git ref checked out
+// into a temporary worktree.
+func loadBaseSpec(base string) (*spec.Swagger, error) {
+	if info, err := os.Stat(base); err == nil && !info.IsDir() {
+		return build.LoadOpenAPISpecFile(base)
+	}
+
+	if err := exec.Command("git", "rev-parse", "--verify", "--quiet", base+"^{commit}").Run(); err != nil {
+		return nil, fmt.Errorf("--base %s is neither a file nor a git ref", base)
+	}
+	// the worktree checks out the root of the repository, which may be a parent of the project
+	prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the directory of the project in the git repository: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "apiserver-boot-api-compat-")
+	if err != nil {
+		return nil, err
+	}
+	os.RemoveAll(dir)
+	// the module is read before leaving the working directory, as it's cached
+	title := path.Base(util.GetRepo())
+	if out, err := exec.Command("git", "worktree", "add", "--detach", dir, base).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed checking out %s: %v: %s", base, err, out)
+	}
+	defer func() {
+		if out, err := exec.Command("git", "worktree", "remove", "--force", dir).CombinedOutput(); err != nil {
+			klog.Errorf("failed removing the worktree %s: %v: %s", dir, err, out)
+		}
+	}()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(filepath.Join(dir, strings.TrimSpace(string(prefix)))); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(wd)
+	// the generated code is often ignored by git, e.g. by the .gitignore of "init repo"
+	if !build.HasGeneratedOpenAPI() {
+		klog.Infof("Running go generate at %s, as its generated openapi definitions aren't committed", base)
+		if out, err := exec.Command("go", "generate", "./...").CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed generating the code at %s: %v: %s\n%s", base, err, out, baseSpecHint(base))
+		}
+	}
+	apis, err := build.LoadProjectAPIs()
+	if err != nil {
+		return nil, fmt.Errorf("failed loading the apis at %s: %v\n%s", base, err, baseSpecHint(base))
+	}
+	return build.BuildOpenAPISpec(apis, title), nil
+}
+
+// baseSpecHint suggests comparing against a spec file when the apis of the git ref can't be loaded.
+func baseSpecHint(base string) string {
+	return fmt.Sprintf("Otherwise write the spec of %s by \"apiserver-boot build openapi --output-dir <dir>\" "+
+		"in a checkout of it, and pass --base <dir>/swagger.json instead", base)
+}
+
+const (
+	severityError   = "ERROR"
+	severityWarning = "WARNING"
+)
+
+type compatFinding struct {
+	severity string
+	resource string
+	message  string
+}
+
+func (f compatFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.severity, f.resource, f.message)
+}
+
+var versionMatch = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+// severityOf returns the severity of an incompatible change in the version, or an empty
+// string for the alpha versions which allow any change.
+func severityOf(version string) string {
+	m := versionMatch.FindStringSubmatch(version)
+	switch {
+	case m == nil, m[2] == "alpha":
+		return ""
+	case m[2] == "beta":
+		return severityWarning
+	}
+	return severityError
+}
+
+func checkAPICompat(baseSpec, currentSpec *spec.Swagger) []compatFinding {
+	current := map[string]build.APIResource{}
+	for _, r := range build.ResourcesFromOpenAPISpec(currentSpec) {
+		current[r.Group+"/"+r.Version+"/"+r.Resource] = r
+	}
+
+	var findings []compatFinding
+	for _, r := range build.ResourcesFromOpenAPISpec(baseSpec) {
+		severity := severityOf(r.Version)
+		if len(severity) == 0 {
+			continue
+		}
+		c := &compatChecker{
+			base:     baseSpec.Definitions,
+			current:  currentSpec.Definitions,
+			severity: severity,
+			resource: fmt.Sprintf("%s/%s, Kind=%s", r.Group, r.Version, r.Kind),
+			visited:  map[string]bool{},
+		}
+		cur, found := current[r.Group+"/"+r.Version+"/"+r.Resource]
+		if !found {
+			c.report("resource %s was removed", r.Resource)
+			findings = append(findings, c.findings...)
+			continue
+		}
+		for _, sub := range r.SubResources {
+			if !hasSubResource(cur, sub.Name) {
+				c.report("subresource %s/%s was removed", r.Resource, sub.Name)
+			}
+		}
+		c.compare(r.Kind, refSchema(r.Definition), refSchema(cur.Definition))
+		findings = append(findings, c.findings...)
+	}
+	return findings
+}
+
+func hasSubResource(r build.APIResource, name string) bool {
+	for _, s := range r.SubResources {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func refSchema(definition string) spec.Schema {
+	return *spec.RefSchema("#/definitions/" + definition)
+}
+
+// compatChecker walks the schemas of a resource in the base and in the current spec side by side.
+type compatChecker struct {
+	base, current spec.Definitions
+	severity      string
+	resource      string
+	visited       map[string]bool
+	findings      []compatFinding
+}
+
+func (c *compatChecker) report(format string, args ...interface{}) {
+	c.findings = append(c.findings, compatFinding{
+		severity: c.severity,
+		resource: c.resource,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func resolve(definitions spec.Definitions, s spec.Schema) (spec.Schema, string) {
+	name := ""
+	for len(s.Ref.String()) > 0 {
+		name = strings.TrimPrefix(s.Ref.String(), "#/definitions/")
+		def, found := definitions[name]
+		if !found {
+			return spec.Schema{}, name
+		}
+		s = def
+	}
+	return s, name
+}
+
+func typeOf(s spec.Schema) string {
+	switch {
+	case len(s.Type) == 0:
+		if len(s.Properties) > 0 {
+			return "object"
+		}
+		return ""
+	case s.Type[0] == "object" && s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil:
+		return "map"
+	case len(s.Format) > 0:
+		return s.Type[0] + "/" + s.Format
+	}
+	return s.Type[0]
+}
+
+func (c *compatChecker) compare(field string, base, current spec.Schema) {
+	base, baseName := resolve(c.base, base)
+	current, currentName := resolve(c.current, current)
+	if len(baseName) > 0 {
+		key := baseName + "|" + currentName
+		if c.visited[key] {
+			return
+		}
+		c.visited[key] = true
+	}
+
+	baseType, currentType := typeOf(base), typeOf(current)
+	if len(baseType) > 0 && baseType != currentType {
+		c.report("field %s changed type from %s to %s", field, baseType, currentType)
+		return
+	}
+
+	if len(current.Enum) > 0 {
+		if len(base.Enum) == 0 {
+			c.report("field %s was restricted to the enum values %s", field, enumValues(current.Enum))
+		} else if removed := missingEnumValues(base.Enum, current.Enum); len(removed) > 0 {
+			c.report("field %s removed the enum values %s", field, removed)
+		}
+	}
+
+	baseRequired := map[string]bool{}
+	for _, r := range base.Required {
+		baseRequired[r] = true
+	}
+	for _, r := range current.Required {
+		if !baseRequired[r] {
+			c.report("field %s.%s is newly required", field, r)
+		}
+	}
+
+	var names []string
+	for name := range base.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		currentProp, found := current.Properties[name]
+		if !found {
+			c.report("field %s.%s was removed", field, name)
+			continue
+		}
+		c.compare(field+"."+name, base.Properties[name], currentProp)
+	}
+
+	if base.Items != nil && base.Items.Schema != nil && current.Items != nil && current.Items.Schema != nil {
+		c.compare(field+"[]", *base.Items.Schema, *current.Items.Schema)
+	}
+	if base.AdditionalProperties != nil && base.AdditionalProperties.Schema != nil &&
+		current.AdditionalProperties != nil && current.AdditionalProperties.Schema != nil {
+		c.compare(field+"[*]", *base.AdditionalProperties.Schema, *current.AdditionalProperties.Schema)
+	}
+}
+
+func enumValues(values []interface{}) []string {
+	var result []string
+	for _, v := range values {
+		result = append(result, fmt.Sprint(v))
+	}
+	return result
+}
+
+func missingEnumValues(base, current []interface{}) []string {
+	found := map[string]bool{}
+	for _, v := range enumValues(current) {
+		found[v] = true
+	}
+	var missing []string
+	for _, v := range enumValues(base) {
+		if !found[v] {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/boot/build"
+)
+
+func TestSeverityOf(t *testing.T) {
+	tests := map[string]string{
+		"v1":       severityError,
+		"v2":       severityError,
+		"v1beta1":  severityWarning,
+		"v2beta3":  severityWarning,
+		"v1alpha1": "",
+		"v1alpha":  "",
+		"latest":   "",
+	}
+	for version, expected := range tests {
+		if severity := severityOf(version); severity != expected {
+			t.Errorf("%s: got %q, expected %q", version, severity, expected)
+		}
+	}
+}
+
+// barSpec is the schema of the spec of Bar, changed by the test cases.
+type barSpec struct {
+	replicasType string
+	modes        []interface{}
+	withName     bool
+	required     []string
+}
+
+var baseBarSpec = barSpec{
+	replicasType: "integer",
+	modes:        []interface{}{"Fast", "Slow"},
+	withName:     true,
+}
+
+// barAPIs returns the spec of the Bar resource in the version, with its status subresource if
+// withStatus.
+func barAPIs(version string, bar barSpec, withStatus bool) *spec.Swagger {
+	definition := "com.example.foo." + version + ".Bar"
+	barSpec := spec.Schema{}
+	barSpec.Type = []string{"object"}
+	barSpec.Required = bar.required
+	barSpec.Properties = map[string]spec.Schema{
+		"replicas": {SchemaProps: spec.SchemaProps{Type: []string{bar.replicasType}}},
+		"mode":     {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Enum: bar.modes}},
+	}
+	if bar.withName {
+		barSpec.Properties["name"] = *spec.StringProperty()
+	}
+	schema := spec.Schema{}
+	schema.Type = []string{"object"}
+	schema.Properties = map[string]spec.Schema{"spec": barSpec}
+	schema.Extensions = spec.Extensions{
+		"x-kubernetes-group-version-kind": []interface{}{
+			map[string]interface{}{"group": "foo.example.com", "version": version, "kind": "Bar"},
+		},
+	}
+
+	resource := build.APIResource{
+		Group:      "foo.example.com",
+		Version:    version,
+		Kind:       "Bar",
+		Resource:   "bars",
+		Definition: definition,
+	}
+	if withStatus {
+		resource.SubResources = []build.APISubResource{{Name: "status", Type: build.SubResourceStatus}}
+	}
+	return build.BuildOpenAPISpec(&build.ProjectAPIs{
+		Definitions: spec.Definitions{definition: schema},
+		Resources:   []build.APIResource{resource},
+	}, "test")
+}
+
+func TestCheckAPICompat(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		current  barSpec
+		noStatus bool
+		removed  bool
+		expected []string
+	}{
+		{
+			name:    "unchanged",
+			version: "v1",
+			current: baseBarSpec,
+		},
+		{
+			name:     "removed field",
+			version:  "v1",
+			current:  barSpec{replicasType: "integer", modes: []interface{}{"Fast", "Slow"}},
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: field Bar.spec.name was removed"},
+		},
+		{
+			name:     "changed type",
+			version:  "v1",
+			current:  barSpec{replicasType: "string", modes: []interface{}{"Fast", "Slow"}, withName: true},
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: field Bar.spec.replicas changed type from integer to string"},
+		},
+		{
+			name:     "newly required field",
+			version:  "v1",
+			current:  barSpec{replicasType: "integer", modes: []interface{}{"Fast", "Slow"}, withName: true, required: []string{"name"}},
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: field Bar.spec.name is newly required"},
+		},
+		{
+			name:     "removed enum value",
+			version:  "v1",
+			current:  barSpec{replicasType: "integer", modes: []interface{}{"Fast"}, withName: true},
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: field Bar.spec.mode removed the enum values [Slow]"},
+		},
+		{
+			name:    "added enum value",
+			version: "v1",
+			current: barSpec{replicasType: "integer", modes: []interface{}{"Fast", "Slow", "Paused"}, withName: true},
+		},
+		{
+			name:     "removed subresource",
+			version:  "v1",
+			current:  baseBarSpec,
+			noStatus: true,
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: subresource bars/status was removed"},
+		},
+		{
+			name:     "removed resource",
+			version:  "v1",
+			removed:  true,
+			expected: []string{"ERROR: foo.example.com/v1, Kind=Bar: resource bars was removed"},
+		},
+		{
+			name:     "beta version warns",
+			version:  "v1beta1",
+			current:  barSpec{replicasType: "integer", modes: []interface{}{"Fast", "Slow"}},
+			expected: []string{"WARNING: foo.example.com/v1beta1, Kind=Bar: field Bar.spec.name was removed"},
+		},
+		{
+			name:    "alpha version allows any change",
+			version: "v1alpha1",
+			current: barSpec{replicasType: "string"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseSpec := barAPIs(test.version, baseBarSpec, true)
+			currentSpec := barAPIs(test.version, test.current, !test.noStatus)
+			if test.removed {
+				currentSpec = build.BuildOpenAPISpec(&build.ProjectAPIs{Definitions: spec.Definitions{}}, "test")
+			}
+			var findings []string
+			for _, f := range checkAPICompat(baseSpec, currentSpec) {
+				findings = append(findings, f.String())
+			}
+			if !reflect.DeepEqual(findings, test.expected) {
+				t.Errorf("got %q, expected %q", findings, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/markbates/inflect"
+)
+
+// declaredResource is a resource declared by the types of pkg/apis, read statically from the
+// methods the apiserver-runtime builder calls on the types.
+type declaredResource struct {
+	group    string
+	version  string
+	resource string
+	kind     string
+	// file is where the type is declared.
+	file string
+	// namespaced is nil if NamespaceScoped() doesn't return a constant.
+	namespaced *bool
+	// subResources are the kinds of the subresources by name: status, scale, arbitrary or
+	// connector.
+	subResources map[string]string
+	shortNames   []string
+}
+
+// loadDeclaredResources scans the packages of dir with *_types.go files for the resource types,
+// the types with a GetGroupVersionResource() method.
+func loadDeclaredResources(dir string) ([]declaredResource, error) {
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), "_types.go") {
+			if len(dirs) == 0 || dirs[len(dirs)-1] != filepath.Dir(path) {
+				dirs = append(dirs, filepath.Dir(path))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []declaredResource
+	for _, d := range dirs {
+		r, err := loadPackageResources(d)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, r...)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].group+"/"+resources[i].version+"/"+resources[i].resource <
+			resources[j].group+"/"+resources[j].version+"/"+resources[j].resource
+	})
+	return resources, nil
+}
+
+// typeDecls are the types of a package with their methods.
+type typeDecls struct {
+	fset    *token.FileSet
+	files   map[string]string
+	methods map[string]map[string]*ast.FuncDecl
+	// groupName is the +groupName of the package doc.
+	groupName string
+}
+
+func loadPackageResources(dir string) ([]declaredResource, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []declaredResource
+	for _, pkg := range pkgs {
+		decls := &typeDecls{
+			fset:    fset,
+			files:   map[string]string{},
+			methods: map[string]map[string]*ast.FuncDecl{},
+		}
+		for filename, f := range pkg.Files {
+			for _, c := range f.Comments {
+				for _, line := range strings.Split(c.Text(), "\n") {
+					if strings.HasPrefix(line, "+groupName=") {
+						decls.groupName = strings.TrimPrefix(line, "+groupName=")
+					}
+				}
+			}
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						if t, ok := spec.(*ast.TypeSpec); ok {
+							decls.files[t.Name.Name] = filename
+						}
+					}
+				case *ast.FuncDecl:
+					if d.Recv == nil || d.Body == nil {
+						continue
+					}
+					recv := receiverType(d.Recv)
+					if decls.methods[recv] == nil {
+						decls.methods[recv] = map[string]*ast.FuncDecl{}
+					}
+					decls.methods[recv][d.Name.Name] = d
+				}
+			}
+		}
+
+		for name, methods := range decls.methods {
+			if _, ok := methods["GetGroupVersionResource"]; !ok {
+				continue
+			}
+			resources = append(resources, decls.resource(name, filepath.Base(dir)))
+		}
+	}
+	return resources, nil
+}
+
+func (decls *typeDecls) resource(name, dirVersion string) declaredResource {
+	methods := decls.methods[name]
+	r := declaredResource{
+		group:        decls.groupName,
+		version:      dirVersion,
+		resource:     inflect.NewDefaultRuleset().Pluralize(strings.ToLower(name)),
+		kind:         name,
+		file:         decls.files[name],
+		subResources: map[string]string{},
+	}
+	if lit := returnedCompositeLit(methods["GetGroupVersionResource"]); lit != nil {
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, _ := kv.Key.(*ast.Ident)
+			value, ok := stringLit(kv.Value)
+			if key == nil || !ok {
+				continue
+			}
+			switch key.Name {
+			case "Group":
+				r.group = value
+			case "Version":
+				r.version = value
+			case "Resource":
+				r.resource = value
+			}
+		}
+	}
+	if ident := returnedIdent(methods["NamespaceScoped"]); ident != nil && (ident.Name == "true" || ident.Name == "false") {
+		namespaced := ident.Name == "true"
+		r.namespaced = &namespaced
+	}
+	if _, ok := methods["GetStatus"]; ok {
+		// apiserver-runtime registers the status of any resource as <resource>/status
+		r.subResources["status"] = "status"
+	}
+	if _, ok := methods["GetScale"]; ok {
+		r.subResources["scale"] = "scale"
+	}
+	if lit := returnedCompositeLit(methods["GetArbitrarySubResources"]); lit != nil {
+		for _, elt := range lit.Elts {
+			subType := compositeLitType(elt)
+			sub, ok := stringLit(returnedExpr(decls.methods[subType]["SubResourceName"]))
+			if !ok {
+				continue
+			}
+			if _, ok := decls.methods[subType]["Connect"]; ok {
+				r.subResources[sub] = "connector"
+			} else {
+				r.subResources[sub] = "arbitrary"
+			}
+		}
+	}
+	if lit := returnedCompositeLit(methods["ShortNames"]); lit != nil {
+		for _, elt := range lit.Elts {
+			if s, ok := stringLit(elt); ok {
+				r.shortNames = append(r.shortNames, s)
+			}
+		}
+	}
+	return r
+}
+
+// returnedExpr returns the expression returned by a method with a single return statement.
+func returnedExpr(d *ast.FuncDecl) ast.Expr {
+	if d == nil || len(d.Body.List) != 1 {
+		return nil
+	}
+	ret, ok := d.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil
+	}
+	return ret.Results[0]
+}
+
+func returnedIdent(d *ast.FuncDecl) *ast.Ident {
+	ident, _ := returnedExpr(d).(*ast.Ident)
+	return ident
+}
+
+func returnedCompositeLit(d *ast.FuncDecl) *ast.CompositeLit {
+	e := returnedExpr(d)
+	if u, ok := e.(*ast.UnaryExpr); ok {
+		e = u.X
+	}
+	lit, _ := e.(*ast.CompositeLit)
+	return lit
+}
+
+// compositeLitType returns the type name of T{} or &T{}.
+func compositeLitType(e ast.Expr) string {
+	if u, ok := e.(*ast.UnaryExpr); ok {
+		e = u.X
+	}
+	if lit, ok := e.(*ast.CompositeLit); ok {
+		if ident, ok := lit.Type.(*ast.Ident); ok {
+			return ident.Name
+		}
+	}
+	return ""
+}
+
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+func receiverType(recv *ast.FieldList) string {
+	t := recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if ident, ok := t.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
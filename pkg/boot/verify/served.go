@@ -0,0 +1,259 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-builder-alpha/pkg/utils"
+)
+
+var servedPod string
+var servedPort int32
+var servedKubeConfigFlags = genericclioptions.NewConfigFlags(false)
+
+var servedCmd = &cobra.Command{
+	Use:   "served",
+	Short: "Check the running apiserver serves the apis declared by the project.",
+	Long: `Check the running apiserver serves the apis declared by the project.
+
+The resource types of pkg/apis are scanned statically for their group version resource, scope,
+status, scale, arbitrary and connector subresources and short names, and compared with the
+discovery of the running apiserver, either the server of the kubeconfig or a pod of the
+aggregated apiserver reached by a port-forward with --pod.
+
+The following differences are reported:
+- resources, subresources and short names declared but not served, e.g. a resource missing its
+  WithResource in cmd/apiserver/main.go, or a subresource missing from GetArbitrarySubResources
+- resources, subresources and short names served but not declared, e.g. by a stale image
+- resources served with another scope than NamespaceScoped() declares
+
+The command fails if any difference is found.`,
+	Example: `# Check the apiserver run by "apiserver-boot run local"
+apiserver-boot verify served --kubeconfig kubeconfig
+
+# Check the apiserver aggregated into the cluster
+apiserver-boot verify served
+
+# Check a pod of the aggregated apiserver serving on 443
+apiserver-boot verify served --pod default/foo-apiserver-6d8b9c7f5-x2x4z`,
+	Run: RunVerifyServed,
+}
+
+func AddVerifyServed(cmd *cobra.Command) {
+	cmd.AddCommand(servedCmd)
+	servedKubeConfigFlags.AddFlags(servedCmd.Flags())
+	servedCmd.Flags().StringVar(&servedPod, "pod", "",
+		"<namespace>/<name> of a pod of the aggregated apiserver to check through a port-forward, instead of the server of the kubeconfig")
+	servedCmd.Flags().Int32Var(&servedPort, "port", 443, "The serving port of the pod of --pod")
+}
+
+func RunVerifyServed(cmd *cobra.Command, args []string) {
+	if len(servedPod) > 0 && len(strings.Split(servedPod, "/")) != 2 {
+		klog.Fatal("--pod must be <namespace>/<name>")
+	}
+	declared, err := loadDeclaredResources("pkg/apis")
+	if err != nil {
+		klog.Fatalf("failed scanning pkg/apis: %v", err)
+	}
+	if len(declared) == 0 {
+		klog.Fatal("no resource types found in pkg/apis, run the command from the root of the project")
+	}
+
+	restConfig, err := servedKubeConfigFlags.ToRESTConfig()
+	if err != nil {
+		klog.Fatalf("failed building kube client config: %v", err)
+	}
+	if len(servedPod) > 0 {
+		parts := strings.Split(servedPod, "/")
+		proxy := utils.NewEphemeralLocalProxy(restConfig, parts[0], parts[1], servedPort)
+		stopFunc, err := proxy.Listen()
+		if err != nil {
+			klog.Fatalf("failed running local proxy to port %v: %v", servedPort, err)
+		}
+		defer stopFunc()
+		restConfig = rest.CopyConfig(restConfig)
+		restConfig.Insecure = true
+		restConfig.CAData = nil
+		restConfig.CAFile = ""
+		restConfig.Host = proxy.Addr()
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		klog.Fatalf("failed building discovery client: %v", err)
+	}
+
+	served, err := loadServedResources(discoveryClient, declared)
+	if err != nil {
+		klog.Fatalf("failed api-discovery of %s: %v", restConfig.Host, err)
+	}
+	findings := checkServed(declared, served)
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if len(findings) > 0 {
+		fmt.Fprintf(os.Stderr, "found %d differences between pkg/apis and the apis served by %s\n", len(findings), restConfig.Host)
+		os.Exit(1)
+	}
+	fmt.Printf("the apis served by %s match pkg/apis\n", restConfig.Host)
+}
+
+// loadServedResources returns the resources served in all the versions of the declared groups,
+// by group version.
+func loadServedResources(discoveryClient discovery.DiscoveryInterface, declared []declaredResource) (map[string]*metav1.APIResourceList, error) {
+	groups := sets.NewString()
+	for _, r := range declared {
+		groups.Insert(r.group)
+	}
+	serverGroups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	// the discovery client takes a forbidden /apis for an empty list
+	if len(serverGroups.Groups) == 0 {
+		return nil, errors.New("no api group discovered, check the user is allowed to get the discovery paths")
+	}
+	served := map[string]*metav1.APIResourceList{}
+	for _, group := range serverGroups.Groups {
+		if !groups.Has(group.Name) {
+			continue
+		}
+		for _, version := range group.Versions {
+			resourceList, err := discoveryClient.ServerResourcesForGroupVersion(version.GroupVersion)
+			if err != nil {
+				return nil, err
+			}
+			served[version.GroupVersion] = resourceList
+		}
+	}
+	return served, nil
+}
+
+const (
+	findingNotServed  = "NOT SERVED"
+	findingUndeclared = "UNDECLARED"
+	findingMismatch   = "MISMATCH"
+)
+
+type servedFinding struct {
+	kind     string
+	resource string
+	message  string
+}
+
+func (f servedFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.kind, f.resource, f.message)
+}
+
+func checkServed(declared []declaredResource, served map[string]*metav1.APIResourceList) []servedFinding {
+	var findings []servedFinding
+	declaredNames := sets.NewString()
+	for _, r := range declared {
+		gv := r.group + "/" + r.version
+		declaredNames.Insert(gv + "/" + r.resource)
+		report := func(kind, format string, a ...interface{}) {
+			findings = append(findings, servedFinding{
+				kind:     kind,
+				resource: fmt.Sprintf("%s, Resource=%s", gv, r.resource),
+				message:  fmt.Sprintf(format, a...),
+			})
+		}
+
+		resourceList, ok := served[gv]
+		if !ok {
+			report(findingNotServed, "group version %s of %s in %s isn't served, check the WithResource of %s in cmd/apiserver/main.go",
+				gv, r.kind, r.file, r.kind)
+			continue
+		}
+		var resource *metav1.APIResource
+		servedSubResources := sets.NewString()
+		for i, apiResource := range resourceList.APIResources {
+			parts := strings.SplitN(apiResource.Name, "/", 2)
+			if parts[0] != r.resource {
+				continue
+			}
+			if len(parts) == 1 {
+				resource = &resourceList.APIResources[i]
+			} else {
+				servedSubResources.Insert(parts[1])
+			}
+		}
+		if resource == nil {
+			report(findingNotServed, "%s declared in %s isn't served, check the WithResource of %s in cmd/apiserver/main.go",
+				r.kind, r.file, r.kind)
+			continue
+		}
+
+		if r.namespaced != nil && *r.namespaced != resource.Namespaced {
+			report(findingMismatch, "NamespaceScoped() of %s returns %v but the resource is served with namespaced=%v",
+				r.kind, *r.namespaced, resource.Namespaced)
+		}
+		declaredSubResources := sets.StringKeySet(r.subResources)
+		for _, sub := range declaredSubResources.Difference(servedSubResources).List() {
+			report(findingNotServed, "%s subresource %s isn't served%s", r.subResources[sub], sub, subResourceHint(r.subResources[sub]))
+		}
+		for _, sub := range servedSubResources.Difference(declaredSubResources).List() {
+			report(findingUndeclared, "subresource %s is served but not declared by %s", sub, r.kind)
+		}
+		declaredShortNames := sets.NewString(r.shortNames...)
+		servedShortNames := sets.NewString(resource.ShortNames...)
+		for _, s := range declaredShortNames.Difference(servedShortNames).List() {
+			report(findingNotServed, "short name %s isn't served", s)
+		}
+		for _, s := range servedShortNames.Difference(declaredShortNames).List() {
+			report(findingUndeclared, "short name %s is served but not returned by ShortNames() of %s", s, r.kind)
+		}
+	}
+
+	var gvs []string
+	for gv := range served {
+		gvs = append(gvs, gv)
+	}
+	sort.Strings(gvs)
+	for _, gv := range gvs {
+		for _, apiResource := range served[gv].APIResources {
+			if strings.Contains(apiResource.Name, "/") || declaredNames.Has(gv+"/"+apiResource.Name) {
+				continue
+			}
+			findings = append(findings, servedFinding{
+				kind:     findingUndeclared,
+				resource: fmt.Sprintf("%s, Resource=%s", gv, apiResource.Name),
+				message:  fmt.Sprintf("%s is served but not declared in pkg/apis", apiResource.Kind),
+			})
+		}
+	}
+	return findings
+}
+
+func subResourceHint(kind string) string {
+	switch kind {
+	case "arbitrary", "connector":
+		return ", check GetArbitrarySubResources() returns it"
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verify
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Command group for verifying the apis of the project.",
+	Long:  `Command group for verifying the apis of the project.`,
+	Example: `# Check the apis for changes breaking the clients since the last release
+apiserver-boot verify api-compat --base v1.0.0
+
+# Check the running apiserver serves the apis declared by the project
+apiserver-boot verify served --kubeconfig kubeconfig`,
+	Run: RunVerify,
+}
+
+func AddVerify(cmd *cobra.Command) {
+	cmd.AddCommand(verifyCmd)
+	AddAPICompat(verifyCmd)
+	AddVerifyServed(verifyCmd)
+}
+
+func RunVerify(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memory provides an in-memory storage for the aggregated resources, so that the apiserver
+// can be run by unit and integration tests without etcd or any other external store.
+package memory
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	pkgserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource/resourcerest"
+	builderrest "sigs.k8s.io/apiserver-runtime/pkg/builder/rest"
+)
+
+// WithMemoryStorage stores all the resources of the apiserver in memory rather than in etcd, and
+// enables the field selectors on the fields of the resourcerest.FieldsIndexer resources.  If
+// snapshotDir isn't empty, each resource is also persisted to the "<group>/<resource>.json" file
+// under it, and loaded from it when the apiserver starts.
+//
+// A test can serve its resources with:
+//
+//	cmd, err := memory.WithMemoryStorage(builder.APIServer.WithResource(&v1.Foo{}), "").Build()
+func WithMemoryStorage(server *builder.Server, snapshotDir string) *builder.Server {
+	getter := newRESTOptionsGetter(func(gr schema.GroupResource) string {
+		if len(snapshotDir) == 0 {
+			return ""
+		}
+		return filepath.Join(snapshotDir, gr.Group, gr.Resource+".json")
+	})
+	return WithFieldsIndexers(server).
+		WithoutEtcd().
+		WithConfigFns(func(config *pkgserver.RecommendedConfig) *pkgserver.RecommendedConfig {
+			config.RESTOptionsGetter = getter
+			return config
+		})
+}
+
+// NewMemoryStorageProvider stores a resource in memory, while the other resources of the apiserver
+// are still stored in etcd.  If snapshotFile isn't empty, the resource is also persisted to it.
+// An example of storing a resource in memory will be:
+//
+//	builder.APIServer.
+//	  WithResourceAndStorage(&v1alpha1.ExampleResource{}, memory.NewMemoryStorageProvider("")).
+//	  Build()
+func NewMemoryStorageProvider(snapshotFile string) builderrest.StoreFn {
+	getter := newRESTOptionsGetter(func(schema.GroupResource) string {
+		return snapshotFile
+	})
+	return func(s *genericregistry.Store, options *generic.StoreOptions) {
+		options.RESTOptions = getter
+	}
+}
+
+// WithFieldsIndexers allows the field selectors on the fields indexed by the resources implementing
+// resourcerest.FieldsIndexer, which are otherwise rejected as only metadata.name and metadata.namespace
+// are supported by default.  The fields are matched by the in-memory storage.
+func WithFieldsIndexers(server *builder.Server) *builder.Server {
+	var schemes []*runtime.Scheme
+	return server.
+		WithAdditionalSchemeInstallers(func(scheme *runtime.Scheme) error {
+			schemes = append(schemes, scheme)
+			return nil
+		}).
+		// the conversions are added once all the resources are registered with the schemes
+		WithServerFns(func(s *builder.GenericAPIServer) *builder.GenericAPIServer {
+			for _, scheme := range schemes {
+				// the field selectors of the resources are rejected as without the conversions
+				if err := addFieldLabelConversions(scheme); err != nil {
+					klog.Errorf("Failed adding the field selectors of the indexed fields: %v", err)
+				}
+			}
+			return s
+		})
+}
+
+func addFieldLabelConversions(scheme *runtime.Scheme) error {
+	indexerType := reflect.TypeOf((*resourcerest.FieldsIndexer)(nil)).Elem()
+	for gvk, t := range scheme.AllKnownTypes() {
+		if !reflect.PtrTo(t).Implements(indexerType) {
+			continue
+		}
+		indexer := reflect.New(t).Interface().(resourcerest.FieldsIndexer)
+		indexed := map[string]bool{}
+		for _, f := range indexer.IndexingFields() {
+			indexed[f] = true
+		}
+		kind := gvk.Kind
+		err := scheme.AddFieldLabelConversionFunc(gvk, func(label, value string) (string, string, error) {
+			if indexed[label] {
+				return label, value, nil
+			}
+			return runtime.DefaultMetaV1FieldSelectorConversion(label, value)
+		})
+		if err != nil {
+			return fmt.Errorf("failed adding the field selectors of %s: %v", kind, err)
+		}
+	}
+	return nil
+}
+
+// withIndexedFields adds the fields indexed by the resourcerest.FieldsIndexer objects to their
+// selectable fields.
+func withIndexedFields(obj runtime.Object, fs fields.Set) fields.Set {
+	indexer, ok := obj.(resourcerest.FieldsIndexer)
+	if !ok {
+		return fs
+	}
+	merged := fields.Set{}
+	for k, v := range fs {
+		merged[k] = v
+	}
+	for _, f := range indexer.IndexingFields() {
+		merged[f] = indexer.GetField(f)
+	}
+	return merged
+}
+
+// restOptionsGetter returns the options of the resources creating their in-memory storage, in place
+// of the etcd options.  The stores are kept by the getter, so that the objects of each apiserver
+// built, e.g. by each test, are separate.
+type restOptionsGetter struct {
+	snapshotFile func(schema.GroupResource) string
+
+	lock sync.Mutex
+	// stores are shared by all the versions of a resource, the same as their etcd prefix
+	stores map[string]*store
+}
+
+func newRESTOptionsGetter(snapshotFile func(schema.GroupResource) string) *restOptionsGetter {
+	return &restOptionsGetter{
+		snapshotFile: snapshotFile,
+		stores:       map[string]*store{},
+	}
+}
+
+// getStore returns the store of the prefix, created on the first call.
+func (g *restOptionsGetter) getStore(prefix, snapshotFile string, newFunc func() runtime.Object, getAttrs storage.AttrFunc) (*store, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s, found := g.stores[prefix]; found {
+		return s, nil
+	}
+	s, err := newStore(snapshotFile, newFunc, getAttrs)
+	if err != nil {
+		return nil, err
+	}
+	g.stores[prefix] = s
+	return s, nil
+}
+
+// GetRESTOptions implements RESTOptionsGetter interface.
+func (g *restOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	snapshotFile := g.snapshotFile(resource)
+	return generic.RESTOptions{
+		StorageConfig: &storagebackend.ConfigForResource{
+			Config: storagebackend.Config{
+				// only used by the dry-run requests to copy the objects
+				Codec: jsonCodec{},
+			},
+			GroupResource: resource,
+		},
+		Decorator: func(
+			config *storagebackend.ConfigForResource,
+			resourcePrefix string,
+			keyFunc func(obj runtime.Object) (string, error),
+			newFunc func() runtime.Object,
+			newListFunc func() runtime.Object,
+			getAttrsFunc storage.AttrFunc,
+			trigger storage.IndexerFuncs,
+			indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+			s, err := g.getStore(resourcePrefix, snapshotFile, newFunc, getAttrsFunc)
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, func() {}, nil
+		},
+		DeleteCollectionWorkers: 1,
+		ResourcePrefix:          resource.Group + "/" + resource.Resource,
+	}, nil
+}
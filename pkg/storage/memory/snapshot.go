@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// snapshot is the content of the snapshot file of a resource.
+type snapshot struct {
+	ResourceVersion uint64                     `json:"resourceVersion"`
+	Objects         map[string]json.RawMessage `json:"objects"`
+}
+
+// load restores the objects and the resourceVersion from the snapshot file, if any.
+func (s *store) load() error {
+	if len(s.snapshotFile) == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.snapshotFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading snapshot %s: %v", s.snapshotFile, err)
+	}
+
+	snap := &snapshot{}
+	if err := json.Unmarshal(b, snap); err != nil {
+		return fmt.Errorf("failed decoding snapshot %s: %v", s.snapshotFile, err)
+	}
+	for key, data := range snap.Objects {
+		obj := s.newFunc()
+		if err := json.Unmarshal(data, obj); err != nil {
+			return fmt.Errorf("failed decoding %s from snapshot %s: %v", key, s.snapshotFile, err)
+		}
+		s.set(key, obj)
+	}
+	s.rev = snap.ResourceVersion
+	return nil
+}
+
+// save writes the objects and the resourceVersion to the snapshot file, if any.  It must be
+// called with the lock held.
+func (s *store) save(rev uint64) error {
+	if len(s.snapshotFile) == 0 {
+		return nil
+	}
+	snap := &snapshot{
+		ResourceVersion: rev,
+		Objects:         map[string]json.RawMessage{},
+	}
+	for key, obj := range s.objects {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed encoding %s: %v", key, err)
+		}
+		snap.Objects[key] = data
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// replaces the snapshot at once so that it is never partially written
+	if err := os.MkdirAll(filepath.Dir(s.snapshotFile), 0700); err != nil {
+		return fmt.Errorf("failed creating the directory of snapshot %s: %v", s.snapshotFile, err)
+	}
+	tmp := s.snapshotFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed writing snapshot %s: %v", s.snapshotFile, err)
+	}
+	return os.Rename(tmp, s.snapshotFile)
+}
+
+// jsonCodec encodes the objects as json, which is enough to copy the objects of a resource as
+// the in-memory storage keeps them decoded.
+type jsonCodec struct{}
+
+var _ runtime.Codec = jsonCodec{}
+
+// Encode implements runtime.Encoder.
+func (jsonCodec) Encode(obj runtime.Object, w io.Writer) error {
+	return json.NewEncoder(w).Encode(obj)
+}
+
+// Identifier implements runtime.Encoder.
+func (jsonCodec) Identifier() runtime.Identifier {
+	return "memory-json"
+}
+
+// Decode implements runtime.Decoder.
+func (jsonCodec) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	if into == nil {
+		return nil, nil, fmt.Errorf("the object to decode into must be set")
+	}
+	if err := runtime.SetZeroValue(into); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, into); err != nil {
+		return nil, nil, err
+	}
+	gvk := into.GetObjectKind().GroupVersionKind()
+	return into, &gvk, nil
+}
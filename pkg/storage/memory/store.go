@@ -0,0 +1,555 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/etcd3"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource/resourcerest"
+)
+
+// eventsHistorySize is the number of the latest events kept for the watches resuming from a
+// resourceVersion, older resourceVersions are expired as after an etcd compaction.
+const eventsHistorySize = 1000
+
+var _ storage.Interface = &store{}
+
+// store keeps the objects of a resource in a map by their etcd key.  The objects are never
+// modified once stored, the writes replace them and the reads return copies.
+type store struct {
+	lock      sync.RWMutex
+	versioner storage.Versioner
+	newFunc   func() runtime.Object
+	getAttrs  storage.AttrFunc
+
+	objects map[string]runtime.Object
+	rev     uint64
+	// indexes maps the fields indexed by the objects to their values and the keys of the objects
+	indexes       map[string]map[string]sets.String
+	indexedFields []string
+	events        []event
+
+	watchers      map[int]*watcher
+	nextWatcherID int
+
+	snapshotFile string
+}
+
+// event is a change of the object of a key, the object is nil for the deletions.
+type event struct {
+	rev     uint64
+	key     string
+	obj     runtime.Object
+	prevObj runtime.Object
+}
+
+// newStore returns the store of a resource, with the objects of the snapshot file if any.
+func newStore(snapshotFile string, newFunc func() runtime.Object, getAttrs storage.AttrFunc) (*store, error) {
+	s := &store{
+		versioner: etcd3.APIObjectVersioner{},
+		newFunc:   newFunc,
+		getAttrs:  getAttrs,
+		objects:   map[string]runtime.Object{},
+		// the resourceVersions start from 1 as with etcd, 0 means any resourceVersion
+		rev:          1,
+		indexes:      map[string]map[string]sets.String{},
+		watchers:     map[int]*watcher{},
+		snapshotFile: snapshotFile,
+	}
+	if indexer, ok := newFunc().(resourcerest.FieldsIndexer); ok {
+		s.indexedFields = indexer.IndexingFields()
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Versioner implements storage.Interface.
+func (s *store) Versioner() storage.Versioner {
+	return s.versioner
+}
+
+// Create implements storage.Interface.
+func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	if version, err := s.versioner.ObjectResourceVersion(obj); err == nil && version != 0 {
+		return errors.New("resourceVersion should not be set on objects to be created")
+	}
+	if err := s.versioner.PrepareObjectForStorage(obj); err != nil {
+		return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, found := s.objects[key]; found {
+		return storage.NewKeyExistsError(key, 0)
+	}
+	stored, err := s.write(key, obj)
+	if err != nil {
+		return err
+	}
+	return setObject(out, stored)
+}
+
+// Delete implements storage.Interface.
+func (s *store) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	for {
+		s.lock.RLock()
+		cur, found := s.objects[key]
+		s.lock.RUnlock()
+		if !found {
+			return storage.NewKeyNotFoundError(key, 0)
+		}
+
+		// the preconditions and the validation are checked without the lock, as the admission
+		// may call the apiserver, the deletion is retried if the object changed meanwhile
+		if preconditions != nil {
+			if err := preconditions.Check(key, cur.DeepCopyObject()); err != nil {
+				return err
+			}
+		}
+		if err := validateDeletion(ctx, cur.DeepCopyObject()); err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		if s.objects[key] != cur {
+			s.lock.Unlock()
+			continue
+		}
+		_, err := s.write(key, nil)
+		s.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		return setObject(out, cur)
+	}
+}
+
+// Watch implements storage.Interface.
+func (s *store) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.watch(ctx, key, opts, false)
+}
+
+// WatchList implements storage.Interface.
+func (s *store) WatchList(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	return s.watch(ctx, key, opts, true)
+}
+
+// Get implements storage.Interface.
+func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	s.lock.RLock()
+	obj, found := s.objects[key]
+	s.lock.RUnlock()
+	if !found {
+		if opts.IgnoreNotFound {
+			return runtime.SetZeroValue(objPtr)
+		}
+		return storage.NewKeyNotFoundError(key, 0)
+	}
+	return setObject(objPtr, obj)
+}
+
+// GetToList implements storage.Interface.
+func (s *store) GetToList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	return s.list(key, false, opts.Predicate, listObj)
+}
+
+// List implements storage.Interface.  The lists are paginated by the limit and the continue
+// token of the requests, but unlike etcd the next pages are read from the current objects rather
+// than at the resourceVersion of the first page.  The resourceVersion of the first page is still
+// returned with them, so that a watch from it replays the changes made meanwhile.
+func (s *store) List(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	if len(opts.Predicate.Continue) > 0 && len(opts.ResourceVersion) > 0 && opts.ResourceVersion != "0" {
+		return apierrors.NewBadRequest("specifying resource version is not allowed when using continue")
+	}
+	return s.list(key, true, opts.Predicate, listObj)
+}
+
+// GuaranteedUpdate implements storage.Interface.
+func (s *store) GuaranteedUpdate(
+	ctx context.Context, key string, ptrToType runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	v, err := conversion.EnforcePtr(ptrToType)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+
+	for {
+		s.lock.RLock()
+		cur, found := s.objects[key]
+		s.lock.RUnlock()
+
+		var origObj runtime.Object
+		var origRev uint64
+		switch {
+		case found:
+			origObj = cur.DeepCopyObject()
+			if origRev, err = s.versioner.ObjectResourceVersion(cur); err != nil {
+				return err
+			}
+		case ignoreNotFound:
+			origObj = reflect.New(v.Type()).Interface().(runtime.Object)
+		default:
+			return storage.NewKeyNotFoundError(key, 0)
+		}
+		if preconditions != nil {
+			if err := preconditions.Check(key, origObj); err != nil {
+				return err
+			}
+		}
+
+		// the update is computed without the lock, as the admission may call the apiserver, and
+		// retried if the object changed meanwhile
+		ret, _, err := tryUpdate(origObj, storage.ResponseMeta{ResourceVersion: origRev})
+		if err != nil {
+			return err
+		}
+		if err := s.versioner.PrepareObjectForStorage(ret); err != nil {
+			return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+		}
+		if found {
+			unchanged := cur.DeepCopyObject()
+			if err := s.versioner.PrepareObjectForStorage(unchanged); err != nil {
+				return err
+			}
+			if equality.Semantic.DeepEqual(ret, unchanged) {
+				return setObject(ptrToType, cur)
+			}
+		}
+
+		s.lock.Lock()
+		if s.objects[key] != cur {
+			s.lock.Unlock()
+			continue
+		}
+		stored, err := s.write(key, ret)
+		s.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		return setObject(ptrToType, stored)
+	}
+}
+
+// Count implements storage.Interface.
+func (s *store) Count(key string) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return int64(len(s.keys(key, true))), nil
+}
+
+// write stores the object of the key, or deletes it if the object is nil, with a new resourceVersion
+// and notifies the watchers.  It must be called with the lock held.
+func (s *store) write(key string, obj runtime.Object) (runtime.Object, error) {
+	rev := s.rev + 1
+	var stored runtime.Object
+	if obj != nil {
+		stored = obj.DeepCopyObject()
+		if err := s.versioner.UpdateObject(stored, rev); err != nil {
+			return nil, err
+		}
+	}
+
+	prevObj, found := s.objects[key]
+	s.set(key, stored)
+	if err := s.save(rev); err != nil {
+		if found {
+			s.set(key, prevObj)
+		} else {
+			s.set(key, nil)
+		}
+		return nil, err
+	}
+	s.rev = rev
+
+	e := event{rev: rev, key: key, obj: stored, prevObj: prevObj}
+	s.events = append(s.events, e)
+	if len(s.events) > eventsHistorySize {
+		s.events = s.events[len(s.events)-eventsHistorySize:]
+	}
+	for id, w := range s.watchers {
+		if !w.matchesKey(key) {
+			continue
+		}
+		select {
+		case w.incoming <- e:
+		default:
+			// the watcher isn't keeping up, the client will watch again from its last resourceVersion
+			w.terminate()
+			delete(s.watchers, id)
+		}
+	}
+	return stored, nil
+}
+
+// set replaces the object of the key in the map and in the indexes.
+func (s *store) set(key string, obj runtime.Object) {
+	if prevObj, found := s.objects[key]; found {
+		for f, value := range s.indexValues(prevObj) {
+			s.indexes[f][value].Delete(key)
+			if s.indexes[f][value].Len() == 0 {
+				delete(s.indexes[f], value)
+			}
+		}
+		delete(s.objects, key)
+	}
+	if obj == nil {
+		return
+	}
+	s.objects[key] = obj
+	for f, value := range s.indexValues(obj) {
+		if s.indexes[f] == nil {
+			s.indexes[f] = map[string]sets.String{}
+		}
+		if s.indexes[f][value] == nil {
+			s.indexes[f][value] = sets.NewString()
+		}
+		s.indexes[f][value].Insert(key)
+	}
+}
+
+func (s *store) indexValues(obj runtime.Object) map[string]string {
+	indexer, ok := obj.(resourcerest.FieldsIndexer)
+	if !ok {
+		return nil
+	}
+	values := map[string]string{}
+	for _, f := range s.indexedFields {
+		values[f] = indexer.GetField(f)
+	}
+	return values
+}
+
+// list appends the objects of the key, or of all the keys under it if recursive, matching the
+// predicate to the list.  The recursive lists are paginated by the limit and the continue token
+// of the predicate.
+func (s *store) list(key string, recursive bool, pred storage.SelectionPredicate, listObj runtime.Object) error {
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+	pred = s.predicate(pred)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := s.candidates(key, recursive, pred)
+	rev := s.rev
+	if recursive && len(pred.Continue) > 0 {
+		startKey, continueRev, err := decodeContinue(pred.Continue, key)
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+		}
+		keys = keys[sort.SearchStrings(keys, startKey):]
+		rev = continueRev
+	}
+	limit := pred.Limit
+	if !recursive {
+		limit = 0
+	}
+
+	var count int64
+	var next string
+	var remaining *int64
+	for i, k := range keys {
+		obj := s.objects[k]
+		if !matches(pred, obj) {
+			continue
+		}
+		if limit > 0 && count == limit {
+			var err error
+			if next, err = encodeContinue(k, key, rev); err != nil {
+				return err
+			}
+			// the remaining objects are only counted without selectors, as by etcd
+			if pred.Empty() {
+				n := int64(len(keys) - i)
+				remaining = &n
+			}
+			break
+		}
+		item := reflect.ValueOf(obj.DeepCopyObject())
+		if v.Type().Elem().Kind() != reflect.Ptr && v.Type().Elem().Kind() != reflect.Interface {
+			item = item.Elem()
+		}
+		v.Set(reflect.Append(v, item))
+		count++
+	}
+	return s.versioner.UpdateList(listObj, rev, next, remaining)
+}
+
+// continueToken is the continue token of the lists, in the same format as by etcd.
+type continueToken struct {
+	APIVersion      string `json:"v"`
+	ResourceVersion uint64 `json:"rv"`
+	StartKey        string `json:"start"`
+}
+
+// encodeContinue returns the continue token of a list of the key resuming from the next key.
+func encodeContinue(next, key string, rev uint64) (string, error) {
+	data, err := json.Marshal(&continueToken{
+		APIVersion:      "meta.k8s.io/v1",
+		ResourceVersion: rev,
+		StartKey:        strings.TrimPrefix(next, keyPrefix(key)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeContinue returns the key and the resourceVersion a list of the key resumes from.
+func decodeContinue(continueValue, key string) (string, uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(continueValue)
+	if err != nil {
+		return "", 0, err
+	}
+	var c continueToken
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", 0, err
+	}
+	if c.APIVersion != "meta.k8s.io/v1" {
+		return "", 0, fmt.Errorf("unknown version %q", c.APIVersion)
+	}
+	if c.ResourceVersion == 0 || len(c.StartKey) == 0 {
+		return "", 0, errors.New("missing resourceVersion or start key")
+	}
+	// the start key can't escape the listed key
+	if cleaned := path.Clean("/" + c.StartKey); cleaned != "/"+c.StartKey {
+		return "", 0, fmt.Errorf("invalid start key %q", c.StartKey)
+	}
+	return keyPrefix(key) + c.StartKey, c.ResourceVersion, nil
+}
+
+// candidates returns the sorted keys of the objects which may match the predicate, looking up
+// the index of the first indexed field the predicate requires.  It must be called with the lock held.
+func (s *store) candidates(key string, recursive bool, pred storage.SelectionPredicate) []string {
+	for _, f := range s.indexedFields {
+		value, found := pred.Field.RequiresExactMatch(f)
+		if !found {
+			continue
+		}
+		var keys []string
+		for _, k := range s.indexes[f][value].List() {
+			if matchesKey(key, recursive, k) {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	return s.keys(key, recursive)
+}
+
+// keys returns the sorted keys of the objects of the key, or of all the keys under it if recursive.
+// It must be called with the lock held.
+func (s *store) keys(key string, recursive bool) []string {
+	var keys []string
+	for k := range s.objects {
+		if matchesKey(key, recursive, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// predicate defaults the selectors of the predicate, and matches the indexed fields along with
+// the fields of its attributes.
+func (s *store) predicate(pred storage.SelectionPredicate) storage.SelectionPredicate {
+	if pred.Label == nil {
+		pred.Label = labels.Everything()
+	}
+	if pred.Field == nil {
+		pred.Field = fields.Everything()
+	}
+	getAttrs := pred.GetAttrs
+	if getAttrs == nil {
+		getAttrs = s.getAttrs
+	}
+	pred.GetAttrs = func(obj runtime.Object) (labels.Set, fields.Set, error) {
+		ls, fs, err := getAttrs(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ls, withIndexedFields(obj, fs), nil
+	}
+	return pred
+}
+
+func matches(pred storage.SelectionPredicate, obj runtime.Object) bool {
+	ok, err := pred.Matches(obj)
+	return err == nil && ok
+}
+
+// matchesKey returns true if the key is the same as the watched or listed key, or under it if recursive.
+func matchesKey(key string, recursive bool, k string) bool {
+	if !recursive {
+		return k == key
+	}
+	return strings.HasPrefix(k, keyPrefix(key))
+}
+
+// keyPrefix returns the prefix of the keys under the key.
+func keyPrefix(key string) string {
+	if !strings.HasSuffix(key, "/") {
+		return key + "/"
+	}
+	return key
+}
+
+// setObject copies the stored object into the output object.
+func setObject(out, obj runtime.Object) error {
+	outVal, err := conversion.EnforcePtr(out)
+	if err != nil {
+		return err
+	}
+	objVal, err := conversion.EnforcePtr(obj.DeepCopyObject())
+	if err != nil {
+		return err
+	}
+	if outVal.Type() != objVal.Type() {
+		return fmt.Errorf("can't copy %v into %v", objVal.Type(), outVal.Type())
+	}
+	outVal.Set(objVal)
+	return nil
+}
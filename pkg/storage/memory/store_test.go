@@ -0,0 +1,216 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/apis/example"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// wait is how long the tests wait for the watch events.
+const wait = 5 * time.Second
+
+func newTestStore(t *testing.T, getter *restOptionsGetter) storage.Interface {
+	gr := schema.GroupResource{Resource: "pods"}
+	options, err := getter.GetRESTOptions(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, err := options.Decorator(options.StorageConfig, options.ResourcePrefix, nil,
+		func() runtime.Object { return &example.Pod{} },
+		func() runtime.Object { return &example.PodList{} },
+		storage.DefaultNamespaceScopedAttr, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newPod(name string) *example.Pod {
+	return &example.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": name}},
+	}
+}
+
+func everything() storage.SelectionPredicate {
+	return storage.SelectionPredicate{Label: labels.Everything(), GetAttrs: storage.DefaultNamespaceScopedAttr}
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := newTestStore(t, newRESTOptionsGetter(func(schema.GroupResource) string { return "" }))
+	ctx := context.TODO()
+
+	created := &example.Pod{}
+	if err := s.Create(ctx, "/pods/default/foo", newPod("foo"), created, 0); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.ResourceVersion == "" {
+		t.Fatal("create: no resourceVersion set")
+	}
+	if err := s.Create(ctx, "/pods/default/foo", newPod("foo"), &example.Pod{}, 0); !storage.IsNodeExist(err) {
+		t.Fatalf("create existing: expected exists error, got %v", err)
+	}
+
+	got := &example.Pod{}
+	if err := s.Get(ctx, "/pods/default/foo", storage.GetOptions{}, got); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Name != "foo" || got.ResourceVersion != created.ResourceVersion {
+		t.Fatalf("get: got %s at %s, expected foo at %s", got.Name, got.ResourceVersion, created.ResourceVersion)
+	}
+	if err := s.Get(ctx, "/pods/default/bar", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Fatalf("get missing: expected not found error, got %v", err)
+	}
+
+	list := &example.PodList{}
+	if err := s.List(ctx, "/pods/default", storage.ListOptions{Predicate: everything()}, list); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Items) != 1 || list.ResourceVersion != created.ResourceVersion {
+		t.Fatalf("list: got %d items at %s, expected 1 at %s", len(list.Items), list.ResourceVersion, created.ResourceVersion)
+	}
+
+	w, err := s.WatchList(ctx, "/pods/default", storage.ListOptions{ResourceVersion: list.ResourceVersion, Predicate: everything()})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer w.Stop()
+
+	if err := s.Create(ctx, "/pods/default/bar", newPod("bar"), &example.Pod{}, 0); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	updated := &example.Pod{}
+	err = s.GuaranteedUpdate(ctx, "/pods/default/foo", updated, false, nil,
+		func(input runtime.Object, _ storage.ResponseMeta) (runtime.Object, *uint64, error) {
+			pod := input.(*example.Pod)
+			pod.Spec.NodeName = "node"
+			return pod, nil, nil
+		}, nil)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := s.Delete(ctx, "/pods/default/bar", &example.Pod{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	expected := []struct {
+		eventType watch.EventType
+		name      string
+	}{
+		{watch.Added, "bar"},
+		{watch.Modified, "foo"},
+		{watch.Deleted, "bar"},
+	}
+	for _, e := range expected {
+		select {
+		case got := <-w.ResultChan():
+			pod, ok := got.Object.(*example.Pod)
+			if !ok || got.Type != e.eventType || pod.Name != e.name {
+				t.Fatalf("watch: got %s %v, expected %s %s", got.Type, got.Object, e.eventType, e.name)
+			}
+			if e.name == "foo" && pod.Spec.NodeName != "node" {
+				t.Fatalf("watch: got the update of foo without its change")
+			}
+		case <-time.After(wait):
+			t.Fatalf("watch: timed out waiting for %s %s", e.eventType, e.name)
+		}
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	s := newTestStore(t, newRESTOptionsGetter(func(schema.GroupResource) string { return "" }))
+	ctx := context.TODO()
+	for i := 0; i < 5; i++ {
+		if err := s.Create(ctx, fmt.Sprintf("/pods/default/pod-%d", i), newPod(fmt.Sprintf("pod-%d", i)), &example.Pod{}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	var firstRev string
+	pred := everything()
+	pred.Limit = 2
+	for page := 0; ; page++ {
+		list := &example.PodList{}
+		if err := s.List(ctx, "/pods/default", storage.ListOptions{Predicate: pred}, list); err != nil {
+			t.Fatalf("page %d: %v", page, err)
+		}
+		if page == 0 {
+			firstRev = list.ResourceVersion
+			// the later pages are returned with the resourceVersion of the first one
+			if err := s.Create(ctx, "/pods/default/pod-9", newPod("pod-9"), &example.Pod{}, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if list.ResourceVersion != firstRev {
+			t.Fatalf("page %d: got resourceVersion %s, expected %s", page, list.ResourceVersion, firstRev)
+		}
+		if len(list.Items) > 2 {
+			t.Fatalf("page %d: got %d items over the limit", page, len(list.Items))
+		}
+		for _, pod := range list.Items {
+			names = append(names, pod.Name)
+		}
+		if list.Continue == "" {
+			if list.RemainingItemCount != nil {
+				t.Fatalf("page %d: got remaining items on the last page", page)
+			}
+			break
+		}
+		if list.RemainingItemCount == nil || *list.RemainingItemCount == 0 {
+			t.Fatalf("page %d: expected remaining items with a continue token", page)
+		}
+		pred.Continue = list.Continue
+	}
+	expected := "[pod-0 pod-1 pod-2 pod-3 pod-4 pod-9]"
+	if fmt.Sprint(names) != expected {
+		t.Fatalf("got %v, expected %s", names, expected)
+	}
+
+	pred.Continue = "invalid"
+	if err := s.List(ctx, "/pods/default", storage.ListOptions{Predicate: pred}, &example.PodList{}); err == nil {
+		t.Fatal("expected an error for an invalid continue token")
+	}
+}
+
+func TestStoresScopedByGetter(t *testing.T) {
+	getter := newRESTOptionsGetter(func(schema.GroupResource) string { return "" })
+	s := newTestStore(t, getter)
+	if err := s.Create(context.TODO(), "/pods/default/foo", newPod("foo"), &example.Pod{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the versions of a resource share its store
+	if err := newTestStore(t, getter).Get(context.TODO(), "/pods/default/foo", storage.GetOptions{}, &example.Pod{}); err != nil {
+		t.Fatalf("expected the same store from the same getter: %v", err)
+	}
+	// another apiserver starts with no objects
+	other := newTestStore(t, newRESTOptionsGetter(func(schema.GroupResource) string { return "" }))
+	if err := other.Get(context.TODO(), "/pods/default/foo", storage.GetOptions{}, &example.Pod{}); !storage.IsNotFound(err) {
+		t.Fatalf("expected not found from another getter, got %v", err)
+	}
+}
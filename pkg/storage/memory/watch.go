@@ -0,0 +1,166 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// watchBufferSize is the number of the events a watcher can fall behind before being terminated.
+const watchBufferSize = 100
+
+var _ watch.Interface = &watcher{}
+
+// watcher receives the events of the watched keys from the store, and sends those matching its
+// predicate to the client.
+type watcher struct {
+	id        int
+	store     *store
+	key       string
+	recursive bool
+	pred      storage.SelectionPredicate
+
+	incoming chan event
+	result   chan watch.Event
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// watch starts watching the key, or all the keys under it if recursive.  The watch starts with
+// the current objects as added for an unset resourceVersion, or resumes after the resourceVersion
+// from the recent events.
+func (s *store) watch(ctx context.Context, key string, opts storage.ListOptions, recursive bool) (watch.Interface, error) {
+	rev, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var initial []event
+	switch {
+	case rev == 0:
+		for _, k := range s.keys(key, recursive) {
+			initial = append(initial, event{rev: s.rev, key: k, obj: s.objects[k]})
+		}
+	case rev < s.rev:
+		if len(s.events) == 0 || s.events[0].rev > rev+1 {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rev, s.rev))
+		}
+		for _, e := range s.events {
+			if e.rev > rev && matchesKey(key, recursive, e.key) {
+				initial = append(initial, e)
+			}
+		}
+	}
+
+	w := &watcher{
+		id:        s.nextWatcherID,
+		store:     s,
+		key:       key,
+		recursive: recursive,
+		pred:      s.predicate(opts.Predicate),
+		incoming:  make(chan event, len(initial)+watchBufferSize),
+		result:    make(chan watch.Event),
+		done:      make(chan struct{}),
+	}
+	for _, e := range initial {
+		w.incoming <- e
+	}
+	s.nextWatcherID++
+	s.watchers[w.id] = w
+
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.result)
+	defer w.Stop()
+	for {
+		select {
+		case e := <-w.incoming:
+			ev, ok := w.convert(e)
+			if !ok {
+				continue
+			}
+			select {
+			case w.result <- ev:
+			case <-w.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		case <-w.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// convert returns the watch event of the change for the predicate of the watcher, an object no
+// longer matching is deleted and an object starting to match is added.
+func (w *watcher) convert(e event) (watch.Event, bool) {
+	curPasses := e.obj != nil && matches(w.pred, e.obj)
+	oldPasses := e.prevObj != nil && matches(w.pred, e.prevObj)
+	switch {
+	case curPasses && oldPasses:
+		return watch.Event{Type: watch.Modified, Object: e.obj.DeepCopyObject()}, true
+	case curPasses:
+		return watch.Event{Type: watch.Added, Object: e.obj.DeepCopyObject()}, true
+	case oldPasses:
+		// the last state of the object, at the resourceVersion it has been deleted
+		obj := e.prevObj.DeepCopyObject()
+		if err := w.store.versioner.UpdateObject(obj, e.rev); err != nil {
+			return watch.Event{}, false
+		}
+		return watch.Event{Type: watch.Deleted, Object: obj}, true
+	}
+	return watch.Event{}, false
+}
+
+func (w *watcher) matchesKey(key string) bool {
+	return matchesKey(w.key, w.recursive, key)
+}
+
+// terminate stops sending the events.  The store must remove the watcher.
+func (w *watcher) terminate() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// Stop implements watch.Interface.
+func (w *watcher) Stop() {
+	w.terminate()
+	w.store.lock.Lock()
+	delete(w.store.watchers, w.id)
+	w.store.lock.Unlock()
+}
+
+// ResultChan implements watch.Interface.
+func (w *watcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
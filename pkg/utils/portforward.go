@@ -1,17 +1,21 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -21,96 +25,351 @@ import (
 )
 
 type LocalProxyServer interface {
+	// Listen starts forwarding the connections to the local port, and returns a func closing
+	// the proxy.
 	Listen() (func(), error)
+	// Addr returns the local address of the proxy once listening.
+	Addr() string
+	// Close stops listening and closes the forwarded connections.
+	Close() error
 }
 
 // PortForwardProtocolV1Name is the subprotocol used for port forwarding.
 const PortForwardProtocolV1Name = "portforward.k8s.io"
 
+// endpointsResyncPeriod is how long the ready endpoints of a service are cached. They are
+// resolved again sooner when a pod can't be reached.
+const endpointsResyncPeriod = 10 * time.Second
+
 func NewLocalProxy(
 	restConfig *rest.Config,
 	podNamespace,
 	podName string,
 	targetPort int32) LocalProxyServer {
+	return newPodProxy(restConfig, podNamespace, podName, targetPort, targetPort)
+}
+
+// NewEphemeralLocalProxy forwards a local port chosen by the system to the port of the pod, so
+// that several proxies run at the same time.
+func NewEphemeralLocalProxy(
+	restConfig *rest.Config,
+	podNamespace,
+	podName string,
+	targetPort int32) LocalProxyServer {
+	return newPodProxy(restConfig, podNamespace, podName, targetPort, 0)
+}
+
+// NewPodLocalProxy forwards the local port to the port of the pod. The local port is chosen by
+// the system if 0.
+func NewPodLocalProxy(
+	restConfig *rest.Config,
+	podNamespace,
+	podName string,
+	targetPort int32,
+	localPort int32) LocalProxyServer {
+	return newPodProxy(restConfig, podNamespace, podName, targetPort, localPort)
+}
+
+// NewServiceLocalProxy forwards the local port to the ready endpoints of the port of the
+// service, distributing the connections round-robin across the pods. The local port is chosen
+// by the system if 0.
+func NewServiceLocalProxy(
+	restConfig *rest.Config,
+	serviceNamespace,
+	serviceName string,
+	servicePort int32,
+	localPort int32) LocalProxyServer {
+	r := &roundRobin{
+		restConfig: restConfig,
+		localPort:  localPort,
+	}
+	r.resolve = func() ([]PodPort, error) {
+		return ServiceEndpoints(r.client, serviceNamespace, serviceName, servicePort)
+	}
+	return r
+}
+
+func newPodProxy(restConfig *rest.Config, podNamespace, podName string, targetPort, localPort int32) *roundRobin {
 	return &roundRobin{
-		restConfig:   restConfig,
-		podNamespace: podNamespace,
-		podName:      podName,
-		targetPort:   targetPort,
+		restConfig: restConfig,
+		localPort:  localPort,
+		resolve: func() ([]PodPort, error) {
+			return []PodPort{{Namespace: podNamespace, Name: podName, Port: targetPort}}, nil
+		},
 	}
 }
 
 var _ LocalProxyServer = &roundRobin{}
 
-type roundRobin struct {
-	podNamespace string
-	podName      string
-	targetPort   int32
+// PodPort is a port of a pod the connections are forwarded to.
+type PodPort struct {
+	Namespace string
+	Name      string
+	Port      int32
+}
 
+func (p PodPort) String() string {
+	return fmt.Sprintf("%s/%s:%d", p.Namespace, p.Name, p.Port)
+}
+
+// podConnection is the port-forward connection to a pod, shared by the forwarded connections.
+type podConnection struct {
+	conn      httpstream.Connection
+	requestID int
+}
+
+type roundRobin struct {
 	restConfig *rest.Config
+	localPort  int32
+	// resolve returns the pod ports ready to receive connections.
+	resolve func() ([]PodPort, error)
+
+	client   kubernetes.Interface
+	dialer   func(PodPort) httpstream.Dialer
+	listener net.Listener
+	addr     string
+
+	lock     sync.Mutex
+	closed   bool
+	backends []PodPort
+	resolved time.Time
+	next     int
+	pods     map[PodPort]*podConnection
+	conns    map[net.Conn]struct{}
+}
+
+func (r *roundRobin) Addr() string {
+	return r.addr
 }
 
 func (r *roundRobin) Listen() (func(), error) {
-	klog.V(4).Infof("Started local proxy server at port %d", r.targetPort)
+	if r.dialer == nil {
+		if err := r.newDialer(); err != nil {
+			return nil, err
+		}
+	}
+	r.pods = map[PodPort]*podConnection{}
+	r.conns = map[net.Conn]struct{}{}
+
 	listener, err := net.Listen(
 		"tcp",
-		net.JoinHostPort("localhost", strconv.Itoa(int(r.targetPort))))
+		net.JoinHostPort("localhost", strconv.Itoa(int(r.localPort))))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create listener: Error %s", err)
 	}
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				if !strings.Contains(strings.ToLower(err.Error()), "use of closed network connection") {
-					runtime.HandleError(fmt.Errorf("error accepting connection on port %d: %v", r.targetPort, err))
-				}
+	r.listener = listener
+	r.addr = listener.Addr().String()
+	klog.V(4).Infof("Started local proxy server at %s", r.addr)
+	go r.serve()
+	return func() { r.Close() }, nil
+}
+
+// newDialer dials the pods through the portforward subresource of the apiserver of restConfig.
+func (r *roundRobin) newDialer() error {
+	client, err := kubernetes.NewForConfig(r.restConfig)
+	if err != nil {
+		return err
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(r.restConfig)
+	if err != nil {
+		return err
+	}
+	r.client = client
+	r.dialer = func(b PodPort) httpstream.Dialer {
+		req := client.CoreV1().RESTClient().
+			Post().
+			Resource("pods").
+			Namespace(b.Namespace).
+			Name(b.Name).
+			SubResource("portforward")
+		return spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	}
+	return nil
+}
+
+func (r *roundRobin) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
-			go func() {
-				if err := r.handle(conn); err != nil {
-					runtime.HandleError(fmt.Errorf("error handling connection: %v", err))
-				}
-			}()
+			runtime.HandleError(fmt.Errorf("error accepting connection on %s: %v", r.addr, err))
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
-	}()
-	return func() { listener.Close() }, nil
+		if !r.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer r.untrack(conn)
+			if err := r.handle(conn); err != nil {
+				runtime.HandleError(fmt.Errorf("error handling connection: %v", err))
+			}
+		}()
+	}
+}
 
+func (r *roundRobin) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	for conn := range r.conns {
+		conn.Close()
+	}
+	for b, pc := range r.pods {
+		pc.conn.Close()
+		delete(r.pods, b)
+	}
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Close()
 }
 
+func (r *roundRobin) track(conn net.Conn) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *roundRobin) untrack(conn net.Conn) {
+	conn.Close()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.conns, conn)
+}
+
+// handle forwards the connection to the next backend, trying the others if it can't be reached,
+// then the backends resolved again in case the pods were replaced.
 func (r *roundRobin) handle(conn net.Conn) error {
 	klog.V(6).Info("Receiving connection")
-	transport, upgrader, err := spdy.RoundTripperFor(r.restConfig)
-	if err != nil {
-		return err
+	rc := &replayConn{Conn: conn, recording: true}
+	var lastErr error
+	for _, refresh := range []bool{false, true} {
+		backends, start, err := r.pick(refresh)
+		if err != nil {
+			return err
+		}
+		for i := range backends {
+			b := backends[(start+i)%len(backends)]
+			pc, requestID, err := r.connect(b)
+			if err == nil {
+				err = r.forward(rc, b, pc, requestID)
+				if err == nil {
+					return nil
+				}
+			}
+			if !errors.Is(err, errBackendUnreachable) {
+				return err
+			}
+			klog.V(4).Infof("Failed forwarding to %s: %v", b, err)
+			lastErr = err
+		}
 	}
-	nativeClient, err := kubernetes.NewForConfig(r.restConfig)
-	if err != nil {
-		return err
+	return lastErr
+}
+
+// pick returns the ready backends, and the index of the one to forward the next connection to.
+func (r *roundRobin) pick(refresh bool) ([]PodPort, int, error) {
+	r.lock.Lock()
+	stale := refresh || r.backends == nil || time.Since(r.resolved) > endpointsResyncPeriod
+	r.lock.Unlock()
+	if stale {
+		backends, err := r.resolve()
+		if err != nil {
+			return nil, 0, err
+		}
+		r.lock.Lock()
+		r.backends = backends
+		r.resolved = time.Now()
+		r.lock.Unlock()
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.backends) == 0 {
+		return nil, 0, errors.New("no ready endpoint to forward to")
 	}
-	req := nativeClient.RESTClient().
-		Post().
-		Prefix("api", "v1").
-		Resource("pods").
-		Namespace(r.podNamespace).
-		Name(r.podName).
-		SubResource("portforward")
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
-	streamConn, _, err := dialer.Dial(PortForwardProtocolV1Name)
+	start := r.next % len(r.backends)
+	r.next++
+	return r.backends, start, nil
+}
+
+var errBackendUnreachable = errors.New("backend unreachable")
+
+// connect returns the port-forward connection to the pod of the backend, dialing it if
+// there is none, with the next request id of the connection.
+func (r *roundRobin) connect(b PodPort) (httpstream.Connection, int, error) {
+	r.lock.Lock()
+	if pc, ok := r.pods[b]; ok {
+		pc.requestID++
+		defer r.lock.Unlock()
+		return pc.conn, pc.requestID, nil
+	}
+	r.lock.Unlock()
+
+	streamConn, _, err := r.dialer(b).Dial(PortForwardProtocolV1Name)
 	if err != nil {
-		return err
+		return nil, 0, fmt.Errorf("%w: %v", errBackendUnreachable, err)
+	}
+	klog.V(4).Infof("Connected to %s", b)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		streamConn.Close()
+		return nil, 0, errors.New("proxy closed")
+	}
+	if pc, ok := r.pods[b]; ok {
+		// dialed concurrently
+		streamConn.Close()
+		pc.requestID++
+		return pc.conn, pc.requestID, nil
 	}
-	defer streamConn.Close()
+	r.pods[b] = &podConnection{conn: streamConn}
+	go func() {
+		<-streamConn.CloseChan()
+		klog.V(4).Infof("Connection to %s closed", b)
+		r.dropPodConnection(b, streamConn)
+	}()
+	return streamConn, 0, nil
+}
 
+// dropPodConnection forgets the connection to the pod so that it's dialed again, and resolves
+// the backends again in case the pod disappeared.
+func (r *roundRobin) dropPodConnection(b PodPort, streamConn httpstream.Connection) {
+	streamConn.Close()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if pc, ok := r.pods[b]; ok && pc.conn == streamConn {
+		delete(r.pods, b)
+	}
+	r.resolved = time.Time{}
+}
+
+// forward copies the connection to and from a stream of the port-forward connection to the pod.
+// The error wraps errBackendUnreachable if the streams couldn't be created, or if the pod
+// reported an error, e.g. refusing the connection, before replying anything.
+func (r *roundRobin) forward(conn *replayConn, b PodPort, streamConn httpstream.Connection, requestID int) error {
 	// create error stream
 	headers := http.Header{}
 	headers.Set(v1.StreamType, v1.StreamTypeError)
-	headers.Set(v1.PortHeader, fmt.Sprintf("%d", r.targetPort))
-	headers.Set(v1.PortForwardRequestIDHeader, strconv.Itoa(0))
+	headers.Set(v1.PortHeader, fmt.Sprintf("%d", b.Port))
+	headers.Set(v1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
 
 	errorStream, err := streamConn.CreateStream(headers)
 	if err != nil {
-		runtime.HandleError(fmt.Errorf("error creating error stream for port %d -> %d: %v", r.targetPort, r.targetPort, err))
-		return err
+		r.dropPodConnection(b, streamConn)
+		return fmt.Errorf("%w: error creating error stream for port %d of %s: %v", errBackendUnreachable, b.Port, b.Name, err)
 	}
 	// we're not writing to this stream
 	errorStream.Close()
@@ -120,9 +379,9 @@ func (r *roundRobin) handle(conn net.Conn) error {
 		message, err := ioutil.ReadAll(errorStream)
 		switch {
 		case err != nil:
-			errorChan <- fmt.Errorf("error reading from error stream for port %d -> %d: %v", r.targetPort, r.targetPort, err)
+			errorChan <- fmt.Errorf("error reading from error stream for port %d of %s: %v", b.Port, b.Name, err)
 		case len(message) > 0:
-			errorChan <- fmt.Errorf("an error occurred forwarding %d -> %d: %v", r.targetPort, r.targetPort, string(message))
+			errorChan <- fmt.Errorf("an error occurred forwarding to port %d of %s: %v", b.Port, b.Name, string(message))
 		}
 		close(errorChan)
 	}()
@@ -131,16 +390,21 @@ func (r *roundRobin) handle(conn net.Conn) error {
 	headers.Set(v1.StreamType, v1.StreamTypeData)
 	dataStream, err := streamConn.CreateStream(headers)
 	if err != nil {
-		runtime.HandleError(fmt.Errorf("error creating forwarding stream for port %d -> %d: %v", r.targetPort, r.targetPort, err))
-		return err
+		streamConn.RemoveStreams(errorStream)
+		r.dropPodConnection(b, streamConn)
+		return fmt.Errorf("%w: error creating forwarding stream for port %d of %s: %v", errBackendUnreachable, b.Port, b.Name, err)
 	}
+	defer streamConn.RemoveStreams(errorStream, dataStream)
 
 	localError := make(chan struct{})
+	localDone := make(chan struct{})
 	remoteDone := make(chan struct{})
+	// aborted is closed when the copies are interrupted after an error of the pod
+	aborted := make(chan struct{})
 
 	go func() {
 		// Copy from the remote side to the local port.
-		if _, err := io.Copy(conn, dataStream); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		if _, err := io.Copy(conn, dataStream); err != nil && !isAborted(aborted, err) {
 			runtime.HandleError(fmt.Errorf("error copying from remote stream to local connection: %v", err))
 		}
 
@@ -149,11 +413,12 @@ func (r *roundRobin) handle(conn net.Conn) error {
 	}()
 
 	go func() {
+		defer close(localDone)
 		// inform server we're not sending any more data after copy unblocks
 		defer dataStream.Close()
 
 		// Copy from the local port to the remote side.
-		if _, err := io.Copy(dataStream, conn); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		if _, err := io.Copy(dataStream, conn); err != nil && !isAborted(aborted, err) {
 			runtime.HandleError(fmt.Errorf("error copying from local connection to remote stream: %v", err))
 			// break out of the select below without waiting for the other copy to finish
 			close(localError)
@@ -168,7 +433,153 @@ func (r *roundRobin) handle(conn net.Conn) error {
 		klog.V(6).Info("Connection closed due to local error")
 	}
 
-	return nil
+	// always expect something on errorChan (it may be nil)
+	err = <-errorChan
+	if err == nil {
+		return nil
+	}
+
+	// stop both copies to replay the connection to another pod if nothing was replied
+	close(aborted)
+	conn.SetDeadline(time.Now())
+	dataStream.Reset()
+	<-localDone
+	<-remoteDone
+	conn.SetDeadline(time.Time{})
+	if conn.rewind() {
+		return fmt.Errorf("%w: %v", errBackendUnreachable, err)
+	}
+	return err
+}
+
+// isAborted returns true if the copy failed because it was aborted, or the connection was closed.
+func isAborted(aborted chan struct{}, err error) bool {
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		return true
+	}
+	select {
+	case <-aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxReplay bounds what is read from a local connection before the pod replies, to be
+// replayed to another pod.
+const maxReplay = 64 * 1024
+
+// replayConn records what is read from the local connection until the pod replies, so that the
+// connection can be forwarded again to another pod if the first one refused it.
+type replayConn struct {
+	net.Conn
+
+	lock sync.Mutex
+	// recording is false once the pod replied, or more than maxReplay was read.
+	recording bool
+	sent      []byte
+	pending   []byte
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	if len(c.pending) > 0 {
+		defer c.lock.Unlock()
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		c.record(b[:n])
+		return n, nil
+	}
+	c.lock.Unlock()
+
+	n, err := c.Conn.Read(b)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.record(b[:n])
+	return n, err
+}
+
+func (c *replayConn) record(b []byte) {
+	if !c.recording {
+		return
+	}
+	c.sent = append(c.sent, b...)
+	if len(c.sent) > maxReplay {
+		c.recording = false
+		c.sent = nil
+	}
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		c.lock.Lock()
+		c.recording = false
+		c.sent = nil
+		c.lock.Unlock()
+	}
+	return c.Conn.Write(b)
+}
+
+// rewind reads again what was read since the connection was accepted, and returns false if
+// the pod already replied.
+func (c *replayConn) rewind() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.recording {
+		return false
+	}
+	c.pending = append(c.sent, c.pending...)
+	c.sent = nil
+	return true
+}
+
+// ServiceEndpoints returns the ready endpoints of the port of the service.
+func ServiceEndpoints(client kubernetes.Interface, namespace, name string, port int32) ([]PodPort, error) {
+	service, err := client.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	portName, found := "", false
+	for _, p := range service.Spec.Ports {
+		if p.Port == port {
+			portName, found = p.Name, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("service %s/%s has no port %d", namespace, name, port)
+	}
+
+	endpoints, err := client.CoreV1().Endpoints(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var podPorts []PodPort
+	for _, subset := range endpoints.Subsets {
+		var targetPort int32
+		for _, p := range subset.Ports {
+			if p.Name == portName {
+				targetPort = p.Port
+			}
+		}
+		if targetPort == 0 {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			podPorts = append(podPorts, PodPort{
+				Namespace: address.TargetRef.Namespace,
+				Name:      address.TargetRef.Name,
+				Port:      targetPort,
+			})
+		}
+	}
+	sort.Slice(podPorts, func(i, j int) bool {
+		return podPorts[i].String() < podPorts[j].String()
+	})
+	klog.V(4).Infof("Resolved the endpoints of service %s/%s: %v", namespace, name, podPorts)
+	return podPorts, nil
 }
 
 var _ net.Conn = &conn{}
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// wait is how long the tests wait for the forwarded connections.
+const wait = 5 * time.Second
+
+// fakeStream is one side of a stream, reading what the other side writes.
+type fakeStream struct {
+	headers http.Header
+	r       *io.PipeReader
+	w       *io.PipeWriter
+}
+
+func newFakeStreamPair(headers http.Header) (client, server *fakeStream) {
+	toServerR, toServerW := io.Pipe()
+	toClientR, toClientW := io.Pipe()
+	return &fakeStream{headers: headers, r: toClientR, w: toServerW},
+		&fakeStream{headers: headers, r: toServerR, w: toClientW}
+}
+
+func (s *fakeStream) Read(b []byte) (int, error)  { return s.r.Read(b) }
+func (s *fakeStream) Write(b []byte) (int, error) { return s.w.Write(b) }
+func (s *fakeStream) Close() error                { return s.w.Close() }
+func (s *fakeStream) Headers() http.Header        { return s.headers }
+func (s *fakeStream) Identifier() uint32          { return 0 }
+
+func (s *fakeStream) Reset() error {
+	s.r.Close()
+	return s.w.Close()
+}
+
+// fakePod replies "<name>: <line>" to the first line sent to it, or refuses the connections.
+type fakePod struct {
+	name   string
+	refuse bool
+}
+
+func (p *fakePod) serve(errorStream, dataStream *fakeStream) {
+	defer errorStream.Close()
+	defer dataStream.Close()
+	if p.refuse {
+		fmt.Fprint(errorStream, "dial tcp4 127.0.0.1:443: connect: connection refused")
+		return
+	}
+	line, err := bufio.NewReader(dataStream).ReadString('\n')
+	if err != nil {
+		return
+	}
+	if line == "wait\n" {
+		io.Copy(ioutil.Discard, dataStream)
+		return
+	}
+	fmt.Fprintf(dataStream, "%s: %s", p.name, line)
+}
+
+// fakeConnection is the port-forward connection to a fakePod.
+type fakeConnection struct {
+	pod *fakePod
+
+	lock         sync.Mutex
+	closed       chan bool
+	streams      []*fakeStream
+	errorStreams map[string]*fakeStream
+}
+
+func (c *fakeConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	default:
+	}
+	client, server := newFakeStreamPair(headers)
+	c.streams = append(c.streams, client)
+	requestID := headers.Get(v1.PortForwardRequestIDHeader)
+	switch headers.Get(v1.StreamType) {
+	case v1.StreamTypeError:
+		c.errorStreams[requestID] = server
+	case v1.StreamTypeData:
+		go c.pod.serve(c.errorStreams[requestID], server)
+	}
+	return client, nil
+}
+
+func (c *fakeConnection) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.closed:
+		return nil
+	default:
+	}
+	close(c.closed)
+	for _, s := range c.streams {
+		s.Reset()
+	}
+	return nil
+}
+
+func (c *fakeConnection) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *fakeConnection) CloseChan() <-chan bool                     { return c.closed }
+func (c *fakeConnection) SetIdleTimeout(time.Duration)               {}
+func (c *fakeConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+type dialerFunc func(protocols ...string) (httpstream.Connection, string, error)
+
+func (f dialerFunc) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	return f(protocols...)
+}
+
+// fakeCluster serves the fake pods of the endpoints, and records the connections to them.
+type fakeCluster struct {
+	lock        sync.Mutex
+	pods        map[string]*fakePod
+	endpoints   []PodPort
+	connections map[string][]*fakeConnection
+}
+
+func newFakeCluster(pods ...*fakePod) *fakeCluster {
+	c := &fakeCluster{pods: map[string]*fakePod{}, connections: map[string][]*fakeConnection{}}
+	for _, p := range pods {
+		c.pods[p.name] = p
+		c.endpoints = append(c.endpoints, PodPort{Namespace: "default", Name: p.name, Port: 443})
+	}
+	return c
+}
+
+// replace deletes the pod, closing its connections, and replaces its endpoint by a new pod.
+func (c *fakeCluster) replace(name string, pod *fakePod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pods, name)
+	c.pods[pod.name] = pod
+	for i, e := range c.endpoints {
+		if e.Name == name {
+			c.endpoints[i].Name = pod.name
+		}
+	}
+	for _, conn := range c.connections[name] {
+		conn.Close()
+	}
+}
+
+// connection returns the first connection to the pod.
+func (c *fakeCluster) connection(name string) *fakeConnection {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.connections[name]) == 0 {
+		return nil
+	}
+	return c.connections[name][0]
+}
+
+func (c *fakeCluster) proxy(t *testing.T) *roundRobin {
+	r := &roundRobin{
+		resolve: func() ([]PodPort, error) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			return append([]PodPort{}, c.endpoints...), nil
+		},
+		dialer: func(b PodPort) httpstream.Dialer {
+			return dialerFunc(func(...string) (httpstream.Connection, string, error) {
+				c.lock.Lock()
+				defer c.lock.Unlock()
+				pod, ok := c.pods[b.Name]
+				if !ok {
+					return nil, "", fmt.Errorf("pods %q not found", b.Name)
+				}
+				conn := &fakeConnection{pod: pod, closed: make(chan bool), errorStreams: map[string]*fakeStream{}}
+				c.connections[b.Name] = append(c.connections[b.Name], conn)
+				return conn, PortForwardProtocolV1Name, nil
+			})
+		},
+	}
+	if _, err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// request sends the line to the proxy and returns the reply.
+func request(t *testing.T, r *roundRobin, line string) string {
+	conn, err := net.Dial("tcp", r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wait))
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply)
+}
+
+func TestRoundRobin(t *testing.T) {
+	cluster := newFakeCluster(&fakePod{name: "a"}, &fakePod{name: "b"}, &fakePod{name: "c"})
+	r := cluster.proxy(t)
+
+	_, port, err := net.SplitHostPort(r.Addr())
+	if err != nil || port == "0" {
+		t.Fatalf("expected the address of the port chosen by the system, got %q", r.Addr())
+	}
+
+	var replies []string
+	for i := 0; i < 6; i++ {
+		replies = append(replies, request(t, r, "hello"))
+	}
+	expected := "[a: hello\n b: hello\n c: hello\n a: hello\n b: hello\n c: hello\n]"
+	if fmt.Sprint(replies) != expected {
+		t.Fatalf("got %q, expected %q", replies, expected)
+	}
+	for name, conns := range cluster.connections {
+		if len(conns) != 1 {
+			t.Errorf("expected a single connection to pod %s, got %d", name, len(conns))
+		}
+	}
+}
+
+func TestResolveAfterPodDisappears(t *testing.T) {
+	cluster := newFakeCluster(&fakePod{name: "a"}, &fakePod{name: "b"})
+	r := cluster.proxy(t)
+
+	if reply := request(t, r, "hello"); reply != "a: hello\n" {
+		t.Fatalf("got %q, expected the reply of pod a", reply)
+	}
+	cluster.replace("a", &fakePod{name: "c"})
+
+	replies := map[string]int{}
+	for i := 0; i < 4; i++ {
+		replies[request(t, r, "hello")]++
+	}
+	if replies["a: hello\n"] != 0 || replies["c: hello\n"] == 0 || replies["b: hello\n"] == 0 {
+		t.Fatalf("expected the replies of pods b and c only, got %v", replies)
+	}
+}
+
+func TestRefusedConnectionForwardedToNextPod(t *testing.T) {
+	cluster := newFakeCluster(&fakePod{name: "a", refuse: true}, &fakePod{name: "b"})
+	r := cluster.proxy(t)
+
+	for i := 0; i < 2; i++ {
+		if reply := request(t, r, "hello"); reply != "b: hello\n" {
+			t.Fatalf("got %q, expected the reply of pod b", reply)
+		}
+	}
+}
+
+func TestCloseTearsDownConnections(t *testing.T) {
+	cluster := newFakeCluster(&fakePod{name: "a"})
+	r := cluster.proxy(t)
+
+	conn, err := net.Dial("tcp", r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wait))
+	if _, err := fmt.Fprintln(conn, "wait"); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the connection to be forwarded to the pod
+	for start := time.Now(); cluster.connection("a") == nil && time.Since(start) < wait; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var netErr net.Error
+	if _, err := ioutil.ReadAll(conn); errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("expected the connection closed by the proxy")
+	}
+	if podConn := cluster.connection("a"); podConn == nil || !podConn.isClosed() {
+		t.Fatal("expected the connection to the pod closed")
+	}
+	if conn, err := net.Dial("tcp", r.Addr()); err == nil {
+		conn.Close()
+		t.Fatal("expected the proxy to stop listening")
+	}
+}